@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configExcluded are the flags that can't be set from a config file, as
+// they select what the run does rather than how.
+var configExcluded = map[string]bool{
+	"config":       true,
+	"print-config": true,
+	"keygen":       true,
+	"prune":        true,
+}
+
+// listFlag is a flag that can be repeated, collecting every value given.
+// In a config file, it takes a list, each item set on its own.
+type listFlag []string
+
+func (l *listFlag) String() string   { return strings.Join(*l, ",") }
+func (l *listFlag) Get() interface{} { return []string(*l) }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// applyConfig sets the flags of fs from the YAML file at path. Keys are flag
+// names without the leading dash, lists are joined with commas, or set item
+// by item for a listFlag. Flags given on the command line take precedence
+// over the file.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]yaml.Node
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil || configExcluded[name] {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		node := values[name]
+		if _, ok := fs.Lookup(name).Value.(*listFlag); ok && node.Kind == yaml.SequenceNode {
+			for _, item := range node.Content {
+				if err := fs.Set(name, item.Value); err != nil {
+					return fmt.Errorf("%s: %s: %w", path, name, err)
+				}
+			}
+			continue
+		}
+		if err := fs.Set(name, configValue(&node)); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// configValue formats a YAML value the way it would be given as a flag.
+// Scalars are used as written, so 0644 stays an octal file mode.
+func configValue(node *yaml.Node) string {
+	if node.Kind != yaml.SequenceNode {
+		return node.Value
+	}
+	items := make([]string, len(node.Content))
+	for i, item := range node.Content {
+		items[i] = item.Value
+	}
+	return strings.Join(items, ",")
+}
+
+// printConfig writes the effective value of every option of fs as YAML, in
+// the format read by applyConfig.
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	values := map[string]interface{}{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if configExcluded[f.Name] {
+			return
+		}
+		// keep numbers and booleans unquoted, durations in flag syntax
+		values[f.Name] = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			if _, isDuration := g.Get().(time.Duration); !isDuration {
+				values[f.Name] = g.Get()
+			}
+		}
+	})
+	b, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("go-selfupdate", flag.ContinueOnError)
+	fs.String("format", "gzip", "")
+	fs.Int("workers", 6, "")
+	fs.Bool("no-patch", false, "")
+	fs.String("include", "", "")
+	fs.String("file-mode", "0644", "")
+	fs.Bool("prune", false, "")
+	fs.Var(new(listFlag), "meta", "")
+	return fs
+}
+
+func TestApplyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "release.yaml")
+	config := "format: zstd\nworkers: 3\nno-patch: true\ninclude: [linux-*, darwin-*]\nfile-mode: 0600\nmeta: [\"notes=https://example.com/a,b\", commit=abc]\n"
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := newTestFlagSet()
+	if err := fs.Parse([]string{"-workers", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"format":    "zstd",
+		"workers":   "2", // the command line wins
+		"no-patch":  "true",
+		"include":   "linux-*,darwin-*",
+		"file-mode": "0600",
+	}
+	for name, value := range want {
+		if got := fs.Lookup(name).Value.String(); got != value {
+			t.Errorf("%s = %q; want %q", name, got, value)
+		}
+	}
+
+	if got := fs.Lookup("meta").Value.(*listFlag); len(*got) != 2 || (*got)[0] != "notes=https://example.com/a,b" {
+		t.Errorf("meta = %q; want each item set on its own", *got)
+	}
+
+	var out bytes.Buffer
+	if err := printConfig(&out, fs); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "workers: 2\n") || strings.Contains(out.String(), "prune") {
+		t.Errorf("Unexpected effective config:\n%s", out.String())
+	}
+
+	for _, bad := range []string{"bogus: 1\n", "prune: true\n", "workers: many\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfig(newTestFlagSet(), path); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
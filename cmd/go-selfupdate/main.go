@@ -1,174 +1,85 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
-	"crypto/sha256"
+	"crypto/ed25519"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sync"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/kr/binarydist"
+	"github.com/dongshuzhao/go-selfupdate/selfupdate/generate"
 )
 
-var version, genDir string
+// logger is used for all output of the tool.
+var logger = generate.NewLogger(os.Stdout, os.Stderr, generate.LevelNormal)
 
-type current struct {
-	Version string
-	Sha256  []byte
-}
-
-func generateSha256(path string) []byte {
-	h := sha256.New()
-	b, err := os.ReadFile(path)
-	if err != nil {
-		fmt.Println(err)
-	}
-	h.Write(b)
-	sum := h.Sum(nil)
-	return sum
-	// return base64.URLEncoding.EncodeToString(sum)
-}
-
-type gzReader struct {
-	z, r io.ReadCloser
-}
+// generatorVersion identifies this tool in the manifest. It can be set at
+// build time with -ldflags "-X main.generatorVersion=1.2.3", otherwise the
+// module version from the build info is used.
+var generatorVersion = "dev"
 
-func (g *gzReader) Read(p []byte) (int, error) {
-	return g.z.Read(p)
-}
+// generatedAt is captured once per run so that all manifests written by a
+// single invocation agree. See resolveGeneratedAt for overrides.
+var generatedAt = time.Now().UTC().Truncate(time.Second)
 
-func (g *gzReader) Close() error {
-	g.z.Close()
-	return g.r.Close()
-}
-
-func newGzReader(r io.ReadCloser) io.ReadCloser {
-	var err error
-	g := new(gzReader)
-	g.r = r
-	g.z, err = gzip.NewReader(r)
-	if err != nil {
-		panic(err)
+// resolveGeneratorVersion returns generatorVersion, falling back to the
+// module version recorded in the binary when it wasn't set at build time.
+func resolveGeneratorVersion() string {
+	if generatorVersion != "dev" {
+		return generatorVersion
 	}
-	return g
-}
-
-func createUpdate(path string, platform string) {
-	os.MkdirAll(filepath.Join(genDir, version), 0755)
-
-	var buf bytes.Buffer
-	w := gzip.NewWriter(&buf)
-	f, err := os.ReadFile(path)
-	if err != nil {
-		panic(err)
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
 	}
-	w.Write(f)
-	w.Close() // You must close this first to flush the bytes to the buffer.
-	err = os.WriteFile(filepath.Join(genDir, version, platform+".gz"), buf.Bytes(), 0755)
-
-	processUpdate := func(file fs.DirEntry) {
-		fmt.Printf("Processing %s\n", file.Name())
-		if !file.IsDir() {
-			fmt.Printf("%s is not a directory, skipped\n", file.Name())
-			return
-		}
-		if file.Name() == version {
-			fmt.Printf("%s is current version, skipped\n", file.Name())
-			return
-		}
-
-		os.Mkdir(filepath.Join(genDir, file.Name(), version), 0755)
+	return generatorVersion
+}
 
-		fName := filepath.Join(genDir, file.Name(), platform+".gz")
-		old, err := os.Open(fName)
+// resolveGeneratedAt returns the timestamp to record in manifests. flagValue
+// (RFC3339) takes precedence, then the SOURCE_DATE_EPOCH environment variable
+// (unix seconds) used by reproducible builds, then the time of this run.
+func resolveGeneratedAt(flagValue string) (time.Time, error) {
+	if flagValue != "" {
+		t, err := time.Parse(time.RFC3339, flagValue)
 		if err != nil {
-			// Don't have an old release for this os/arch, continue on
-			fmt.Printf("%s found no release for this os/arch, skipped\n", file.Name())
-			return
+			return time.Time{}, fmt.Errorf("invalid -generated-at %q: %w", flagValue, err)
 		}
-
-		fName = filepath.Join(genDir, version, platform+".gz")
-		newF, err := os.Open(fName)
+		return t.UTC(), nil
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Can't open %s: error: %s\n", fName, err)
-			os.Exit(1)
-		}
-
-		ar := newGzReader(old)
-		defer ar.Close()
-		br := newGzReader(newF)
-		defer br.Close()
-		patch := new(bytes.Buffer)
-		if err := binarydist.Diff(ar, br, patch); err != nil {
-			fmt.Printf("Failed to bsdiff %s, exiting\n", file.Name())
-			panic(err)
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", epoch, err)
 		}
-		os.WriteFile(filepath.Join(genDir, file.Name(), version, platform), patch.Bytes(), 0755)
-		fmt.Printf("Done with %s\n", file.Name())
-	}
-
-	files, err := os.ReadDir(genDir)
-	if err != nil {
-		fmt.Println(err)
-	}
-
-	// spin up parallel workers to process the files:
-	numCPUs := runtime.NumCPU()
-	numWorkers := numCPUs
-	if numWorkers > 6 {
-		numWorkers = 6
-	}
-	fmt.Printf("Number of CPUs: %d\n", numCPUs)
-	fmt.Printf("Number of workers: %d\n", numWorkers)
-	filesChan := make(chan fs.DirEntry)
-	var wg sync.WaitGroup
-	wg.Add(numWorkers)
-	for i := 0; i < numWorkers; i++ {
-		go func() {
-			for file := range filesChan {
-				processUpdate(file)
-			}
-			wg.Done()
-		}()
-	}
-	for _, file := range files {
-		filesChan <- file
-	}
-	close(filesChan)
-	wg.Wait()
-
-	c := current{Version: version, Sha256: generateSha256(path)}
-
-	b, err := json.MarshalIndent(c, "", "    ")
-	if err != nil {
-		fmt.Println("error:", err)
-	}
-	err = os.WriteFile(filepath.Join(genDir, platform+".json"), b, 0755)
-	if err != nil {
-		panic(err)
+		return time.Unix(sec, 0).UTC(), nil
 	}
+	return generatedAt, nil
 }
 
 func printUsage() {
 	fmt.Println("")
 	fmt.Println("Positional arguments:")
-	fmt.Println("\tSingle platform: go-selfupdate myapp 1.2")
-	fmt.Println("\tCross platform: go-selfupdate /tmp/mybinares/ 1.2")
-}
-
-func createBuildDir() {
-	os.MkdirAll(genDir, 0755)
+	fmt.Println("\tSingle platform: go-selfupdate myapp 1.2.0")
+	fmt.Println("\tCross platform: go-selfupdate /tmp/mybinares/ 1.2.0")
+	fmt.Println("\tCross platform from a glob: go-selfupdate 'build/myapp-*' 1.2.0")
+	fmt.Println("\tFrom stdin: go-selfupdate -platform linux-amd64 - 1.2.0")
+	fmt.Println("\tVersion from the binary: go-selfupdate -version-from buildinfo myapp")
+	fmt.Println("\tPrune old versions: go-selfupdate -prune -keep 5")
+	fmt.Println("\tVerify a published tree: go-selfupdate -o public verify")
+	fmt.Println("\tBeta channel: go-selfupdate -channel beta myapp 1.3.0-beta.1")
+	fmt.Println("\tGenerate a signing key: go-selfupdate -keygen release.key")
+	fmt.Println("\tOptions from a file: go-selfupdate -config release.yaml myapp 1.2.0")
 }
 
 func main() {
 	outputDirFlag := flag.String("o", "public", "Output directory for writing updates")
+	channelFlag := flag.String("channel", "", "Release channel, e.g. beta. Publishes to (or with -prune, prunes) a subdirectory of the output directory with its own manifests and patches.")
 
 	var defaultPlatform string
 	goos := os.Getenv("GOOS")
@@ -180,36 +91,373 @@ func main() {
 	}
 	platformFlag := flag.String("platform", defaultPlatform,
 		"Target platform in the form OS-ARCH. Defaults to running os/arch or the combination of the environment variables GOOS and GOARCH if both are set.")
+	compressionFlag := flag.String("compression", "default", "Gzip level for the full binary: 0-9, none, fast, best or default")
+	formatFlag := flag.String("format", "gzip", "Compression format for the full binary: gzip, pgzip for the same gzip compressed on every core, faster for large binaries, or zstd")
+	hashFlag := flag.String("hash", "sha256", "Checksum algorithm recorded in the manifest: sha256, sha512 or blake2b")
+	inputCompressedFlag := flag.Bool("input-compressed", false, "The input binaries are gzip compressed. Their content is published, and with -format gzip they are stored as they are.")
+	workersFlag := flag.Int("workers", generate.DefaultWorkers(),
+		"Number of patches to generate in parallel. Each worker keeps two decompressed binaries and a patch in memory, so lower this on memory-constrained machines.")
+	platformWorkersFlag := flag.Int("platform-workers", generate.DefaultWorkers(), "Number of platforms processed in parallel in directory mode. Patches are still limited to -workers at a time overall.")
+	keepUncompressedFlag := flag.Bool("keep-uncompressed", false, "Also write the binary uncompressed, for servers compressing at the HTTP layer")
+	excludeOldFlag := flag.String("exclude-old", "", "Comma separated glob patterns of older versions, like pulled ones, not to generate patches from")
+	noPatchFlag := flag.Bool("no-patch", false, "Only write the full binary and manifest, don't generate patches from older versions")
+	maxPatchRatioFlag := flag.Float64("max-patch-ratio", 0.9, "Skip patches larger than this fraction of the compressed full binary, whose clients download the full binary instead. 0 means no limit.")
+	sameMajorPatchesFlag := flag.Bool("same-major-patches", false, "Only generate patches from older versions with the same semver major version, so clients updating across a major version download the full binary")
+	diffDepthFlag := flag.Int("diff-depth", 0, "Only generate patches from the N newest prior versions (by semver if all version directories are semver, otherwise by modification time). 0 means all.")
+	baseVersionFlag := flag.String("base-version", "", "Comma separated prior versions to generate patches from, instead of every version in the output directory, e.g. the release a hotfix is for. Each must exist there.")
+	includeFlag := flag.String("include", "", "Comma separated glob patterns; in directory mode only matching file names are used as platform binaries")
+	excludeFlag := flag.String("exclude", "", "Comma separated glob patterns; in directory mode matching file names are skipped")
+	checkPlatformFlag := flag.String("check-platform", "", "Compare the platform of each binary with its ELF, Mach-O or PE header: warn, or error to fail the platform on a mismatch")
+	matrixFlag := flag.Bool("matrix", false, "When the input is a directory, publish every binary below it, like build/linux/amd64/myapp, taking the platform from the directories leading to it")
+	matrixSepFlag := flag.String("matrix-sep", "-", "With -matrix, the string joining the directory names into the platform")
+	platformRegexFlag := flag.String("platform-regex", "", "When the input is a glob like 'build/myapp-*', regular expression finding the platform in each file name, in a group named platform, groups named os and arch, or the first group. By default names must end in OS-ARCH or OS_ARCH, optionally followed by .exe.")
+	verifyOldFlag := flag.Bool("verify-old", false, "Check the full binary of each older version against the checksum in its patch index before generating a patch from it, skipping versions that don't match")
+	skipCorruptFlag := flag.Bool("skip-corrupt", false, "Leave older versions whose full binary can't be decompressed out of patch generation, instead of failing before anything is written")
+	privateKeyFlag := flag.String("private-key", "", "PEM encoded ed25519 private key used to sign the manifests, or env:NAME to read it, PEM or base64 encoded, from the environment variable NAME")
+	keygenFlag := flag.String("keygen", "", "Generate an ed25519 key pair, writing the private key to this path and the public key to path.pub, then exit")
+	pruneFlag := flag.Bool("prune", false, "Remove old version directories from the output directory instead of generating an update, see -keep and -keep-for")
+	keepFlag := flag.Int("keep", 0, "With -prune, keep the N newest versions")
+	keepForFlag := flag.Duration("keep-for", 0, "With -prune, keep versions modified within this duration, e.g. 720h")
+	verboseFlag := flag.Bool("v", false, "Verbose output, including sizes and timings")
+	quietFlag := flag.Bool("q", false, "Quiet output, only errors and the final summary")
+	patchFormatFlag := flag.String("patch-format", "none", "Compress the patches with gzip or zstd on top of the diff's own compression; none writes them raw")
+	diffAlgoFlag := flag.String("diff-algo", "bsdiff", "Make patches with bsdiff, which every client applies, or zstd, much faster on large binaries but applied only by clients supporting it")
+	strictPatchesFlag := flag.Bool("strict-patches", false, "Fail instead of skipping a patch that doesn't reproduce the new binary when applied")
+	appcastFlag := flag.String("appcast", "", "URL the output directory is served from. If set, an appcast.xml feed for Sparkle is written listing the newest full binary of every platform, signed with -private-key if given.")
+	s3Flag := flag.String("s3", "", "Upload the files written to s3://bucket/prefix once the run is done, manifests last. Credentials, region and endpoint are read from the standard AWS_* environment variables.")
+	layoutFlag := flag.String("layout", "", "Comma separated file=template pairs naming the files as uploaded and in the appcast, e.g. binary={platform}-{version},patch={platform}-{old}-{version}. The files are manifest, version-manifest, binary, patch and patch-index, the placeholders {version}, {platform} and {old}. Clients must set the same Updater.Layout.")
+	githubFlag := flag.String("github", "", "Attach the files written as assets to a release of this owner/name GitHub repository once the run is done, manifests last. The token is read from GITHUB_TOKEN or GH_TOKEN.")
+	githubTagFlag := flag.String("github-tag", "", "With -github, the tag of the release, created if missing. Defaults to the version prefixed with v.")
+	timingsFlag := flag.Bool("timings", false, "Record how long compressing, diffing and writing took, with the throughput, in the -summary-json output. -v logs them either way.")
+	summaryJSONFlag := flag.String("summary-json", "", "Write a JSON summary of the run to this path, or to stdout if -, even if the run fails. With -, the progress output goes to stderr.")
+	skipSpaceCheckFlag := flag.Bool("skip-space-check", false, "Don't check that the output directory's filesystem has room for the run before writing anything")
+	checksumsFlag := flag.Bool("checksums", false, "Write a SHA256SUMS file covering every file produced by the run to the output directory")
+	fileModeFlag := flag.String("file-mode", "0644", "Octal permissions of the written files; directories always use 0755")
+	noNormalizeFlag := flag.Bool("no-normalize-platform", false, "Keep architecture aliases like x86_64 or aarch64 in platform names instead of renaming them to their GOARCH")
+	followSymlinksFlag := flag.Bool("follow-symlinks", false, "Publish the targets of symlinks in the input directory instead of skipping them")
+	skipUnchangedFlag := flag.Bool("skip-unchanged", false, "Skip platforms whose binary is identical to the currently published one instead of only warning")
+	orderedFlag := flag.Bool("ordered", false, "Keep the log of platforms and patches processed in parallel in input order, so the output of two runs can be compared")
+	rolloutFlag := flag.Int("rollout", 100, "Percentage of installations, from 0 to 100, offered the version for a staged rollout. Run again with the same binary and a higher percentage to widen it; only the manifests are rewritten. Without -rollout, publishing the published version again keeps its percentage.")
+	var metaFlag listFlag
+	flag.Var(&metaFlag, "meta", "Additional key=value field written to the Metadata of the manifest, like a release notes URL or commit. Repeat for several.")
+	minFromVersionFlag := flag.String("min-from-version", "", "Oldest version clients can update from directly. Clients running an older one get an error telling them to update to this version first, and no patches are generated from older versions.")
+	forceFlag := flag.Bool("force", false, "Overwrite the artifacts of a version that was already generated for the platform")
+	incrementalFlag := flag.Bool("incremental", false, "Republish a version that was already generated, keeping the patches whose old and new binaries are unchanged, e.g. to add a platform")
+	dryRunFlag := flag.Bool("dry-run", false, "Report the files that would be written, with their sizes, without writing anything")
+	versionFromFlag := flag.String("version-from", "", "Read the version from the binary instead of the version argument, which becomes optional: buildinfo for the module version Go records, or regex:EXPR for the first group of EXPR matched against the binary. The argument is used if this fails, and must match otherwise.")
+	allowAnyVersionFlag := flag.Bool("allow-any-version", false, "Accept a version argument that isn't semver. Versions are then ordered by modification time where order matters.")
+	versionRegexFlag := flag.String("version-regex", "", "Regular expression the names of version directories in the output directory match in full, the version argument included; other directories aren't diffed from. By default they have to be semver, unless -allow-any-version is set.")
+	generatedAtFlag := flag.String("generated-at", "", "RFC3339 timestamp recorded as GeneratedAt in the manifest. Defaults to SOURCE_DATE_EPOCH if set, otherwise the current time.")
+
+	configFlag := flag.String("config", "", "YAML file setting any of the other options, keyed by flag name without the dash. Flags on the command line take precedence.")
+	printConfigFlag := flag.Bool("print-config", false, "Print the effective options, after applying -config, as YAML and exit")
 
 	flag.Parse()
-	if flag.NArg() < 2 {
+	if *configFlag != "" {
+		if err := applyConfig(flag.CommandLine, *configFlag); err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(2)
+		}
+	}
+	if *printConfigFlag {
+		if err := printConfig(os.Stdout, flag.CommandLine); err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// "verify" is a subcommand, unless it names the binary of -version-from
+	verify := flag.NArg() == 1 && flag.Arg(0) == "verify" && *versionFromFlag == ""
+	if flag.NArg() < 2 && !(flag.NArg() == 1 && *versionFromFlag != "") && !*pruneFlag && *keygenFlag == "" && !verify {
 		flag.Usage()
 		printUsage()
 		os.Exit(0)
 	}
 
-	platform := *platformFlag
-	appPath := flag.Arg(0)
-	version = flag.Arg(1)
-	genDir = *outputDirFlag
+	logLevel := generate.LevelNormal
+	switch {
+	case *verboseFlag && *quietFlag:
+		logger.Errorf("-v and -q can't be used together")
+		os.Exit(2)
+	case *verboseFlag:
+		logLevel = generate.LevelVerbose
+	case *quietFlag:
+		logLevel = generate.LevelQuiet
+	}
+	out := io.Writer(os.Stdout)
+	if *summaryJSONFlag == "-" {
+		// keep stdout for the JSON
+		out = os.Stderr
+	}
+	logger = generate.NewLogger(out, os.Stderr, logLevel)
+
+	if *workersFlag > 4*runtime.NumCPU() {
+		logger.Warnf("-workers %d is much higher than the %d available CPUs", *workersFlag, runtime.NumCPU())
+	}
+	if *workersFlag < 1 {
+		logger.Errorf("invalid -workers %d: must be at least 1", *workersFlag)
+		os.Exit(2)
+	}
+	if *platformWorkersFlag < 1 {
+		logger.Errorf("invalid -platform-workers %d: must be at least 1", *platformWorkersFlag)
+		os.Exit(2)
+	}
 
-	createBuildDir()
+	mode, err := strconv.ParseUint(*fileModeFlag, 8, 32)
+	if err != nil || mode > 0777 {
+		logger.Errorf("invalid -file-mode %q: want octal permissions like 0644", *fileModeFlag)
+		os.Exit(2)
+	}
 
-	// If dir is given create update for each file
-	fi, err := os.Stat(appPath)
+	include, err := generate.ParsePatterns(*includeFlag)
+	if err != nil {
+		logger.Errorf("-include: %s", err)
+		os.Exit(2)
+	}
+	exclude, err := generate.ParsePatterns(*excludeFlag)
 	if err != nil {
-		panic(err)
+		logger.Errorf("-exclude: %s", err)
+		os.Exit(2)
+	}
+	excludeOld, err := generate.ParsePatterns(*excludeOldFlag)
+	if err != nil {
+		logger.Errorf("-exclude-old: %s", err)
+		os.Exit(2)
 	}
 
-	if fi.IsDir() {
-		files, err := os.ReadDir(appPath)
-		if err == nil {
-			for _, file := range files {
-				createUpdate(filepath.Join(appPath, file.Name()), file.Name())
+	metadata, err := generate.ParseMetadata(metaFlag)
+	if err != nil {
+		logger.Errorf("-meta: %s", err)
+		os.Exit(2)
+	}
+
+	layout, err := generate.ParseLayout(*layoutFlag)
+	if err != nil {
+		logger.Errorf("-layout: %s", err)
+		os.Exit(2)
+	}
+
+	var baseVersions []string
+	if *baseVersionFlag != "" {
+		baseVersions = strings.Split(*baseVersionFlag, ",")
+	}
+
+	generatedAt, err := resolveGeneratedAt(*generatedAtFlag)
+	if err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(2)
+	}
+
+	if *keygenFlag != "" {
+		id, err := generate.GenerateKeys(*keygenFlag)
+		if err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(1)
+		}
+		logger.Summaryf("Wrote private key %s and public key %s.pub (key ID %s)", *keygenFlag, *keygenFlag, id)
+		return
+	}
+	var signingKey ed25519.PrivateKey
+	if name, ok := strings.CutPrefix(*privateKeyFlag, "env:"); ok {
+		signingKey, err = generate.LoadPrivateKeyEnv(name)
+	} else if *privateKeyFlag != "" {
+		signingKey, err = generate.LoadPrivateKey(*privateKeyFlag)
+	}
+	if err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(2)
+	}
+
+	if *pruneFlag {
+		opts := generate.PruneOptions{
+			OutputDir:       *outputDirFlag,
+			Channel:         *channelFlag,
+			Keep:            *keepFlag,
+			KeepFor:         *keepForFlag,
+			VersionRegex:    *versionRegexFlag,
+			AllowAnyVersion: *allowAnyVersionFlag,
+			DryRun:          *dryRunFlag,
+			Logger:          logger,
+		}
+		if signingKey != nil {
+			// re-signs the manifests rewritten without pruned patches
+			opts.Signer = signingKey
+		}
+		if err := generate.Prune(opts); err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if verify {
+		err := generate.Verify(generate.VerifyOptions{
+			OutputDir: *outputDirFlag,
+			Channel:   *channelFlag,
+			Logger:    logger,
+		})
+		if err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	appPath := flag.Arg(0)
+	if appPath == "-" {
+		platformSet := false
+		flag.Visit(func(f *flag.Flag) {
+			platformSet = platformSet || f.Name == "platform"
+		})
+		if !platformSet {
+			logger.Errorf("-platform must be given when reading the binary from stdin")
+			os.Exit(2)
+		}
+	}
+	version := flag.Arg(1)
+	if *versionFromFlag != "" {
+		src, err := generate.ParseVersionSource(*versionFromFlag)
+		if err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(2)
+		}
+		found, err := src.InputVersion(appPath)
+		switch {
+		case err == nil && version != "" && found != version:
+			logger.Errorf("the binary has version %s, but %s was given", found, version)
+			os.Exit(2)
+		case err == nil:
+			version = found
+			logger.Printf("Read version %s from the binary", version)
+		case version != "":
+			logger.Warnf("can't read the version from the binary, using %s: %s", version, err)
+		default:
+			logger.Errorf("can't read the version from the binary: %s", err)
+			os.Exit(2)
+		}
+	}
+
+	// without -rollout, publishing the published version again keeps its
+	// rollout instead of widening it to everyone
+	var rollout *int
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "rollout" {
+			rollout = rolloutFlag
+		}
+	})
+
+	var upload generate.Uploader
+	switch {
+	case *s3Flag != "" && *githubFlag != "":
+		logger.Errorf("-s3 and -github can't be combined")
+		os.Exit(2)
+	case *s3Flag != "":
+		s3, err := generate.NewS3Uploader(*s3Flag)
+		if err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(2)
+		}
+		upload = s3
+	case *githubFlag != "":
+		tag := *githubTagFlag
+		if tag == "" {
+			tag = "v" + strings.TrimPrefix(version, "v")
+		}
+		gh, err := generate.NewGitHubUploader(*githubFlag, tag)
+		if err != nil {
+			logger.Errorf("%s", err)
+			os.Exit(2)
+		}
+		upload = gh
+	}
+
+	opts := generate.Options{
+		InputPath:           appPath,
+		Version:             version,
+		OutputDir:           *outputDirFlag,
+		Channel:             *channelFlag,
+		Platform:            *platformFlag,
+		Format:              *formatFlag,
+		Compression:         *compressionFlag,
+		Hash:                *hashFlag,
+		InputCompressed:     *inputCompressedFlag,
+		Timings:             *timingsFlag,
+		Workers:             *workersFlag,
+		PlatformWorkers:     *platformWorkersFlag,
+		NoPatch:             *noPatchFlag,
+		KeepUncompressed:    *keepUncompressedFlag,
+		DiffDepth:           *diffDepthFlag,
+		BaseVersions:        baseVersions,
+		MaxPatchRatio:       *maxPatchRatioFlag,
+		SameMajorPatches:    *sameMajorPatchesFlag,
+		StrictPatches:       *strictPatchesFlag,
+		VerifyOld:           *verifyOldFlag,
+		SkipCorrupt:         *skipCorruptFlag,
+		PatchFormat:         *patchFormatFlag,
+		DiffAlgo:            *diffAlgoFlag,
+		Include:             include,
+		Exclude:             exclude,
+		ExcludeOld:          excludeOld,
+		PlatformRegex:       *platformRegexFlag,
+		Matrix:              *matrixFlag,
+		MatrixSep:           *matrixSepFlag,
+		CheckPlatform:       *checkPlatformFlag,
+		FollowSymlinks:      *followSymlinksFlag,
+		NoNormalizePlatform: *noNormalizeFlag,
+		Force:               *forceFlag,
+		Incremental:         *incrementalFlag,
+		SkipUnchanged:       *skipUnchangedFlag,
+		Rollout:             rollout,
+		Metadata:            metadata,
+		MinFromVersion:      *minFromVersionFlag,
+		Ordered:             *orderedFlag,
+		DryRun:              *dryRunFlag,
+		SkipSpaceCheck:      *skipSpaceCheckFlag,
+		Checksums:           *checksumsFlag,
+		AppcastURL:          *appcastFlag,
+		Upload:              upload,
+		Layout:              layout,
+		FileMode:            os.FileMode(mode),
+		SigningKey:          signingKey,
+		GeneratedAt:         generatedAt,
+		GeneratorVersion:    resolveGeneratorVersion(),
+		AllowAnyVersion:     *allowAnyVersionFlag,
+		VersionRegex:        *versionRegexFlag,
+		Logger:              logger,
+	}
+	if err := opts.Validate(); err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(2)
+	}
+
+	summary, err := generate.GenerateUpdateSummary(opts)
+	if *summaryJSONFlag != "" {
+		if errSummary := writeSummary(*summaryJSONFlag, summary); errSummary != nil {
+			logger.Errorf("can't write summary: %s", errSummary)
+			if err == nil {
+				os.Exit(1)
 			}
-			os.Exit(0)
 		}
 	}
+	if err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+	if !*dryRunFlag {
+		logger.Summaryf("Generated version %s in %s", version, filepath.Join(*outputDirFlag, *channelFlag))
+	}
+}
 
-	createUpdate(appPath, platform)
+// writeSummary writes s as JSON to path, or to stdout if path is "-".
+func writeSummary(path string, s generate.Summary) error {
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
 }
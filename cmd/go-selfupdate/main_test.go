@@ -1,6 +1,33 @@
 package main
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestUpdater(t *testing.T) {
 }
+
+func TestResolveGeneratedAt(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	got, err := resolveGeneratedAt("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1700000000, 0).UTC(); !got.Equal(want) {
+		t.Errorf("resolveGeneratedAt with SOURCE_DATE_EPOCH = %s; want %s", got, want)
+	}
+
+	got, err = resolveGeneratedAt("2024-01-02T03:04:05+01:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2024-01-02T02:04:05Z"; got.Format(time.RFC3339) != want {
+		t.Errorf("resolveGeneratedAt with flag = %s; want %s", got.Format(time.RFC3339), want)
+	}
+
+	if _, err := resolveGeneratedAt("yesterday"); err == nil {
+		t.Error("Expected an error for an invalid timestamp")
+	}
+}
@@ -2,4 +2,12 @@ module github.com/dongshuzhao/go-selfupdate
 
 go 1.22
 
-require github.com/kr/binarydist v0.1.0
+require (
+	github.com/klauspost/compress v1.17.9
+	github.com/klauspost/pgzip v1.2.6
+	github.com/kr/binarydist v0.1.0
+	golang.org/x/crypto v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.21.0 // indirect
@@ -0,0 +1,69 @@
+package selfupdate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cachePath returns where CacheDownloads keeps the binary of Info.Version,
+// in Dir next to the part files of resumed downloads. The name includes
+// the checksum, so the binary of a version republished with different
+// content is never taken for it.
+func (u *Updater) cachePath() string {
+	return filepath.Join(u.getExecRelativeDir(u.Dir), fmt.Sprintf(".%s-%s-%x.bin", plat, u.Info.Version, u.Info.Hash.Value))
+}
+
+// cachedBin returns the binary of Info.Version kept by an earlier attempt,
+// or nil if CacheDownloads is off or there is none. A kept binary that no
+// longer matches the checksum in Info is removed.
+func (u *Updater) cachedBin() []byte {
+	if !u.CacheDownloads || len(u.Info.Hash.Value) == 0 {
+		return nil
+	}
+	path := u.cachePath()
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	if verifyHash(bin, u.Info.Hash.Algo, u.Info.Hash.Value) != nil {
+		_ = os.Remove(path)
+		return nil
+	}
+	return bin
+}
+
+// cacheBin keeps the verified binary bin of Info.Version with
+// CacheDownloads, for another attempt if installing it fails. The cache is
+// only an optimization, so failing to write it is not an error.
+func (u *Updater) cacheBin(bin []byte) {
+	if !u.CacheDownloads || len(u.Info.Hash.Value) == 0 {
+		return
+	}
+	path := u.cachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	// written aside and renamed, so a crash never leaves a partial binary
+	// under the final name
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bin, 0600); err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+	}
+}
+
+// clearCache removes every binary kept by CacheDownloads, including those
+// of versions superseded before they were installed.
+func (u *Updater) clearCache() {
+	if !u.CacheDownloads {
+		return
+	}
+	paths, _ := filepath.Glob(filepath.Join(u.getExecRelativeDir(u.Dir), "."+plat+"-*.bin"))
+	for _, path := range paths {
+		_ = os.Remove(path)
+	}
+}
@@ -0,0 +1,168 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// maxPatchChain is the most patches applied one after another to reach the
+// new version.
+const maxPatchChain = 4
+
+// patchIndex lists the patches to Version, as written by the generator to
+// <CmdName>/<Version>/<os>-<arch>.patches.json.
+type patchIndex struct {
+	Version string
+	Hash    struct {
+		Algo  string
+		Value []byte
+	}
+	Compression string
+	DiffAlgo    string
+	Patches     []struct {
+		From   string
+		Length int64
+		Sha256 []byte // Checksum of the patch file as stored
+	}
+}
+
+// chainStep is a patch from From to the version of index.
+type chainStep struct {
+	from   string
+	index  *patchIndex
+	length int64  // Size of the patch
+	sum    []byte // sha256 of the patch as stored, nil if unknown
+}
+
+// chainRoute is a way from a version to Info.Version.
+type chainRoute struct {
+	steps []chainStep
+	cost  int64 // Total size of the patches
+}
+
+// planPatchChain finds the fewest patches leading from CurrentVersion to
+// Info.Version, and the smallest among those, by following the patch
+// indexes back from Info.Version. Versions whose index lacks the checksum
+// of their binary, written by older generators, are passed over as the
+// result of patching to them can't be verified. It returns nil if there is
+// no chain of at most maxPatchChain patches, or if it isn't smaller than
+// the full binary.
+func (u *Updater) planPatchChain(ctx context.Context) ([]chainStep, error) {
+	routes := map[string]chainRoute{u.Info.Version: {}}
+	frontier := []string{u.Info.Version}
+	for depth := 1; depth <= maxPatchChain && len(frontier) > 0; depth++ {
+		var next []string
+		for _, to := range frontier {
+			index, err := u.fetchPatchIndex(ctx, to)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				continue
+			}
+			if index.Hash.Algo == "" {
+				continue
+			}
+			for _, p := range index.Patches {
+				r := chainRoute{
+					steps: append([]chainStep{{from: p.From, index: index, length: p.Length, sum: p.Sha256}}, routes[to].steps...),
+					cost:  routes[to].cost + p.Length,
+				}
+				prev, seen := routes[p.From]
+				switch {
+				case !seen:
+					next = append(next, p.From)
+				case len(prev.steps) < depth || prev.cost <= r.cost:
+					continue
+				}
+				routes[p.From] = r
+			}
+		}
+		if r, ok := routes[u.CurrentVersion]; ok {
+			if u.Info.CompressedLength > 0 && r.cost >= u.Info.CompressedLength {
+				return nil, nil
+			}
+			return r.steps, nil
+		}
+		frontier = next
+	}
+	return nil, nil
+}
+
+// fetchPatchIndex fetches the patch index of version.
+func (u *Updater) fetchPatchIndex(ctx context.Context, version string) (*patchIndex, error) {
+	b, err := u.downloadManifest(ctx, u.DiffURL, u.layoutPath(u.Layout.PatchIndexPath(version, plat)))
+	if err != nil {
+		return nil, err
+	}
+	var index patchIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// fetchAndVerifyPatchChain updates old to Info.Version through a chain of
+// patches, see planPatchChain, for clients so far behind that there is no
+// patch straight to it. Every intermediate binary is verified against the
+// checksum in its patch index, the result against the manifest.
+func (u *Updater) fetchAndVerifyPatchChain(ctx context.Context, old io.Reader) ([]byte, error) {
+	chain, err := u.planPatchChain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if chain == nil {
+		return nil, errNoPatch
+	}
+
+	bin, err := io.ReadAll(&ctxReader{ctx, old})
+	if err != nil {
+		return nil, err
+	}
+	// progress is reported over the whole chain
+	var done, total int64
+	for _, step := range chain {
+		total += step.length
+	}
+	for _, step := range chain {
+		compression := step.index.Compression
+		if compression == "" {
+			compression = "none"
+		}
+		u.phase(PhaseDownloading)
+		// the patch is checked as stored, before spending time on it
+		patch, err := u.downloadPatch(ctx, step.from, step.index.Version, compression, step.sum, u.reporter(done, total))
+		if err != nil {
+			return nil, err
+		}
+		done += step.length
+		u.phase(PhaseApplying)
+		next, err := applyPatch(ctx, step.index.DiffAlgo, bytes.NewReader(bin), patch)
+		if err != nil {
+			return nil, fmt.Errorf("patching %s to %s: %w", step.from, step.index.Version, err)
+		}
+		u.phase(PhaseVerifying)
+		if err := verifyHash(next, step.index.Hash.Algo, step.index.Hash.Value); err != nil {
+			return nil, fmt.Errorf("patching %s to %s: %w", step.from, step.index.Version, err)
+		}
+		bin = next
+	}
+	if err := verifyHash(bin, u.Info.Hash.Algo, u.Info.Hash.Value); err != nil {
+		return nil, err
+	}
+	return bin, nil
+}
+
+// decompressAll returns b decompressed with compression, see
+// compressionExt.
+func decompressAll(b []byte, compression string) ([]byte, error) {
+	dr, err := decompress(bytes.NewReader(b), compression)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	return io.ReadAll(dr)
+}
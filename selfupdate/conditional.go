@@ -0,0 +1,128 @@
+package selfupdate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cachedManifest holds the Validators of the manifest at Path, which
+// offered no update to CurrentVersion Current.
+type cachedManifest struct {
+	Path    string
+	Current string
+	Validators
+}
+
+// checkLatest fetches the latest manifest and reports whether Info.Version
+// should be installed, see offered. With a ConditionalRequester, the
+// manifest isn't downloaded again while it is unchanged since a check
+// found no update in it, and there is no update then either.
+func (u *Updater) checkLatest(ctx context.Context) (bool, error) {
+	path := u.layoutPath(u.Layout.ManifestPath(plat))
+	cached := u.cachedManifest()
+	var v Validators
+	if cached != nil && cached.Path == path && cached.Current == u.CurrentVersion {
+		v = cached.Validators
+	}
+	next, err := u.fetchInfoIfModified(ctx, path, v)
+	if err == ErrNotModified {
+		u.logger().Info("update: manifest unchanged, no update", "path", path, "current", u.CurrentVersion)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	ok, err := u.offered()
+	if err == nil && !ok && next != (Validators{}) {
+		u.cacheManifest(&cachedManifest{Path: path, Current: u.CurrentVersion, Validators: next})
+	} else if cached != nil {
+		u.cacheManifest(nil)
+	}
+	return ok, err
+}
+
+// fetchInfoIfModified is fetchInfo for the manifest at path, unless it
+// still has the Validators v, in which case it returns ErrNotModified. It
+// returns the Validators of the manifest fetched, zero if the Requester
+// isn't a ConditionalRequester.
+func (u *Updater) fetchInfoIfModified(ctx context.Context, path string, v Validators) (Validators, error) {
+	cr, ok := u.requester().(ConditionalRequester)
+	if !ok {
+		return Validators{}, u.fetchManifest(ctx, path)
+	}
+	start := time.Now()
+	u.logger().Info("update: checking for update", "path", path, "current", u.CurrentVersion)
+	var next Validators
+	b, err := withTimeout(ctx, u.manifestTimeout(), "ManifestTimeout", func(ctx context.Context) ([]byte, error) {
+		var b []byte
+		err := u.retry(ctx, path, func() error {
+			return u.fromMirrors(ctx, u.ApiURL, path, func(url string) error {
+				r, nv, err := cr.FetchIfModified(ctx, url, v)
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				b, err = io.ReadAll(&ctxReader{ctx, r})
+				next = nv
+				return err
+			})
+		})
+		return b, err
+	})
+	if err != nil {
+		return Validators{}, err
+	}
+	return next, u.loadManifest(ctx, path, b, start)
+}
+
+// cachedManifest returns the manifest kept by cacheManifest, in
+// ManifestCachePath if set, or nil if there is none.
+func (u *Updater) cachedManifest() *cachedManifest {
+	if u.ManifestCachePath == "" {
+		return u.manifestCache
+	}
+	b, err := os.ReadFile(u.manifestCachePath())
+	if err != nil {
+		return nil
+	}
+	var m cachedManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil
+	}
+	return &m
+}
+
+// cacheManifest keeps m for the next check, in ManifestCachePath if set,
+// or drops the manifest kept if m is nil. The cache only saves downloads,
+// so failing to write it is not an error.
+func (u *Updater) cacheManifest(m *cachedManifest) {
+	if u.ManifestCachePath == "" {
+		u.manifestCache = m
+		return
+	}
+	path := u.manifestCachePath()
+	if m == nil {
+		_ = os.Remove(path)
+		return
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		u.logger().Warn("update: can't keep the manifest's validators", "path", path, "error", err)
+	}
+}
+
+// manifestCachePath returns ManifestCachePath, relative to the directory
+// of the executable unless it is absolute.
+func (u *Updater) manifestCachePath() string {
+	if filepath.IsAbs(u.ManifestCachePath) {
+		return u.ManifestCachePath
+	}
+	return u.getExecRelativeDir(u.ManifestCachePath)
+}
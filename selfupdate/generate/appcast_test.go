@@ -0,0 +1,80 @@
+package generate
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// parsedAppcast reads the feed back, resolving the namespace prefixes
+// appcast writes literally.
+type parsedAppcast struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Version  string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle version"`
+			Checksum struct {
+				Algo string `xml:"algorithm,attr"`
+			} `xml:"https://github.com/dongshuzhao/go-selfupdate checksum"`
+			Enclosure struct {
+				URL         string `xml:"url,attr"`
+				Length      int64  `xml:"length,attr"`
+				Type        string `xml:"type,attr"`
+				OS          string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle os,attr"`
+				EdSignature string `xml:"http://www.andymatuschak.org/xml-namespaces/sparkle edSignature,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+func TestWriteAppcast(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "myapp")
+	opts := Options{OutputDir: dir, SigningKey: key, AppcastURL: "https://updates.example.com/myapp/"}
+	publish(t, opts, "1.0.0")
+	opts.Platform = "darwin-arm64"
+	opts.Format = "zstd"
+	publish(t, opts, "1.1.0")
+
+	b, err := os.ReadFile(filepath.Join(dir, appcastFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed parsedAppcast
+	if err := xml.Unmarshal(b, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Channel.Title != "myapp" || len(feed.Channel.Items) != 2 {
+		t.Fatalf("Unexpected appcast:\n%s", b)
+	}
+
+	want := map[string][3]string{
+		"1.1.0": {"https://updates.example.com/myapp/1.1.0/darwin-arm64.zst", "application/zstd", "macos"},
+		"1.0.0": {"https://updates.example.com/myapp/1.0.0/linux-amd64.gz", "application/gzip", "linux"},
+	}
+	for _, item := range feed.Channel.Items {
+		e := item.Enclosure
+		w := want[item.Version]
+		if e.URL != w[0] || e.Type != w[1] || e.OS != w[2] || item.Checksum.Algo != "sha256" {
+			t.Errorf("Unexpected item %+v", item)
+		}
+		file, err := os.ReadFile(filepath.Join(dir, e.URL[len("https://updates.example.com/myapp/"):]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Length != int64(len(file)) {
+			t.Errorf("%s: expected length %d, got %d", e.URL, len(file), e.Length)
+		}
+		sig, err := base64.StdEncoding.DecodeString(e.EdSignature)
+		if err != nil || !ed25519.Verify(pub, file, sig) {
+			t.Errorf("%s: invalid edSignature %q", e.URL, e.EdSignature)
+		}
+	}
+}
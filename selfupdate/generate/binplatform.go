@@ -0,0 +1,133 @@
+package generate
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// binaryKind is what the header of a binary tells about its platform.
+// ELF doesn't reliably record the OS, so any of several may match.
+type binaryKind struct {
+	format string
+	oses   []string
+	arches []string
+}
+
+// elfOSes are the GOOS values producing ELF binaries.
+var elfOSes = []string{"linux", "android", "freebsd", "netbsd", "openbsd", "dragonfly", "solaris", "illumos"}
+
+// readBinaryKind parses the ELF, Mach-O or PE header of the file at path.
+// It returns false if the file is none of them.
+func readBinaryKind(path string) (binaryKind, bool) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		return binaryKind{format: "ELF", oses: elfOSes, arches: []string{elfArch(f)}}, true
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		return binaryKind{format: "Mach-O", oses: []string{"darwin", "ios"}, arches: []string{machoArch(f.Cpu)}}, true
+	}
+	if f, err := macho.OpenFat(path); err == nil {
+		defer f.Close()
+		k := binaryKind{format: "universal Mach-O", oses: []string{"darwin", "ios"}}
+		for _, a := range f.Arches {
+			k.arches = append(k.arches, machoArch(a.Cpu))
+		}
+		return k, true
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		return binaryKind{format: "PE", oses: []string{"windows"}, arches: []string{peArch(f.Machine)}}, true
+	}
+	return binaryKind{}, false
+}
+
+func elfArch(f *elf.File) string {
+	le := f.ByteOrder == binary.LittleEndian
+	is64 := f.Class == elf.ELFCLASS64
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "amd64"
+	case elf.EM_386:
+		return "386"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_RISCV:
+		return "riscv64"
+	case elf.EM_S390:
+		return "s390x"
+	case elf.EM_LOONGARCH:
+		return "loong64"
+	case elf.EM_PPC64:
+		if le {
+			return "ppc64le"
+		}
+		return "ppc64"
+	case elf.EM_MIPS:
+		arch := "mips"
+		if is64 {
+			arch = "mips64"
+		}
+		if le {
+			arch += "le"
+		}
+		return arch
+	}
+	return strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+}
+
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuArm:
+		return "arm"
+	}
+	return cpu.String()
+}
+
+func peArch(machine uint16) string {
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "amd64"
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return "386"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "arm64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		return "arm"
+	}
+	return fmt.Sprintf("machine %#x", machine)
+}
+
+// checkPlatform compares platform with what the header of the binary at
+// path says, per CheckPlatform. Formats other than ELF, Mach-O and PE
+// can't be checked and pass.
+func (g *generator) checkPlatform(path, platform string) error {
+	k, ok := readBinaryKind(path)
+	if !ok {
+		g.log.Printf("%s is not an ELF, Mach-O or PE binary, platform %s not checked", path, platform)
+		return nil
+	}
+	goos, arch, _ := strings.Cut(platform, "-")
+	if slices.Contains(k.oses, goos) && slices.Contains(k.arches, arch) {
+		return nil
+	}
+	err := fmt.Errorf("%s is a %s binary for %s, not %s", path, k.format, strings.Join(k.arches, ", "), platform)
+	if g.CheckPlatform == "error" {
+		return err
+	}
+	g.log.Warnf("%s", err)
+	return nil
+}
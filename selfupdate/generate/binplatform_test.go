@@ -0,0 +1,49 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGenerateUpdateCheckPlatform(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	native := runtime.GOOS + "-" + runtime.GOARCH
+	other := "windows-" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		other = "linux-" + runtime.GOARCH
+	}
+
+	opts := Options{InputPath: exe, Version: "1.0.0", OutputDir: t.TempDir(), Platform: native, CheckPlatform: "error", SkipSpaceCheck: true}
+	if err := GenerateUpdate(opts); err != nil {
+		t.Errorf("Expected the test binary to match %s, got %v", native, err)
+	}
+
+	opts.OutputDir, opts.Platform = t.TempDir(), other
+	if err := GenerateUpdate(opts); err == nil || !strings.Contains(err.Error(), "not "+other) {
+		t.Errorf("Expected a mismatch for %s, got %v", other, err)
+	}
+	opts.CheckPlatform = "warn"
+	if err := GenerateUpdate(opts); err != nil {
+		t.Errorf("Expected only a warning for %s, got %v", other, err)
+	}
+
+	// files that aren't binaries can't be checked
+	script := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts = Options{InputPath: script, Version: "1.0.0", OutputDir: t.TempDir(), Platform: other, CheckPlatform: "error"}
+	if err := GenerateUpdate(opts); err != nil {
+		t.Errorf("Expected a script to pass, got %v", err)
+	}
+
+	if err := (&Options{InputPath: "x", Version: "1.0.0", OutputDir: "x", CheckPlatform: "fail"}).Validate(); err == nil {
+		t.Error("Expected an error for an invalid platform check")
+	}
+}
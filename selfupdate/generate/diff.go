@@ -0,0 +1,68 @@
+package generate
+
+import (
+	"bytes"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/kr/binarydist"
+)
+
+// diff returns the patch from oldBin to newBin made with algo.
+func diff(algo string, oldBin, newBin []byte) ([]byte, error) {
+	if algo == "zstd" {
+		// compressing the new binary with the old one as dictionary finds
+		// far matches only at the best level
+		e, err := zstd.NewWriter(nil,
+			zstd.WithEncoderDictRaw(0, oldBin),
+			zstd.WithWindowSize(zstdPatchWindow(len(oldBin)+len(newBin))),
+			zstd.WithEncoderLevel(zstd.SpeedBestCompression),
+			zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer e.Close()
+		return e.EncodeAll(newBin, nil), nil
+	}
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader(oldBin), bytes.NewReader(newBin), &patch); err != nil {
+		return nil, err
+	}
+	return patch.Bytes(), nil
+}
+
+// applyPatch applies patch, made with algo, to oldBin.
+func applyPatch(algo string, oldBin, patch []byte) ([]byte, error) {
+	if algo == "zstd" {
+		d, err := zstd.NewReader(nil, zstd.WithDecoderDictRaw(0, oldBin), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		return d.DecodeAll(patch, nil)
+	}
+	var out bytes.Buffer
+	if err := binarydist.Patch(bytes.NewReader(oldBin), &out, bytes.NewReader(patch)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// zstdPatchWindow returns the window size letting the end of n bytes of
+// dictionary and input refer back to their start, within the limits of
+// zstd.
+func zstdPatchWindow(n int) int {
+	w := zstd.MinWindowSize
+	for w < n && w < zstd.MaxWindowSize {
+		w <<= 1
+	}
+	return w
+}
+
+// diffAlgoName returns how algo is recorded in manifests and patch
+// indexes: not at all for bsdiff, which older clients assume.
+func diffAlgoName(algo string) string {
+	if algo == "bsdiff" {
+		return ""
+	}
+	return algo
+}
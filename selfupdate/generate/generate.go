@@ -0,0 +1,1721 @@
+// Package generate creates the files served to selfupdate clients: the
+// compressed full binary, binary patches from older versions and the
+// manifest of every platform. The go-selfupdate command is a thin wrapper
+// around GenerateUpdate.
+package generate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Options configures GenerateUpdate. Only InputPath, Version and OutputDir
+// are required, the zero value of every other field selects the default.
+type Options struct {
+	// InputPath is the binary to publish. If it is a directory every file
+	// in it is published, using the file name as the platform, or every
+	// binary below it, see Matrix. If it doesn't exist but is a glob
+	// pattern, every matching file is published, see PlatformRegex. "-"
+	// reads a single binary from Stdin.
+	InputPath string
+	// Version is the version being published. It must be semver unless
+	// AllowAnyVersion is set.
+	Version string
+	// OutputDir is the directory holding the published versions.
+	OutputDir string
+	// Channel, if set, publishes into the OutputDir/Channel subdirectory
+	// instead, keeping release streams like stable and beta apart. Each
+	// channel has its own manifests, and patches are only generated from
+	// versions published to the same channel.
+	Channel string
+	// Platform is the OS-ARCH of a single binary. Defaults to the running
+	// os/arch, and must be set when reading from Stdin.
+	Platform string
+	// Stdin is read when InputPath is "-". Defaults to os.Stdin.
+	Stdin io.Reader
+
+	// Format is the compression format of the full binary, "gzip"
+	// (default), "pgzip" or "zstd". "pgzip" writes the same gzip files,
+	// compressing blocks of the binary on every core, which speeds up a
+	// large binary at the cost of a slightly larger file.
+	Format string
+	// Compression is the gzip level of the full binary: 0-9, "none",
+	// "fast", "best" or "default".
+	Compression string
+	// Hash is the checksum algorithm recorded in the manifest: "sha256"
+	// (default), "sha512" or "blake2b".
+	Hash string
+	// Timings records in the Summary how long compressing each binary,
+	// making each patch and writing the files took, with the throughput.
+	// In verbose mode they are logged either way.
+	Timings bool
+	// InputCompressed means the input binaries are gzip compressed, like
+	// the .gz files of a build pipeline. The manifest describes their
+	// content, and with the gzip Format they are stored as they are
+	// instead of being compressed a second time.
+	InputCompressed bool
+
+	// Workers is the number of patches generated in parallel. Each worker
+	// holds two decompressed binaries and a patch in memory. Defaults to
+	// the number of CPUs, capped at 6.
+	Workers int
+	// PlatformWorkers is the number of platforms processed in parallel
+	// when InputPath is a directory. Patch generation stays bounded by
+	// Workers overall. Defaults like Workers.
+	PlatformWorkers int
+	// NoPatch disables generating patches from older versions.
+	NoPatch bool
+	// ExcludeOld are glob patterns of older versions, like pulled or
+	// broken ones, no patches are generated from. Their clients download
+	// the full binary.
+	ExcludeOld []string
+	// KeepUncompressed also writes the binary as is, to
+	// OutputDir/Version/Platform, for servers that compress at the HTTP
+	// layer. The manifest records it in Uncompressed.
+	KeepUncompressed bool
+	// DiffDepth limits patch generation to the newest DiffDepth prior
+	// versions. Zero means every prior version gets a patch.
+	DiffDepth int
+	// BaseVersions, if set, are the only prior versions patches are
+	// generated from, say the release before a hotfix, instead of every
+	// version directory in OutputDir. Each has to exist there. ExcludeOld
+	// and DiffDepth don't apply.
+	BaseVersions []string
+	// MaxPatchRatio skips patches larger than this fraction of the
+	// compressed full binary, as downloading them saves clients too little
+	// to be worth storing. Zero means no limit.
+	MaxPatchRatio float64
+	// SameMajorPatches only generates patches from older versions with
+	// the same semver major version as Version, as patches across a major
+	// version are usually nearly full size. Clients updating to a new
+	// major version download the full binary. Applied before DiffDepth,
+	// and to BaseVersions too. Version must be semver; older versions
+	// that aren't are diffed from as usual.
+	SameMajorPatches bool
+	// StrictPatches makes a patch that fails verification an error
+	// instead of a warning.
+	StrictPatches bool
+	// VerifyOld checks the full binary of each older version against the
+	// checksum recorded in its patch index before diffing from it, and
+	// skips versions that don't match.
+	VerifyOld bool
+	// SkipCorrupt leaves older versions whose full binary can't be
+	// decompressed out of diffing. By default the run fails before writing
+	// anything for the platform, listing every corrupt version.
+	SkipCorrupt bool
+	// PatchFormat compresses the patches with "gzip" or "zstd" on top of
+	// the compression of the diff algorithm. Defaults to "none", writing
+	// them raw.
+	PatchFormat string
+	// DiffAlgo is the algorithm patches are made with: "bsdiff", the
+	// default that every client applies, or "zstd", which compresses the
+	// new binary with the old one as dictionary. zstd is much faster and
+	// uses less memory on large binaries, but clients older than it
+	// download the full binary instead.
+	DiffAlgo string
+
+	// Include and Exclude are glob patterns filtering which files of an
+	// input directory or glob are treated as platform binaries.
+	Include, Exclude []string
+	// PlatformRegex finds the platform in the name of each file matching
+	// an InputPath glob, in its "platform" group, its "os" and "arch"
+	// groups or its first group. By default the name has to end in
+	// OS-ARCH or OS_ARCH, optionally followed by .exe.
+	PlatformRegex string
+	// Matrix treats an InputPath directory as a tree like
+	// linux/amd64/myapp, publishing every binary in it under the platform
+	// named by the directories leading to it, joined with MatrixSep.
+	Matrix bool
+	// MatrixSep joins the directory names of a Matrix binary into its
+	// platform, "-" by default.
+	MatrixSep string
+	// FollowSymlinks publishes the targets of symlinks in an input
+	// directory. By default, like every file that isn't regular, they are
+	// skipped.
+	FollowSymlinks bool
+	// CheckPlatform compares the platform of each binary with the one its
+	// ELF, Mach-O or PE header names. "warn" warns about a mismatch,
+	// "error" fails the platform. Unset skips the check.
+	CheckPlatform string
+	// NoNormalizePlatform keeps platform names as given instead of
+	// canonicalizing architecture aliases like x86_64 to their GOARCH, see
+	// selfupdate.NormalizePlatform.
+	NoNormalizePlatform bool
+
+	// Force allows overwriting the artifacts of an already published
+	// version.
+	Force bool
+	// Incremental republishes an already published version without Force,
+	// keeping every patch that was generated from the same old full binary
+	// to an identical new one, instead of generating it again. Use it to
+	// add a platform to a version. Force regenerates all patches.
+	Incremental bool
+	// SkipUnchanged skips a platform whose binary is identical to the one
+	// its manifest already points at, instead of only warning about it.
+	SkipUnchanged bool
+	// Ordered keeps the log of platforms and patches processed in
+	// parallel in input order, as if they were processed one by one, so
+	// the output of two runs can be compared.
+	Ordered bool
+	// Rollout is the percentage, from 0 to 100, of installations offered
+	// the version, for a staged rollout. 100 offers it to all, and so does
+	// nil, except that publishing the published version again keeps its
+	// rollout. Publishing the same binary of the published version again
+	// with a different Rollout only rewrites its manifests.
+	Rollout *int
+	// Metadata holds additional fields, like a release notes URL or the
+	// commit a release was built from, written to the Metadata of every
+	// manifest for clients to use as they see fit. Keys start with a
+	// letter, followed by letters, digits, '.', '_' or '-', and can't be
+	// the name of a manifest field.
+	Metadata map[string]string
+	// MinFromVersion, if set, is the oldest version clients can install
+	// Version from. Clients running an older one are told to update to it
+	// first, for example to run a migration, and no patches are generated
+	// from the versions before it.
+	MinFromVersion string
+	// DryRun reports what would be written without touching OutputDir.
+	DryRun bool
+	// SkipSpaceCheck skips checking that the filesystem of OutputDir has
+	// room for the run before anything is written.
+	SkipSpaceCheck bool
+	// Checksums writes a SHA256SUMS file covering every file written.
+	Checksums bool
+	// Upload, if set, receives every file written once the run is done,
+	// see Uploader. OutputDir then only needs to hold the prior versions
+	// patches are generated from.
+	Upload Uploader
+	// Layout names the files as uploaded and in the appcast, for hosting
+	// schemes other than the layout of OutputDir, see selfupdate.Layout.
+	// OutputDir itself keeps the default layout, which later runs read the
+	// prior versions from. Clients must set the same Updater.Layout.
+	Layout selfupdate.Layout
+	// AppcastURL, if set, is the URL OutputDir is served from, and an
+	// appcast.xml feed for Sparkle is written pointing at the newest full
+	// binary of every platform below it.
+	AppcastURL string
+	// FileMode is the permission of every file written, 0644 by default.
+	// Directories are always created with 0755.
+	FileMode os.FileMode
+	// Storage receives every file and directory written, see Storage.
+	// Defaults to LocalStorage with FileMode.
+	Storage Storage
+
+	// SigningKey, if set, signs the manifests.
+	SigningKey ed25519.PrivateKey
+	// Signer, if set, signs the manifests instead of SigningKey, for keys
+	// kept outside the process, like in a KMS or hardware token. It must
+	// make ed25519 signatures of whole messages, which it is asked for
+	// with crypto.Hash(0) as options, as ed25519.PrivateKey does.
+	Signer crypto.Signer
+	// GeneratedAt is recorded in every manifest. Defaults to the current
+	// time.
+	GeneratedAt time.Time
+	// GeneratorVersion identifies the tool in the manifest.
+	GeneratorVersion string
+	// AllowAnyVersion accepts a Version that isn't semver.
+	AllowAnyVersion bool
+	// VersionRegex is the regular expression the names of version
+	// directories match in full, Version included. Other directories in
+	// OutputDir aren't taken for older versions. By default they have to
+	// be semantic versions, unless AllowAnyVersion is set.
+	VersionRegex string
+
+	// Logger receives progress, warnings and the dry-run summary. Nothing
+	// is logged when nil.
+	Logger *Logger
+}
+
+// Validate reports the first invalid option, without touching the disk.
+func (o *Options) Validate() error {
+	switch {
+	case o.InputPath == "":
+		return errors.New("no input path")
+	case o.OutputDir == "":
+		return errors.New("no output directory")
+	case o.InputPath == "-" && o.Platform == "":
+		return errors.New("the platform must be given when reading the binary from stdin")
+	}
+	if err := validateVersion(o.Version, o.AllowAnyVersion); err != nil {
+		return err
+	}
+	if o.VersionRegex != "" {
+		re, err := regexp.Compile(fullMatch(o.VersionRegex))
+		if err != nil {
+			return fmt.Errorf("invalid version regex: %w", err)
+		}
+		if !re.MatchString(o.Version) {
+			return fmt.Errorf("invalid version %q: doesn't match the version regex %q", o.Version, o.VersionRegex)
+		}
+	}
+	if err := validateChannel(o.Channel); err != nil {
+		return err
+	}
+	if o.Format != "" && o.Format != "pgzip" {
+		if _, ok := formatExt[o.Format]; !ok {
+			return fmt.Errorf("invalid format %q: want gzip, pgzip or zstd", o.Format)
+		}
+	}
+	if o.PatchFormat != "" && o.PatchFormat != "none" {
+		if _, ok := formatExt[o.PatchFormat]; !ok {
+			return fmt.Errorf("invalid patch format %q: want none, gzip or zstd", o.PatchFormat)
+		}
+	}
+	if o.DiffAlgo != "" && o.DiffAlgo != "bsdiff" && o.DiffAlgo != "zstd" {
+		return fmt.Errorf("invalid diff algorithm %q: want bsdiff or zstd", o.DiffAlgo)
+	}
+	if o.Compression != "" {
+		if _, err := ParseCompressionLevel(o.Compression); err != nil {
+			return err
+		}
+	}
+	if o.Hash != "" {
+		if _, err := newHash(o.Hash); err != nil {
+			return err
+		}
+	}
+	switch {
+	case o.Workers < 0:
+		return fmt.Errorf("invalid workers %d: must be at least 1", o.Workers)
+	case o.PlatformWorkers < 0:
+		return fmt.Errorf("invalid platform workers %d: must be at least 1", o.PlatformWorkers)
+	case o.DiffDepth < 0:
+		return fmt.Errorf("invalid diff depth %d: must not be negative", o.DiffDepth)
+	case len(o.BaseVersions) > 0 && o.NoPatch:
+		return errors.New("base versions can't be combined with no patch")
+	case o.MaxPatchRatio < 0:
+		return fmt.Errorf("invalid max patch ratio %g: must not be negative", o.MaxPatchRatio)
+	case o.SameMajorPatches && !selfupdate.IsSemver(o.Version):
+		return fmt.Errorf("same major patches needs a semver version, got %q", o.Version)
+	case o.Rollout != nil && (*o.Rollout < 0 || *o.Rollout > 100):
+		return fmt.Errorf("invalid rollout %d: must be between 0 and 100", *o.Rollout)
+	case o.FileMode&^os.ModePerm != 0:
+		return fmt.Errorf("invalid file mode %s: only permission bits may be set", o.FileMode)
+	}
+	for _, base := range o.BaseVersions {
+		switch {
+		case !isDirName(base):
+			return fmt.Errorf("invalid base version %q: must be usable as a directory name", base)
+		case base == o.Version:
+			return fmt.Errorf("invalid base version %s: it is the version being published", base)
+		}
+	}
+	if err := validateMetadata(o.Metadata); err != nil {
+		return err
+	}
+	if err := o.validateMinFrom(); err != nil {
+		return err
+	}
+	if o.Signer != nil {
+		if o.SigningKey != nil {
+			return errors.New("set either a signing key or a signer, not both")
+		}
+		if _, ok := o.Signer.Public().(ed25519.PublicKey); !ok {
+			return fmt.Errorf("signer has a %T key, want ed25519", o.Signer.Public())
+		}
+	}
+	if o.Layout != (selfupdate.Layout{}) {
+		if err := o.Layout.Validate(); err != nil {
+			return err
+		}
+		if o.Upload == nil && o.AppcastURL == "" {
+			return errors.New("a layout only applies to uploads and the appcast")
+		}
+	}
+	if o.AppcastURL != "" {
+		if u, err := url.Parse(o.AppcastURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid appcast URL %q: want an absolute URL like https://updates.example.com/myapp", o.AppcastURL)
+		}
+	}
+	switch o.CheckPlatform {
+	case "", "warn", "error":
+	default:
+		return fmt.Errorf("invalid platform check %q: want warn or error", o.CheckPlatform)
+	}
+	if o.PlatformRegex != "" {
+		re, err := regexp.Compile(o.PlatformRegex)
+		if err != nil {
+			return fmt.Errorf("invalid platform regex: %w", err)
+		}
+		if re.NumSubexp() == 0 {
+			return fmt.Errorf("invalid platform regex %q: needs a group capturing the platform", o.PlatformRegex)
+		}
+	}
+	if strings.ContainsAny(o.MatrixSep, `/\`) {
+		return fmt.Errorf("invalid matrix separator %q: must not contain a path separator", o.MatrixSep)
+	}
+	for _, pattern := range append(append(append([]string{}, o.Include...), o.Exclude...), o.ExcludeOld...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// GenerateUpdate publishes opts.Version to opts.OutputDir: the compressed
+// full binary, patches from older versions and the manifest of every
+// platform. Failures for individual platforms or old versions don't stop
+// the run; they are returned together once all work is done.
+func GenerateUpdate(opts Options) error {
+	_, err := GenerateUpdateSummary(opts)
+	return err
+}
+
+// GenerateUpdateSummary is GenerateUpdate, also returning a Summary of the
+// run. The Summary is complete even when an error is returned.
+func GenerateUpdateSummary(opts Options) (Summary, error) {
+	g, err := newGenerator(opts)
+	if err != nil {
+		r := summaryRecorder{s: Summary{Version: opts.Version, OutputDir: opts.OutputDir}}
+		return r.summary(err), err
+	}
+	err = g.generate()
+	return g.summary.summary(err), err
+}
+
+func (g *generator) generate() error {
+	if _, local := g.Storage.(LocalStorage); local && !g.SkipSpaceCheck && !g.DryRun {
+		// fail before a half-published release fills the disk
+		if err := g.checkSpace(); err != nil {
+			return err
+		}
+	}
+	if err := g.mkdirAll(g.OutputDir); err != nil {
+		return err
+	}
+
+	err := g.run(g.InputPath, g.Platform)
+	if g.SkipUnchanged && !g.DryRun {
+		// drop the version directory if every platform was skipped; this
+		// fails harmlessly if anything was written to it
+		g.Storage.Remove(filepath.Join(g.OutputDir, g.Version))
+	}
+	// a run failing before any manifest was written leaves the index and
+	// latest.json at the versions still published
+	if err == nil || g.published() {
+		if errIndex := g.writeIndex(); errIndex != nil {
+			err = errors.Join(err, errIndex)
+		}
+	}
+	if g.Checksums {
+		if errSums := g.writeChecksums(); errSums != nil {
+			err = errors.Join(err, errSums)
+		}
+	}
+	if g.Upload != nil {
+		if errUpload := g.upload(); errUpload != nil {
+			err = errors.Join(err, errUpload)
+		}
+	}
+	if g.DryRun {
+		g.log.Summaryf("%s", g.plan.summary())
+	}
+	g.log.Verbosef("Wrote %s", g.summary.writeTiming())
+	return err
+}
+
+// generator holds the state of a single GenerateUpdate call.
+type generator struct {
+	Options
+
+	log              *Logger
+	compressionLevel int
+	parallelGzip     bool // Format was "pgzip"
+	platformRegexp   *regexp.Regexp
+	versionRegexp    *regexp.Regexp // VersionRegex, nil if unset
+	plan             *writePlan
+	written          *checksumSet
+	summary          *summaryRecorder
+	manifests        *manifestSet // written by this run
+	timed            bool         // Measure writes, with Timings or in verbose mode
+
+	// diffSlots bounds the number of patches generated at once to
+	// Workers, across all platforms processed concurrently.
+	diffSlots chan struct{}
+}
+
+// withLog returns g reporting to log instead, sharing everything else, for
+// work whose output is buffered, see runWorkers.
+func (g *generator) withLog(log *Logger) *generator {
+	if log == g.log {
+		return g
+	}
+	c := *g
+	c.log = log
+	return &c
+}
+
+// newGenerator validates opts and fills in the defaults.
+func newGenerator(opts Options) (*generator, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	g := &generator{Options: opts, log: opts.Logger}
+	if g.log == nil {
+		g.log = NewLogger(io.Discard, io.Discard, LevelQuiet)
+	}
+	g.OutputDir = filepath.Join(g.OutputDir, g.Channel)
+	if g.Platform == "" {
+		g.Platform = runtime.GOOS + "-" + runtime.GOARCH
+	}
+	if g.Stdin == nil {
+		g.Stdin = os.Stdin
+	}
+	if g.Format == "" {
+		g.Format = "gzip"
+	}
+	if g.Format == "pgzip" {
+		// published as gzip, only compressed differently
+		g.Format = "gzip"
+		g.parallelGzip = true
+	}
+	if g.PatchFormat == "" {
+		g.PatchFormat = "none"
+	}
+	if g.DiffAlgo == "" {
+		g.DiffAlgo = "bsdiff"
+	}
+	if g.Compression == "" {
+		g.Compression = "default"
+	}
+	g.compressionLevel, _ = ParseCompressionLevel(g.Compression)
+	g.platformRegexp = defaultPlatformRegexp
+	if g.PlatformRegex != "" {
+		g.platformRegexp = regexp.MustCompile(g.PlatformRegex)
+	}
+	if g.VersionRegex != "" {
+		g.versionRegexp = regexp.MustCompile(fullMatch(g.VersionRegex))
+	}
+	if g.MatrixSep == "" {
+		g.MatrixSep = "-"
+	}
+	if g.Signer == nil && g.SigningKey != nil {
+		g.Signer = g.SigningKey
+	}
+	if g.Hash == "" {
+		g.Hash = "sha256"
+	}
+	if g.Workers == 0 {
+		g.Workers = DefaultWorkers()
+	}
+	if g.PlatformWorkers == 0 {
+		g.PlatformWorkers = DefaultWorkers()
+	}
+	if g.FileMode == 0 {
+		g.FileMode = 0644
+	}
+	if g.Storage == nil {
+		g.Storage = LocalStorage{FileMode: g.FileMode}
+	}
+	if g.GeneratedAt.IsZero() {
+		g.GeneratedAt = time.Now()
+	}
+	// all manifests written by one run agree on the timestamp
+	g.GeneratedAt = g.GeneratedAt.UTC().Truncate(time.Second)
+	g.plan = &writePlan{}
+	g.written = &checksumSet{sums: map[string][sha256.Size]byte{}}
+	g.manifests = &manifestSet{m: map[string]current{}}
+	g.summary = &summaryRecorder{s: Summary{Version: g.Version, OutputDir: g.OutputDir, DryRun: g.DryRun}, timings: g.Timings}
+	g.timed = g.Timings || g.log.level >= LevelVerbose
+	g.diffSlots = make(chan struct{}, g.Workers)
+	return g, nil
+}
+
+// formatExt maps each supported compression format to the file extension of
+// the full binary artifact.
+var formatExt = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+type current struct {
+	SchemaVersion    int // selfupdate.SchemaVersion of the generator
+	Version          string
+	Sha256           []byte `json:",omitempty"` // Only set for sha256, kept for older clients
+	Hash             digest
+	Compression      string            // Compression format of the full binary, "gzip" or "zstd"
+	Length           int64             // Size of the uncompressed binary
+	CompressedLength int64             // Size of the compressed full binary
+	PatchLengths     map[string]int64  `json:",omitempty"` // Size of each patch, keyed by the version it patches from
+	PatchSums        map[string][]byte `json:",omitempty"` // sha256 of each patch as stored, keyed like PatchLengths
+	PatchCompression string            `json:",omitempty"` // Compression of the patches, "gzip" or "zstd"; unset if raw
+	DiffAlgo         string            `json:",omitempty"` // Algorithm of the patches, "zstd"; unset for bsdiff
+	Uncompressed     bool              `json:",omitempty"` // The binary is also stored as is, without extension
+	GeneratedAt      time.Time         // When the manifest was generated, in UTC
+	GeneratorVersion string            // Version of go-selfupdate that generated the manifest
+	Signature        []byte            `json:",omitempty"` // ed25519 signature of Hash, see digestMessage
+	KeyID            string            `json:",omitempty"` // Fingerprint of the public key verifying Signature
+	Rollout          *int              `json:",omitempty"` // Percentage of installations offered the version; unset for all
+	Metadata         map[string]string `json:",omitempty"` // Additional fields from Options.Metadata
+	MinFromVersion   string            `json:",omitempty"` // Oldest version clients can install Version from
+}
+
+// patchIndex lists the patches generated to Version for Platform. It is
+// written to OutputDir/Version/Platform.patches.json.
+type patchIndex struct {
+	Version     string
+	Platform    string
+	Hash        digest // Checksum of the binary of Version, which applying any of the patches yields
+	Compression string `json:",omitempty"` // Compression of the patch files, unset if raw
+	DiffAlgo    string `json:",omitempty"` // Algorithm the patches were made with, unset for bsdiff
+	Patches     []patchEntry
+}
+
+// patchEntry describes the patch from an older version, stored at
+// OutputDir/From/Version/Platform, plus the extension of the patch
+// compression if any.
+type patchEntry struct {
+	From       string // Version the patch applies to
+	Length     int64  // Size of the patch file as stored
+	Sha256     []byte // Checksum of the patch file as stored
+	FromSha256 []byte `json:",omitempty"` // Checksum of the full binary of From as stored, see Incremental
+}
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// digest is a checksum together with the algorithm that produced it.
+type digest struct {
+	Algo  string
+	Value []byte
+}
+
+// newHash returns a new hash.Hash for algo (sha256, sha512 or blake2b).
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "blake2b":
+		return blake2b.New512(nil)
+	}
+	return nil, fmt.Errorf("unknown hash algorithm %q", algo)
+}
+
+type gzReader struct {
+	z, r io.ReadCloser
+}
+
+func (g *gzReader) Read(p []byte) (int, error) {
+	return g.z.Read(p)
+}
+
+func (g *gzReader) Close() error {
+	g.z.Close()
+	return g.r.Close()
+}
+
+// DefaultWorkers returns the number of CPUs, capped at 6.
+func DefaultWorkers() int {
+	n := runtime.NumCPU()
+	if n > 6 {
+		n = 6
+	}
+	return n
+}
+
+// ParseCompressionLevel maps a compression name to a gzip level. It accepts
+// the numeric levels 0-9 as well as the names "default", "none", "fast" and
+// "best". "none" still produces a valid gzip stream (stored blocks) so
+// clients can read it like any other .gz artifact.
+func ParseCompressionLevel(s string) (int, error) {
+	switch s {
+	case "default":
+		return gzip.DefaultCompression, nil
+	case "none":
+		return gzip.NoCompression, nil
+	case "fast":
+		return gzip.BestSpeed, nil
+	case "best":
+		return gzip.BestCompression, nil
+	}
+	level, err := strconv.Atoi(s)
+	if err != nil || level < gzip.NoCompression || level > gzip.BestCompression {
+		return 0, fmt.Errorf("invalid compression %q: want 0-9, none, fast, best or default", s)
+	}
+	return level, nil
+}
+
+// newGzReader returns a reader decompressing r. It fails if r doesn't start
+// with a valid gzip header, for example because the file is truncated.
+func newGzReader(r io.ReadCloser) (io.ReadCloser, error) {
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &gzReader{z: z, r: r}, nil
+}
+
+type zstdReader struct {
+	z *zstd.Decoder
+	r io.ReadCloser
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	return z.z.Read(p)
+}
+
+func (z *zstdReader) Close() error {
+	z.z.Close()
+	return z.r.Close()
+}
+
+// newZstdReader returns a reader decompressing r. Unlike gzip, a corrupt
+// zstd stream is only detected while reading.
+func newZstdReader(r io.ReadCloser) (io.ReadCloser, error) {
+	z, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{z: z, r: r}, nil
+}
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gzipOSUnknown is the "unknown" OS value of the gzip header (RFC 1952).
+const gzipOSUnknown = 255
+
+// pgzipBlockSize is the size of the blocks pgzip compresses in parallel.
+// It is fixed, rather than derived from the number of cores, as the output
+// depends on it.
+const pgzipBlockSize = 1 << 20
+
+// newCompressWriter returns a writer compressing into w using format and,
+// for gzip and pgzip, level. The output only depends on the input, so
+// repeated runs on the same binary produce identical artifacts.
+func newCompressWriter(w io.Writer, format string, level int) (io.WriteCloser, error) {
+	switch format {
+	case "pgzip":
+		gw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		if err := gw.SetConcurrency(pgzipBlockSize, runtime.GOMAXPROCS(0)); err != nil {
+			return nil, err
+		}
+		gw.Header.ModTime = time.Time{}
+		gw.Header.Name = ""
+		gw.Header.OS = gzipOSUnknown
+		return gw, nil
+	case "gzip":
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		// keep the output byte-for-byte reproducible: no modification time,
+		// file name or host dependent OS byte in the header
+		gw.Header.ModTime = time.Time{}
+		gw.Header.Name = ""
+		gw.Header.OS = gzipOSUnknown
+		return gw, nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// compress returns b compressed with format.
+func (g *generator) compress(b []byte, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := newCompressWriter(&buf, format, g.compressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// openFullBin opens the full binary for platform stored in dir, whichever
+// format it was written in, and returns a reader of the decompressed bytes.
+// The error satisfies os.IsNotExist if there is none.
+func openFullBin(dir, platform string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(dir, platform+formatExt["gzip"]))
+	if err == nil {
+		return closeOnError(f, newGzReader)
+	}
+	f, err = os.Open(filepath.Join(dir, platform+formatExt["zstd"]))
+	if err == nil {
+		return closeOnError(f, newZstdReader)
+	}
+	return nil, err
+}
+
+// openArtifact opens the full binary at path, written in format.
+func openArtifact(path, format string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "zstd" {
+		return closeOnError(f, newZstdReader)
+	}
+	return closeOnError(f, newGzReader)
+}
+
+// closeOnError wraps f with newReader, closing f if that fails.
+func closeOnError(f *os.File, newReader func(io.ReadCloser) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	r, err := newReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	return r, nil
+}
+
+// maxSharedBinary is the size up to which the new binary is kept in memory
+// while generating patches, shared by all of them.
+var maxSharedBinary int64 = 1 << 30
+
+// cappedBuffer keeps a copy of what is written to it as long as it stays
+// within max bytes, and drops it once it grows beyond.
+type cappedBuffer struct {
+	buf  bytes.Buffer
+	max  int64
+	over bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.over {
+		return len(p), nil
+	}
+	if int64(c.buf.Len())+int64(len(p)) > c.max {
+		c.over = true
+		c.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+// Bytes returns the copy, or false if it was dropped.
+func (c *cappedBuffer) Bytes() ([]byte, bool) {
+	return c.buf.Bytes(), !c.over
+}
+
+// createUpdate writes the full compressed binary and the manifest for
+// platform and generates patches from every older version found in
+// OutputDir. Failures to diff individual old versions don't stop the run;
+// they are collected and returned together once all work is done.
+func (g *generator) createUpdate(path string, platform string) error {
+	genDir, version := g.OutputDir, g.Version
+	if ok, err := g.republishRollout(path, platform); ok || err != nil {
+		return err
+	}
+	if !g.Force && !g.Incremental {
+		for _, ext := range formatExt {
+			existing := filepath.Join(genDir, version, platform+ext)
+			if _, err := os.Stat(existing); err == nil {
+				return fmt.Errorf("%s already exists, refusing to republish version %s (use -force to overwrite)", existing, version)
+			}
+		}
+	}
+
+	if g.CheckPlatform != "" && path != "-" {
+		if err := g.checkPlatform(path, platform); err != nil {
+			return err
+		}
+	}
+
+	var olds []fs.DirEntry
+	if !g.NoPatch {
+		var err error
+		if olds, err = g.oldVersions(platform); err != nil {
+			return err
+		}
+	}
+
+	if err := g.mkdirAll(filepath.Join(genDir, version)); err != nil {
+		return err
+	}
+
+	in := g.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	br := bufio.NewReader(in)
+	in = br
+	magic, _ := br.Peek(len(gzipMagic))
+	switch isGzip := bytes.Equal(magic, gzipMagic); {
+	case g.InputCompressed && !isGzip:
+		return fmt.Errorf("%s is not gzip compressed", path)
+	case !g.InputCompressed && isGzip:
+		g.log.Warnf("%s is gzip compressed and is published as it is, pass -input-compressed to publish its content", path)
+	}
+
+	// Stream the binary through the compressor into the artifact, hashing
+	// it on the way so it is read only once. Unless it is huge, a copy is
+	// kept to diff every old version from, instead of decompressing the
+	// artifact again for each. In dry-run mode, or with a Storage other
+	// than LocalStorage, there is no local artifact to diff from later, so
+	// the copy is always kept.
+	h, err := newHash(g.Hash)
+	if err != nil {
+		return err
+	}
+	newSHA := sha256.New()
+	hashes := io.MultiWriter(h, newSHA)
+	raw := &cappedBuffer{max: maxSharedBinary}
+	if _, local := g.Storage.(LocalStorage); g.DryRun || !local {
+		raw.max = math.MaxInt64
+	}
+	if !g.NoPatch {
+		hashes = io.MultiWriter(h, newSHA, raw)
+	}
+
+	start := time.Now()
+	binPath := filepath.Join(genDir, version, platform+formatExt[g.Format])
+	out, err := g.createFile(binPath, false)
+	if err != nil {
+		return fmt.Errorf("can't write full binary %s: %w", binPath, err)
+	}
+	// the plain copy, if kept, is written on the way too
+	plain := &artifactFile{}
+	plainPath := filepath.Join(genDir, version, platform)
+	if g.KeepUncompressed {
+		if plain, err = g.createFile(plainPath, false); err != nil {
+			out.discard()
+			return fmt.Errorf("can't write uncompressed binary %s: %w", plainPath, err)
+		}
+		hashes = io.MultiWriter(hashes, plain)
+	}
+	discard := func() {
+		out.discard()
+		plain.discard()
+	}
+	var length int64
+	if g.InputCompressed && g.Format == "gzip" {
+		// already compressed as published: stored as it is, and only
+		// decompressed for the checksums and the copy to diff from
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(io.TeeReader(in, out)); err == nil {
+			length, err = io.Copy(hashes, zr)
+		}
+	} else {
+		if g.InputCompressed {
+			var zr *gzip.Reader
+			if zr, err = gzip.NewReader(in); err != nil {
+				discard()
+				return fmt.Errorf("can't decompress %s: %w", path, err)
+			}
+			in = zr
+		}
+		format := g.Format
+		if g.parallelGzip {
+			format = "pgzip"
+		}
+		var w io.WriteCloser
+		if w, err = newCompressWriter(out, format, g.compressionLevel); err != nil {
+			discard()
+			return err
+		}
+		length, err = io.Copy(w, io.TeeReader(in, hashes))
+		if err == nil {
+			err = w.Close()
+		}
+	}
+	if err != nil {
+		discard()
+		return fmt.Errorf("can't write full binary %s: %w", binPath, err)
+	}
+	compressed := newTiming(time.Since(start), length)
+	sum := h.Sum(nil)
+	var newSum [sha256.Size]byte
+	copy(newSum[:], newSHA.Sum(nil))
+
+	// the artifact isn't in place yet, so an unchanged binary can still be
+	// dropped without a trace
+	if prev := g.publishedManifest(platform); prev != nil && prev.Version != version && sameBinary(prev, g.Hash, sum, newSum) {
+		if g.SkipUnchanged {
+			discard()
+			g.log.Warnf("%s is identical to the published version %s, skipped", platform, prev.Version)
+			g.summary.skipped(platform, path, "identical to the published version "+prev.Version)
+			return nil
+		}
+		g.log.Warnf("%s is identical to the published version %s", platform, prev.Version)
+	}
+
+	if err := out.Close(); err != nil {
+		discard()
+		return fmt.Errorf("can't write full binary %s: %w", binPath, err)
+	}
+	if g.KeepUncompressed {
+		if err := plain.Close(); err != nil {
+			plain.discard()
+			return fmt.Errorf("can't write uncompressed binary %s: %w", plainPath, err)
+		}
+	}
+	g.log.Verbosef("Compressed %s with %s to %d bytes: %s", platform, g.Format, out.n, compressed)
+
+	var (
+		mu      sync.Mutex
+		errList []error
+		patches []patchEntry
+		diffs   = map[string]*Timing{} // by the version patched from
+		prev    *patchIndex            // of an earlier run, for Incremental
+	)
+	if g.Incremental && !g.Force {
+		prev = g.readPatchIndex(version, platform)
+	}
+
+	processUpdate := func(file fs.DirEntry, log *Logger) error {
+		g := g.withLog(log)
+		g.log.Printf("Processing %s for %s", file.Name(), platform)
+		if !file.IsDir() {
+			g.log.Printf("%s is not a directory, skipped", file.Name())
+			return nil
+		}
+		if file.Name() == version {
+			g.log.Printf("%s is current version, skipped", file.Name())
+			return nil
+		}
+
+		release := g.acquireDiffSlot()
+		defer release()
+
+		ar, err := openFullBin(filepath.Join(genDir, file.Name()), platform)
+		if os.IsNotExist(err) {
+			// Don't have an old release for this os/arch, continue on
+			g.log.Printf("%s found no release for this os/arch, skipped", file.Name())
+			return nil
+		}
+		if err != nil {
+			// a corrupt old release only costs its clients the patch
+			g.log.Warnf("%s has a corrupt release for %s, skipped: %s", file.Name(), platform, err)
+			g.summary.skipped(platform, file.Name(), "corrupt release: "+err.Error())
+			return nil
+		}
+		defer ar.Close()
+		fromSum, _ := storedSum(filepath.Join(genDir, file.Name()), platform)
+		if p, ok := g.reusablePatch(prev, sum, file.Name(), fromSum, platform); ok {
+			if g.oversizedPatch(platform, file.Name(), p.Length, out.n) {
+				return nil
+			}
+			mu.Lock()
+			patches = append(patches, p)
+			mu.Unlock()
+			g.log.Printf("Patch from %s for %s is unchanged, kept", file.Name(), platform)
+			return nil
+		}
+
+		// the shared binary is only read, never copied, by every worker
+		newBin, ok := raw.Bytes()
+		if !ok {
+			fName := filepath.Join(genDir, version, platform+formatExt[g.Format])
+			br, err := openArtifact(fName, g.Format)
+			if err != nil {
+				return fmt.Errorf("can't open %s: %w", fName, err)
+			}
+			defer br.Close()
+			if newBin, err = io.ReadAll(br); err != nil {
+				return fmt.Errorf("can't read %s for %s: %w", version, platform, err)
+			}
+		}
+		oldBin, err := io.ReadAll(ar)
+		if err == nil && g.VerifyOld {
+			err = g.verifyOldBin(file.Name(), platform, oldBin)
+		}
+		if err != nil {
+			g.log.Warnf("%s has a corrupt release for %s, skipped: %s", file.Name(), platform, err)
+			g.summary.skipped(platform, file.Name(), "corrupt release: "+err.Error())
+			return nil
+		}
+		start := time.Now()
+		patch, err := diff(g.DiffAlgo, oldBin, newBin)
+		if err != nil {
+			return fmt.Errorf("failed to %s %s: %w", g.DiffAlgo, file.Name(), err)
+		}
+		diffed := newTiming(time.Since(start), int64(len(newBin)))
+		if err := verifyPatch(g.DiffAlgo, oldBin, patch, newSum); err != nil {
+			if g.StrictPatches {
+				return fmt.Errorf("patch from %s: %w", file.Name(), err)
+			}
+			g.log.Warnf("patch from %s skipped, clients will download the full binary: %s", file.Name(), err)
+			g.summary.skipped(platform, file.Name(), "patch failed verification: "+err.Error())
+			return nil
+		}
+		stored := patch
+		if g.PatchFormat != "none" {
+			if stored, err = g.compress(stored, g.PatchFormat); err != nil {
+				return fmt.Errorf("can't compress patch from %s: %w", file.Name(), err)
+			}
+		}
+		if g.oversizedPatch(platform, file.Name(), int64(len(stored)), out.n) {
+			return nil
+		}
+		// several platforms may create the same patch directory at once,
+		// which MkdirAll tolerates
+		if err := g.mkdirAll(filepath.Join(genDir, file.Name(), version)); err != nil {
+			return err
+		}
+		patchPath := filepath.Join(genDir, file.Name(), version, platform+formatExt[g.PatchFormat])
+		patchSum, err := g.writeFileSum(patchPath, stored, true)
+		if err != nil {
+			return fmt.Errorf("can't write patch %s: %w", patchPath, err)
+		}
+		mu.Lock()
+		patches = append(patches, patchEntry{
+			From:       file.Name(),
+			Length:     int64(len(stored)),
+			Sha256:     patchSum,
+			FromSha256: fromSum,
+		})
+		diffs[file.Name()] = diffed
+		mu.Unlock()
+		g.log.Verbosef("Patch from %s: %d bytes (%d stored), diffed %s", file.Name(), len(patch), len(stored), diffed)
+		g.log.Printf("Done with %s for %s", file.Name(), platform)
+		return nil
+	}
+
+	if g.NoPatch {
+		g.log.Printf("Patch generation disabled, skipping older versions")
+	} else {
+		errList = runWorkers(g.log, g.Workers, g.Ordered, olds, processUpdate)
+	}
+
+	sort.Slice(patches, func(i, j int) bool { return patches[i].From < patches[j].From })
+	index := patchIndex{Version: version, Platform: platform, Hash: digest{Algo: g.Hash, Value: sum}, DiffAlgo: diffAlgoName(g.DiffAlgo), Patches: patches}
+	if g.PatchFormat != "none" {
+		index.Compression = g.PatchFormat
+	}
+	b, err := json.MarshalIndent(index, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := g.writeFile(filepath.Join(genDir, version, platform+".patches.json"), b, false); err != nil {
+		return err
+	}
+
+	patchLengths := make(map[string]int64, len(patches))
+	patchSums := make(map[string][]byte, len(patches))
+	for _, p := range patches {
+		patchLengths[p.From] = p.Length
+		patchSums[p.From] = p.Sha256
+	}
+	c := current{
+		SchemaVersion:    selfupdate.SchemaVersion,
+		Version:          version,
+		Hash:             digest{Algo: g.Hash, Value: sum},
+		Compression:      g.Format,
+		Length:           length,
+		CompressedLength: out.n,
+		PatchLengths:     patchLengths,
+		PatchSums:        patchSums,
+		Uncompressed:     g.KeepUncompressed,
+		GeneratedAt:      g.GeneratedAt,
+		GeneratorVersion: g.GeneratorVersion,
+		Rollout:          g.rollout(g.publishedManifest(platform)),
+		Metadata:         g.Metadata,
+		MinFromVersion:   g.MinFromVersion,
+	}
+	if g.Hash == "sha256" {
+		c.Sha256 = sum
+	}
+	if g.PatchFormat != "none" && len(patches) > 0 {
+		c.PatchCompression = g.PatchFormat
+	}
+	if len(patches) > 0 {
+		c.DiffAlgo = diffAlgoName(g.DiffAlgo)
+	}
+	if err := g.writeManifest(platform, c); err != nil {
+		return err
+	}
+	ps := PlatformSummary{Platform: platform, Length: length, CompressedLength: out.n, Patches: []PatchSummary{}}
+	if g.Timings {
+		ps.Compress = compressed
+	}
+	for _, p := range patches {
+		s := PatchSummary{From: p.From, Length: p.Length}
+		if g.Timings {
+			s.Diff = diffs[p.From]
+		}
+		ps.Patches = append(ps.Patches, s)
+	}
+	g.summary.platform(ps)
+
+	return errors.Join(errList...)
+}
+
+// writeManifest signs c if there is a Signer and writes it as the
+// manifest of platform, with a copy next to its version for clients
+// installing it rather than the latest one.
+func (g *generator) writeManifest(platform string, c current) error {
+	if g.Signer != nil {
+		if err := signManifest(&c, g.Signer); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{filepath.Join(g.OutputDir, c.Version, platform+".json"), filepath.Join(g.OutputDir, platform+".json")} {
+		if err := g.writeFile(path, b, false); err != nil {
+			return err
+		}
+		if g.Signer != nil {
+			// detached signature of the exact manifest bytes
+			sig, err := sign(g.Signer, b)
+			if err != nil {
+				return err
+			}
+			if err := g.writeFile(path+".sig", sig, false); err != nil {
+				return err
+			}
+		}
+	}
+	g.recordManifest(platform, c)
+	return nil
+}
+
+// publishedManifest returns the current manifest of platform in OutputDir,
+// or nil if there is none or it can't be read.
+func (g *generator) publishedManifest(platform string) *current {
+	b, err := os.ReadFile(filepath.Join(g.OutputDir, platform+".json"))
+	if err != nil {
+		return nil
+	}
+	var c current
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil
+	}
+	return &c
+}
+
+// sameBinary reports whether the manifest c describes the binary hashing to
+// sum with algo and to sha256Sum with sha256. It is false if c was written
+// with a different algorithm and has no sha256 either.
+func sameBinary(c *current, algo string, sum []byte, sha256Sum [sha256.Size]byte) bool {
+	switch {
+	case c.Hash.Algo == algo:
+		return bytes.Equal(c.Hash.Value, sum)
+	case c.Hash.Algo == "sha256":
+		return bytes.Equal(c.Hash.Value, sha256Sum[:])
+	case c.Sha256 != nil:
+		return bytes.Equal(c.Sha256, sha256Sum[:])
+	}
+	return false
+}
+
+// newestPriorVersions returns the DiffDepth newest version directories in
+// files that hold a full binary for platform, excluding the current
+// version. They are ordered by sortNewestFirst, so the selection is
+// deterministic.
+func (g *generator) newestPriorVersions(files []fs.DirEntry, platform string) []fs.DirEntry {
+	var candidates []fs.DirEntry
+	for _, file := range files {
+		if !file.IsDir() || file.Name() == g.Version || !hasFullBin(filepath.Join(g.OutputDir, file.Name()), platform) {
+			continue
+		}
+		candidates = append(candidates, file)
+	}
+	sortNewestFirst(candidates)
+
+	var selected []fs.DirEntry
+	for i, file := range candidates {
+		if i < g.DiffDepth {
+			selected = append(selected, file)
+		} else {
+			g.log.Printf("%s is older than -diff-depth %d, skipped", file.Name(), g.DiffDepth)
+		}
+	}
+	return selected
+}
+
+// oldVersions lists the older versions to diff platform from, among the
+// version directories and after ExcludeOld, MinFromVersion,
+// SameMajorPatches and DiffDepth, and test-decompresses the full binary of
+// each so a corrupt one is found before anything is written. Corrupt
+// versions are dropped with SkipCorrupt and fail the platform otherwise,
+// all of them reported at once.
+func (g *generator) oldVersions(platform string) ([]fs.DirEntry, error) {
+	var (
+		files []fs.DirEntry
+		err   error
+	)
+	if len(g.BaseVersions) > 0 {
+		if files, err = g.baseVersionDirs(platform); err != nil {
+			return nil, err
+		}
+	} else {
+		files, err = os.ReadDir(g.OutputDir)
+		if err != nil && !(g.DryRun && os.IsNotExist(err)) {
+			return nil, err
+		}
+		files = g.versionDirs(files)
+		if len(g.ExcludeOld) > 0 {
+			files = g.withoutExcludedOld(files, platform)
+		}
+	}
+	if g.MinFromVersion != "" {
+		files = g.withoutBelowMinFrom(files, platform)
+	}
+	if g.SameMajorPatches {
+		files = g.withoutOtherMajors(files, platform)
+	}
+	if g.DiffDepth > 0 && len(g.BaseVersions) == 0 {
+		files = g.newestPriorVersions(files, platform)
+	}
+	var (
+		kept    []fs.DirEntry
+		corrupt []error
+	)
+	for _, file := range files {
+		if !file.IsDir() || file.Name() == g.Version {
+			kept = append(kept, file)
+			continue
+		}
+		err := checkFullBin(filepath.Join(g.OutputDir, file.Name()), platform)
+		switch {
+		case err == nil || os.IsNotExist(err):
+			kept = append(kept, file)
+		case g.SkipCorrupt:
+			g.log.Warnf("%s has a corrupt release for %s, skipped: %s", file.Name(), platform, err)
+			g.summary.skipped(platform, file.Name(), "corrupt release: "+err.Error())
+		default:
+			corrupt = append(corrupt, fmt.Errorf("%s: %w", file.Name(), err))
+		}
+	}
+	if len(corrupt) > 0 {
+		return nil, fmt.Errorf("%d older versions have a corrupt release for %s, fix them or pass -skip-corrupt to leave them out: %w", len(corrupt), platform, errors.Join(corrupt...))
+	}
+	return kept, nil
+}
+
+// baseVersionDirs returns the directories of BaseVersions, without
+// listing OutputDir. A base version without a directory is an error, and
+// one without a release for platform is skipped with a warning.
+func (g *generator) baseVersionDirs(platform string) ([]fs.DirEntry, error) {
+	var dirs []fs.DirEntry
+	for _, base := range g.BaseVersions {
+		dir := filepath.Join(g.OutputDir, base)
+		fi, err := os.Stat(dir)
+		if err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("base version %s not found in %s", base, g.OutputDir)
+		}
+		if !hasFullBin(dir, platform) {
+			g.log.Warnf("base version %s has no release for %s, no patch from it", base, platform)
+			g.summary.skipped(platform, base, "no release for the platform")
+			continue
+		}
+		dirs = append(dirs, fs.FileInfoToDirEntry(fi))
+	}
+	return dirs, nil
+}
+
+// versionDirs drops the directories in files that aren't named like a
+// version, see VersionRegex, so stray directories in OutputDir, like
+// channels, aren't diffed from.
+func (g *generator) versionDirs(files []fs.DirEntry) []fs.DirEntry {
+	var kept []fs.DirEntry
+	for _, file := range files {
+		if file.IsDir() && !g.isVersion(file.Name()) {
+			g.log.Printf("%s is not named like a version, skipped", file.Name())
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+// isVersion reports whether name is named like a version: matching
+// VersionRegex if set, or else a semantic version unless AllowAnyVersion
+// allows any name.
+func (g *generator) isVersion(name string) bool {
+	switch {
+	case g.versionRegexp != nil:
+		return g.versionRegexp.MatchString(name)
+	case g.AllowAnyVersion:
+		return true
+	}
+	return selfupdate.IsSemver(name)
+}
+
+// fullMatch anchors the regular expression re to match whole strings.
+func fullMatch(re string) string {
+	return "^(?:" + re + ")$"
+}
+
+// checkFullBin decompresses the full binary for platform stored in dir,
+// returning an error if it is corrupt. The error satisfies os.IsNotExist
+// if there is none.
+func checkFullBin(dir, platform string) error {
+	r, err := openFullBin(dir, platform)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// withoutExcludedOld drops the versions matching ExcludeOld from files,
+// before DiffDepth picks among the rest.
+func (g *generator) withoutExcludedOld(files []fs.DirEntry, platform string) []fs.DirEntry {
+	var kept []fs.DirEntry
+	for _, file := range files {
+		if !file.IsDir() || !g.excludedOld(file.Name()) {
+			kept = append(kept, file)
+			continue
+		}
+		if hasFullBin(filepath.Join(g.OutputDir, file.Name()), platform) {
+			g.log.Printf("%s matches -exclude-old, no patch for %s", file.Name(), platform)
+			g.summary.skipped(platform, file.Name(), "matches -exclude-old")
+		}
+	}
+	return kept
+}
+
+// excludedOld reports whether version matches ExcludeOld.
+func (g *generator) excludedOld(version string) bool {
+	for _, pattern := range g.ExcludeOld {
+		if ok, _ := filepath.Match(pattern, version); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sortNewestFirst sorts version directories from newest to oldest. If every
+// name is a semantic version they are ordered by semver, otherwise by
+// modification time with ties broken by name.
+func sortNewestFirst(dirs []fs.DirEntry) {
+	modTimes := make(map[string]time.Time, len(dirs))
+	allSemver := true
+	for _, dir := range dirs {
+		if info, err := dir.Info(); err == nil {
+			modTimes[dir.Name()] = info.ModTime()
+		}
+		if !selfupdate.IsSemver(dir.Name()) {
+			allSemver = false
+		}
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		a, b := dirs[i].Name(), dirs[j].Name()
+		if allSemver {
+			c, _ := selfupdate.CompareVersions(a, b)
+			return c > 0
+		}
+		if !modTimes[a].Equal(modTimes[b]) {
+			return modTimes[a].After(modTimes[b])
+		}
+		return a > b
+	})
+}
+
+// hasFullBin reports whether dir holds a full binary for platform in any
+// supported format.
+func hasFullBin(dir, platform string) bool {
+	for _, ext := range formatExt {
+		if _, err := os.Stat(filepath.Join(dir, platform+ext)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyOldBin checks bin, the full binary of the older version for
+// platform, against the checksum in its patch index. Versions published
+// before the index recorded one can't be checked and pass.
+func (g *generator) verifyOldBin(version, platform string, bin []byte) error {
+	b, err := os.ReadFile(filepath.Join(g.OutputDir, version, platform+".patches.json"))
+	if os.IsNotExist(err) {
+		g.log.Printf("%s has no recorded checksum for %s, not verified", version, platform)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var index patchIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		return fmt.Errorf("unreadable patch index: %w", err)
+	}
+	if index.Hash.Algo == "" {
+		g.log.Printf("%s has no recorded checksum for %s, not verified", version, platform)
+		return nil
+	}
+	h, err := newHash(index.Hash.Algo)
+	if err != nil {
+		return err
+	}
+	h.Write(bin)
+	if !bytes.Equal(h.Sum(nil), index.Hash.Value) {
+		return fmt.Errorf("%s checksum doesn't match its patch index", index.Hash.Algo)
+	}
+	return nil
+}
+
+// oversizedPatch reports whether a patch from version of n bytes exceeds
+// MaxPatchRatio of the compressed full binary of full bytes, in which case
+// it is logged and recorded as skipped.
+func (g *generator) oversizedPatch(platform, version string, n, full int64) bool {
+	if g.MaxPatchRatio == 0 || full == 0 {
+		return false
+	}
+	ratio := float64(n) / float64(full)
+	if ratio <= g.MaxPatchRatio {
+		return false
+	}
+	g.log.Printf("Patch from %s for %s is %.2f of the full binary, over -max-patch-ratio %g, skipped", version, platform, ratio, g.MaxPatchRatio)
+	g.summary.skipped(platform, version, fmt.Sprintf("patch is %.2f of the full binary, over the maximum ratio %g", ratio, g.MaxPatchRatio))
+	return true
+}
+
+// acquireDiffSlot blocks until a patch may be generated and returns the
+// function releasing the slot again.
+func (g *generator) acquireDiffSlot() (release func()) {
+	g.diffSlots <- struct{}{}
+	return func() { <-g.diffSlots }
+}
+
+// verifyPatch applies patch, made with algo, to oldBin and checks that the
+// result hashes to newSum, so a patch that doesn't round-trip is never
+// published.
+func verifyPatch(algo string, oldBin, patch []byte, newSum [sha256.Size]byte) error {
+	out, err := applyPatch(algo, oldBin, patch)
+	if err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	if sha256.Sum256(out) != newSum {
+		return errors.New("verification failed: patched binary doesn't match the new version")
+	}
+	return nil
+}
+
+// runWorkers calls process for each file using n goroutines and returns the
+// errors they reported, in the order of files. Each call is passed the
+// Logger to report to: log, or with ordered a buffer of its own, which is
+// flushed to log once the calls for all earlier files are done, so the
+// output reads the same on every run.
+func runWorkers[T any](log *Logger, n int, ordered bool, files []T, process func(T, *Logger) error) []error {
+	log.Verbosef("Number of CPUs: %d", runtime.NumCPU())
+	log.Verbosef("Number of workers: %d", n)
+	type job struct {
+		i    int
+		file T
+	}
+	jobs := make(chan job)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make([]error, len(files))
+		logs = make([]*Logger, len(files))
+		done = make([]bool, len(files))
+		next int // first file whose log isn't flushed yet
+	)
+	for i := range logs {
+		logs[i] = log
+		if ordered {
+			logs[i] = log.buffer()
+		}
+	}
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range jobs {
+				err := process(j.file, logs[j.i])
+				mu.Lock()
+				errs[j.i], done[j.i] = err, true
+				for ordered && next < len(files) && done[next] {
+					log.flush(logs[next])
+					next++
+				}
+				mu.Unlock()
+			}
+			wg.Done()
+		}()
+	}
+	for i, file := range files {
+		jobs <- job{i, file}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var errList []error
+	for _, err := range errs {
+		if err != nil {
+			errList = append(errList, err)
+		}
+	}
+	return errList
+}
+
+// matchesFilters reports whether name matches one of the Include patterns
+// (or there are none) and none of the Exclude patterns.
+func (g *generator) matchesFilters(name string) bool {
+	included := len(g.Include) == 0
+	for _, pattern := range g.Include {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range g.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ParsePatterns splits a comma separated list of glob patterns and checks
+// that each is valid.
+func ParsePatterns(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	patterns := strings.Split(s, ",")
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return patterns, nil
+}
+
+// notABinary explains why the entry at path of an input directory can't be
+// a platform binary, or returns "" if it can. Only regular files qualify,
+// and symlinks to them if FollowSymlinks is set; anything else, like a
+// FIFO, could block or fail the run when read.
+func (g *generator) notABinary(path string, file fs.DirEntry) string {
+	mode := file.Type()
+	if mode&fs.ModeSymlink != 0 {
+		if !g.FollowSymlinks {
+			return "is a symlink (use -follow-symlinks to publish its target)"
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return fmt.Sprintf("is a broken symlink (%s)", err)
+		}
+		mode = fi.Mode().Type()
+	}
+	switch {
+	case mode.IsRegular():
+		return ""
+	case mode.IsDir():
+		return "is a directory"
+	}
+	return "is not a regular file"
+}
+
+// platformName returns the name platform is published under. With
+// InputCompressed, the .gz extension of an input file name is dropped.
+func (g *generator) platformName(platform string) string {
+	if g.InputCompressed {
+		platform = strings.TrimSuffix(platform, ".gz")
+	}
+	if g.NoNormalizePlatform {
+		return platform
+	}
+	return selfupdate.NormalizePlatform(platform)
+}
+
+// run creates updates for appPath. If appPath is a directory an update is
+// created for each file in it, using the file name as the platform, or
+// for each binary below it in Matrix mode. Errors
+// for individual platforms are collected so the remaining ones still get
+// processed. An appPath of "-" reads a single binary from Stdin.
+func (g *generator) run(appPath, platform string) error {
+	platform = g.platformName(platform)
+	if appPath == "-" {
+		return g.createUpdate(appPath, platform)
+	}
+
+	fi, err := os.Stat(appPath)
+	if os.IsNotExist(err) && isGlob(appPath) {
+		return g.runGlob(appPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() && g.Matrix {
+		return g.runMatrix(appPath)
+	}
+	if fi.IsDir() {
+		files, err := os.ReadDir(appPath)
+		if err != nil {
+			return err
+		}
+		var (
+			platforms []fs.DirEntry
+			seen      = map[string]string{} // input path by platform
+			errList   []error
+		)
+		for _, file := range files {
+			if !g.matchesFilters(file.Name()) {
+				g.log.Printf("%s doesn't match -include/-exclude, skipped", file.Name())
+				g.summary.skipped("", file.Name(), "doesn't match -include/-exclude")
+				continue
+			}
+			if reason := g.notABinary(filepath.Join(appPath, file.Name()), file); reason != "" {
+				g.log.Printf("%s %s, skipped", file.Name(), reason)
+				g.summary.skipped("", file.Name(), reason)
+				continue
+			}
+			// two names normalizing to the same platform would write to the
+			// same artifacts, so the manifest of one would describe the
+			// binary of the other
+			path := filepath.Join(appPath, file.Name())
+			name := g.platformName(file.Name())
+			if other, ok := seen[name]; ok {
+				errList = append(errList, fmt.Errorf("%s: same platform %s as %s, skipped", path, name, other))
+				continue
+			}
+			seen[name] = path
+			platforms = append(platforms, file)
+		}
+		errList = append(errList, runWorkers(g.log, g.PlatformWorkers, g.Ordered, platforms, func(file fs.DirEntry, log *Logger) error {
+			if err := g.withLog(log).createUpdate(filepath.Join(appPath, file.Name()), g.platformName(file.Name())); err != nil {
+				return fmt.Errorf("%s: %w", file.Name(), err)
+			}
+			return nil
+		})...)
+		return errors.Join(errList...)
+	}
+
+	return g.createUpdate(appPath, platform)
+}
@@ -0,0 +1,1184 @@
+package generate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+	"github.com/klauspost/compress/zstd"
+	"github.com/kr/binarydist"
+)
+
+// publish generates each of versions in turn from a linux-amd64 binary
+// containing "binary <version>", with the other settings taken from opts.
+func publish(t *testing.T, opts Options, versions ...string) {
+	t.Helper()
+	opts.InputPath = filepath.Join(t.TempDir(), "myapp")
+	if opts.Platform == "" {
+		opts.Platform = "linux-amd64"
+	}
+	opts.AllowAnyVersion = true
+	for _, v := range versions {
+		opts.Version = v
+		if err := os.WriteFile(opts.InputPath, []byte("binary "+v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := GenerateUpdate(opts); err != nil {
+			t.Fatalf("GenerateUpdate(%s) returned error: %s", v, err)
+		}
+	}
+}
+
+// readManifest decodes the manifest of platform in dir.
+func readManifest(t *testing.T, dir, platform string) current {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, platform+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c current
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGenerateUpdateMissingInputReturnsError(t *testing.T) {
+	dir := t.TempDir()
+	err := GenerateUpdate(Options{InputPath: filepath.Join(dir, "does-not-exist"), Version: "1.0.0", OutputDir: dir})
+	if err == nil {
+		t.Error("Expected an error for a missing input binary")
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	valid := Options{InputPath: "myapp", Version: "1.0.0", OutputDir: "public"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate returned error for valid options: %s", err)
+	}
+
+	invalid := []func(*Options){
+		func(o *Options) { o.Version = "1.0" },
+		func(o *Options) { o.OutputDir = "" },
+		func(o *Options) { o.InputPath = "-" },
+		func(o *Options) { o.Format = "xz" },
+		func(o *Options) { o.Compression = "11" },
+		func(o *Options) { o.Hash = "md5" },
+		func(o *Options) { o.Workers = -1 },
+		func(o *Options) { o.DiffDepth = -1 },
+		func(o *Options) { o.DiffAlgo = "courgette" },
+		func(o *Options) { o.MaxPatchRatio = -1 },
+		func(o *Options) { o.Include = []string{"[bad"} },
+	}
+	for i, modify := range invalid {
+		o := valid
+		modify(&o)
+		if err := o.Validate(); err == nil {
+			t.Errorf("case %d: expected an error for %+v", i, o)
+		}
+	}
+}
+
+func TestParseCompressionLevel(t *testing.T) {
+	cases := map[string]int{
+		"default": gzip.DefaultCompression,
+		"none":    gzip.NoCompression,
+		"fast":    gzip.BestSpeed,
+		"best":    gzip.BestCompression,
+		"0":       0,
+		"7":       7,
+	}
+	for in, want := range cases {
+		got, err := ParseCompressionLevel(in)
+		if err != nil {
+			t.Errorf("ParseCompressionLevel(%q) returned error: %s", in, err)
+		}
+		if got != want {
+			t.Errorf("ParseCompressionLevel(%q) = %d; want %d", in, got, want)
+		}
+	}
+
+	for _, in := range []string{"", "10", "-2", "fastest"} {
+		if _, err := ParseCompressionLevel(in); err == nil {
+			t.Errorf("ParseCompressionLevel(%q) expected an error", in)
+		}
+	}
+}
+
+func TestGenerateUpdateZstd(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, Format: "zstd"}, "1.0", "1.1")
+
+	for _, p := range []string{"1.1/linux-amd64.zst", "1.0/1.1/linux-amd64"} {
+		if _, err := os.Stat(filepath.Join(dir, p)); err != nil {
+			t.Errorf("Expected %s to exist: %s", p, err)
+		}
+	}
+
+	c := readManifest(t, dir, "linux-amd64")
+	if c.Compression != "zstd" {
+		t.Errorf("Compression = %q; want zstd", c.Compression)
+	}
+	if c.Length != int64(len("binary 1.1")) {
+		t.Errorf("Length = %d; want %d", c.Length, len("binary 1.1"))
+	}
+	fi, err := os.Stat(filepath.Join(dir, "1.1", "linux-amd64.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CompressedLength != fi.Size() {
+		t.Errorf("CompressedLength = %d; want %d", c.CompressedLength, fi.Size())
+	}
+	if c.PatchLengths["1.0"] == 0 {
+		t.Errorf("PatchLengths should contain the 1.0 patch, got %v", c.PatchLengths)
+	}
+	patch, err := os.ReadFile(filepath.Join(dir, "1.0", "1.1", "linux-amd64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.PatchSums["1.0"], sha256Sum(patch)) {
+		t.Errorf("PatchSums should hold the checksum of the 1.0 patch, got %v", c.PatchSums)
+	}
+}
+
+func TestGenerateUpdatePgzip(t *testing.T) {
+	// several blocks of a compressible binary
+	bin := bytes.Repeat([]byte("a large binary compressed in blocks "), 3*pgzipBlockSize/36)
+	input := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(input, bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var outputs [2][]byte
+	for i, procs := range []int{1, 4} {
+		// the blocks and so the output don't depend on the cores used
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		dir := t.TempDir()
+		if err := GenerateUpdate(Options{InputPath: input, Version: "1.0.0", OutputDir: dir, Platform: "linux-amd64", Format: "pgzip"}); err != nil {
+			t.Fatal(err)
+		}
+		if c := readManifest(t, dir, "linux-amd64"); c.Compression != "gzip" {
+			t.Errorf("Compression = %q; want gzip", c.Compression)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "1.0.0", "linux-amd64.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[i] = b
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("pgzip output differs with the number of cores")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(outputs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, bin) {
+		t.Errorf("Expected a standard gzip reader to get the binary back, got %d bytes, %v", len(got), err)
+	}
+}
+
+func TestGenerateUpdateInputCompressed(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0")
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("binary 1.1"))
+	w.Close()
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp.gz"), Version: "1.1", OutputDir: dir, Platform: "linux-amd64", AllowAnyVersion: true, InputCompressed: true}
+	if err := os.WriteFile(opts.InputPath, gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(dir, "1.1", "linux-amd64.gz"))
+	if err != nil || !bytes.Equal(stored, gz.Bytes()) {
+		t.Errorf("Expected the input to be stored as it is, got %v", err)
+	}
+	c := readManifest(t, dir, "linux-amd64")
+	want := sha256.Sum256([]byte("binary 1.1"))
+	if !bytes.Equal(c.Hash.Value, want[:]) || c.Length != int64(len("binary 1.1")) {
+		t.Errorf("Expected the manifest to describe the decompressed binary, got %+v", c)
+	}
+	var bin bytes.Buffer
+	patch, err := os.ReadFile(filepath.Join(dir, "1.0", "1.1", "linux-amd64"))
+	if err == nil {
+		err = binarydist.Patch(strings.NewReader("binary 1.0"), &bin, bytes.NewReader(patch))
+	}
+	if err != nil || bin.String() != "binary 1.1" {
+		t.Errorf("Expected a patch to the decompressed binary, got %q, %v", bin.String(), err)
+	}
+
+	// other formats get the content compressed once
+	opts.Version, opts.Format = "1.2", "zstd"
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := openArtifact(filepath.Join(dir, "1.2", "linux-amd64.zst"), "zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if b, _ := io.ReadAll(zr); string(b) != "binary 1.1" {
+		t.Errorf("Expected the zstd binary to hold the content, got %q", b)
+	}
+
+	opts.Version = "1.3"
+	opts.InputPath = filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(opts.InputPath, []byte("binary 1.3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateUpdate(opts); err == nil {
+		t.Error("Expected an error for an input that isn't gzip compressed")
+	}
+
+	// the extension isn't part of the platform in an input directory
+	opts.InputPath = t.TempDir()
+	if err := os.WriteFile(filepath.Join(opts.InputPath, "darwin-arm64.gz"), gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.Platform = ""
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "darwin-arm64.json")); err != nil {
+		t.Errorf("Expected a manifest for darwin-arm64: %s", err)
+	}
+}
+
+func TestGenerateUpdateHashAlgo(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, Hash: "sha512"}, "1.0")
+
+	c := readManifest(t, dir, "linux-amd64")
+	want := sha512.Sum512([]byte("binary 1.0"))
+	if c.Hash.Algo != "sha512" || !bytes.Equal(c.Hash.Value, want[:]) {
+		t.Errorf("Hash = %s %x; want sha512 %x", c.Hash.Algo, c.Hash.Value, want)
+	}
+	if c.Sha256 != nil {
+		t.Errorf("Sha256 should be omitted for sha512, got %x", c.Sha256)
+	}
+}
+
+func TestGenerateUpdateGeneratedAt(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.FixedZone("CET", 3600))
+	publish(t, Options{OutputDir: dir, GeneratedAt: at, GeneratorVersion: "v1.2.3"}, "1.0")
+
+	c := readManifest(t, dir, "linux-amd64")
+	if want := "2024-01-02T02:04:05Z"; c.GeneratedAt.Format(time.RFC3339Nano) != want {
+		t.Errorf("GeneratedAt = %s; want %s", c.GeneratedAt.Format(time.RFC3339Nano), want)
+	}
+	if c.GeneratorVersion != "v1.2.3" {
+		t.Errorf("GeneratorVersion = %q; want v1.2.3", c.GeneratorVersion)
+	}
+	if c.SchemaVersion != selfupdate.SchemaVersion {
+		t.Errorf("SchemaVersion = %d; want %d", c.SchemaVersion, selfupdate.SchemaVersion)
+	}
+}
+
+func TestGenerateUpdateNoPatch(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, NoPatch: true}, "1.0", "1.1")
+
+	if _, err := os.Stat(filepath.Join(dir, "1.1", "linux-amd64.gz")); err != nil {
+		t.Errorf("Expected full binary to exist: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.0", "1.1")); !os.IsNotExist(err) {
+		t.Errorf("Expected no patch directory with NoPatch, got %v", err)
+	}
+}
+
+func TestGenerateUpdateKeepUncompressed(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, KeepUncompressed: true}, "1.0.0", "1.1.0")
+
+	b, err := os.ReadFile(filepath.Join(dir, "1.1.0", "linux-amd64"))
+	if err != nil || string(b) != "binary 1.1.0" {
+		t.Errorf("Expected the plain binary next to the compressed one, got %q, %v", b, err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); !c.Uncompressed || c.PatchLengths["1.0.0"] == 0 {
+		t.Errorf("Expected the manifest to record the plain binary and the patch, got %+v", c)
+	}
+
+	publish(t, Options{OutputDir: dir}, "1.2.0")
+	if _, err := os.Stat(filepath.Join(dir, "1.2.0", "linux-amd64")); !os.IsNotExist(err) {
+		t.Errorf("Expected no plain binary by default, got %v", err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); c.Uncompressed {
+		t.Error("Expected the manifest not to record a plain binary by default")
+	}
+}
+
+func TestGenerateUpdateDiffDepth(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, DiffDepth: 2}, "1.9.0", "1.10.0", "1.2.0", "2.0.0")
+
+	for _, old := range []string{"1.10.0", "1.9.0"} {
+		if _, err := os.Stat(filepath.Join(dir, old, "2.0.0", "linux-amd64")); err != nil {
+			t.Errorf("Expected patch from %s: %s", old, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.2.0", "2.0.0")); !os.IsNotExist(err) {
+		t.Errorf("Expected no patch from 1.2.0 outside DiffDepth, got %v", err)
+	}
+}
+
+func TestGenerateUpdateMaxPatchRatio(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0")
+	// bsdiff's headers alone make the patch of such a tiny binary larger
+	// than the compressed binary
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "1.1", OutputDir: dir, Platform: "linux-amd64", AllowAnyVersion: true, MaxPatchRatio: 0.9}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 1.1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := GenerateUpdateSummary(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "1.0", "1.1")); !os.IsNotExist(err) {
+		t.Errorf("Expected no oversized patch, got %v", err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 0 {
+		t.Errorf("Expected the manifest to list no patches, got %v", c.PatchLengths)
+	}
+	if len(s.Skipped) != 1 || s.Skipped[0].Item != "1.0" || !strings.Contains(s.Skipped[0].Reason, "maximum ratio 0.9") {
+		t.Errorf("Expected the patch from 1.0 to be reported as skipped, got %+v", s.Skipped)
+	}
+
+	publish(t, Options{OutputDir: dir, MaxPatchRatio: 100}, "1.2")
+	if c := readManifest(t, dir, "linux-amd64"); c.PatchLengths["1.1"] == 0 {
+		t.Errorf("Expected the patch within the ratio to be kept, got %v", c.PatchLengths)
+	}
+}
+
+func TestGenerateUpdateExcludeOld(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0", "1.1.1")
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "2.0.0", OutputDir: dir, Platform: "linux-amd64", ExcludeOld: []string{"1.1.*"}, DiffDepth: 1}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 2.0.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := GenerateUpdateSummary(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the excluded versions don't count towards DiffDepth
+	if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 1 || c.PatchLengths["1.0.0"] == 0 {
+		t.Errorf("Expected only the patch from 1.0.0, got %v", c.PatchLengths)
+	}
+	if len(s.Skipped) != 2 || s.Skipped[0].Item != "1.1.0" || s.Skipped[1].Item != "1.1.1" {
+		t.Errorf("Expected the excluded versions in the summary, got %+v", s.Skipped)
+	}
+}
+
+func TestGenerateUpdateBaseVersions(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0", "1.2.0")
+	publish(t, Options{OutputDir: dir, BaseVersions: []string{"1.2.0"}}, "1.2.1")
+	if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 1 || c.PatchLengths["1.2.0"] == 0 {
+		t.Errorf("Expected only the patch from 1.2.0, got %v", c.PatchLengths)
+	}
+	publish(t, Options{OutputDir: dir, BaseVersions: []string{"1.0.0", "1.2.1"}}, "1.2.2")
+	if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 2 || c.PatchLengths["1.0.0"] == 0 || c.PatchLengths["1.2.1"] == 0 {
+		t.Errorf("Expected the patches from 1.0.0 and 1.2.1, got %v", c.PatchLengths)
+	}
+
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "1.2.3", OutputDir: dir, Platform: "linux-amd64", BaseVersions: []string{"1.1.9"}}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 1.2.3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateUpdate(opts); err == nil || !strings.Contains(err.Error(), "base version 1.1.9 not found") {
+		t.Errorf("Expected an error for a missing base version, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.2.3")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written, got %v", err)
+	}
+	opts.BaseVersions = []string{"1.2.3"}
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for the version being published as base")
+	}
+}
+
+func TestGenerateUpdateVersionDirs(t *testing.T) {
+	// a directory that isn't a version, holding a full binary all the same
+	stray := func(dir string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, "beta"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, readManifest(t, dir, "linux-amd64").Version, "linux-amd64.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "beta", "linux-amd64.gz"), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0")
+	stray(dir)
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "1.1.0", OutputDir: dir, Platform: "linux-amd64"}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 1.1.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatal(err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 1 || c.PatchLengths["1.0.0"] == 0 {
+		t.Errorf("Expected only the patch from 1.0.0, got %v", c.PatchLengths)
+	}
+
+	dir = t.TempDir()
+	publish(t, Options{OutputDir: dir, VersionRegex: `build-\d+`}, "build-1")
+	stray(dir)
+	publish(t, Options{OutputDir: dir, VersionRegex: `build-\d+`}, "build-2")
+	if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 1 || c.PatchLengths["build-1"] == 0 {
+		t.Errorf("Expected only the patch from build-1, got %v", c.PatchLengths)
+	}
+
+	opts = Options{InputPath: "myapp", Version: "2", OutputDir: dir, AllowAnyVersion: true, VersionRegex: `build-\d+`}
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for a version not matching the regex")
+	}
+}
+
+func TestGenerateUpdateSharedBinary(t *testing.T) {
+	defer func(max int64) { maxSharedBinary = max }(maxSharedBinary)
+	for _, max := range []int64{1 << 20, 4} {
+		// beyond the cap, every patch reads the new binary from its artifact
+		maxSharedBinary = max
+		dir := t.TempDir()
+		publish(t, Options{OutputDir: dir}, "1.0", "1.1", "1.2")
+
+		if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 2 {
+			t.Errorf("max %d: expected patches from 1.0 and 1.1, got %v", max, c.PatchLengths)
+		}
+	}
+
+	b := &cappedBuffer{max: 4}
+	b.Write([]byte("abc"))
+	if got, ok := b.Bytes(); !ok || string(got) != "abc" {
+		t.Errorf("Expected the copy within the cap, got %q, %v", got, ok)
+	}
+	b.Write([]byte("de"))
+	if _, ok := b.Bytes(); ok {
+		t.Error("Expected the copy to be dropped beyond the cap")
+	}
+}
+
+func TestGenerateUpdateDryRun(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0")
+	var out bytes.Buffer
+	publish(t, Options{OutputDir: dir, DryRun: true, Logger: NewLogger(&out, &out, LevelQuiet)}, "1.1")
+
+	for _, p := range []string{"1.1", "1.0/1.1"} {
+		if _, err := os.Stat(filepath.Join(dir, p)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to be written in dry-run mode, got %v", p, err)
+		}
+	}
+	if !strings.Contains(out.String(), "would write 7 files (1 patches)") {
+		t.Errorf("Unexpected dry-run summary %q", out.String())
+	}
+}
+
+func TestGenerateUpdateExistingVersion(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0")
+
+	bin := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(bin, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{InputPath: bin, Version: "1.0", OutputDir: dir, Platform: "linux-amd64", AllowAnyVersion: true}
+	err := GenerateUpdate(opts)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "1.0", "linux-amd64.gz")) {
+		t.Errorf("Expected an error naming the existing artifact, got %v", err)
+	}
+
+	opts.Force = true
+	if err := GenerateUpdate(opts); err != nil {
+		t.Errorf("GenerateUpdate with Force returned error: %s", err)
+	}
+}
+
+func TestGenerateUpdateFromStdin(t *testing.T) {
+	dir := t.TempDir()
+	err := GenerateUpdate(Options{
+		InputPath: "-",
+		Version:   "1.0.0",
+		OutputDir: dir,
+		Platform:  "linux-amd64",
+		Stdin:     strings.NewReader("binary from stdin"),
+	})
+	if err != nil {
+		t.Fatalf("GenerateUpdate returned error: %s", err)
+	}
+
+	c := readManifest(t, dir, "linux-amd64")
+	want := sha256.Sum256([]byte("binary from stdin"))
+	if !bytes.Equal(c.Sha256, want[:]) {
+		t.Errorf("Sha256 = %x; want %x", c.Sha256, want)
+	}
+}
+
+func TestMatchesFilters(t *testing.T) {
+	g := &generator{Options: Options{
+		Include: []string{"linux-*", "darwin-*"},
+		Exclude: []string{"*.txt"},
+	}}
+
+	cases := map[string]bool{
+		"linux-amd64":      true,
+		"darwin-arm64":     true,
+		"windows-amd64":    false,
+		"linux-amd64.txt":  false,
+		"release-notes.md": false,
+	}
+	for name, want := range cases {
+		if got := g.matchesFilters(name); got != want {
+			t.Errorf("matchesFilters(%q) = %v; want %v", name, got, want)
+		}
+	}
+
+	if _, err := ParsePatterns("linux-*,[bad"); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+func TestVerifyPatch(t *testing.T) {
+	oldBin, newBin := []byte("old binary"), []byte("new binary")
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader(oldBin), bytes.NewReader(newBin), &patch); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyPatch("bsdiff", oldBin, patch.Bytes(), sha256.Sum256(newBin)); err != nil {
+		t.Errorf("verifyPatch returned error for a good patch: %s", err)
+	}
+	if err := verifyPatch("bsdiff", oldBin, patch.Bytes(), sha256.Sum256([]byte("other binary"))); err == nil {
+		t.Error("Expected an error for a patch producing the wrong binary")
+	}
+	if err := verifyPatch("bsdiff", oldBin, []byte("garbage"), sha256.Sum256(newBin)); err == nil {
+		t.Error("Expected an error for a corrupt patch")
+	}
+}
+
+func TestGenerateUpdateWriteError(t *testing.T) {
+	dir := t.TempDir()
+	// a file where the version directory should be makes the write fail
+	if err := os.WriteFile(filepath.Join(dir, "1.0.0"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(bin, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GenerateUpdate(Options{InputPath: bin, Version: "1.0.0", OutputDir: dir, Platform: "linux-amd64"}); err == nil {
+		t.Error("Expected an error when the full binary can't be written")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "linux-amd64.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected no manifest to be written, got %v", err)
+	}
+}
+
+func TestGenerateUpdateCorruptOldRelease(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0", "1.1")
+	// 1.0 isn't gzip at all, 1.1 is cut off in the middle of the stream
+	if err := os.WriteFile(filepath.Join(dir, "1.0", "linux-amd64.gz"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := os.ReadFile(filepath.Join(dir, "1.1", "linux-amd64.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.1", "linux-amd64.gz"), gz[:len(gz)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "1.2", OutputDir: dir, Platform: "linux-amd64", AllowAnyVersion: true}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 1.2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = GenerateUpdate(opts)
+	if err == nil || !strings.Contains(err.Error(), "1.0: ") || !strings.Contains(err.Error(), "1.1: ") {
+		t.Fatalf("Expected an error listing both corrupt versions, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.2")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written, got %v", err)
+	}
+
+	var out bytes.Buffer
+	publish(t, Options{OutputDir: dir, SkipCorrupt: true, Logger: NewLogger(&out, &out, LevelQuiet)}, "1.2")
+
+	if c := readManifest(t, dir, "linux-amd64"); c.Version != "1.2" || len(c.PatchLengths) != 0 {
+		t.Errorf("Expected a 1.2 manifest without patches, got %+v", c)
+	}
+	for _, old := range []string{"1.0", "1.1"} {
+		if !strings.Contains(out.String(), old+" has a corrupt release") {
+			t.Errorf("Expected a warning about %s, got %q", old, out.String())
+		}
+	}
+}
+
+func TestGenerateUpdateVerifyOld(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		dir := t.TempDir()
+		publish(t, Options{OutputDir: dir}, "1.0", "1.1")
+		// a valid gzip stream of the wrong binary
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		w.Write([]byte("bit rot"))
+		w.Close()
+		if err := os.WriteFile(filepath.Join(dir, "1.0", "linux-amd64.gz"), gz.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		publish(t, Options{OutputDir: dir, VerifyOld: verify}, "1.2")
+		c := readManifest(t, dir, "linux-amd64")
+		if _, ok := c.PatchLengths["1.0"]; ok == verify {
+			t.Errorf("VerifyOld %v: unexpected patches %v", verify, c.PatchLengths)
+		}
+		if _, ok := c.PatchLengths["1.1"]; !ok {
+			t.Errorf("VerifyOld %v: expected a patch from the intact 1.1, got %v", verify, c.PatchLengths)
+		}
+	}
+}
+
+func TestCompressReproducible(t *testing.T) {
+	for f := range formatExt {
+		var outputs [2]bytes.Buffer
+		for i := range outputs {
+			w, err := newCompressWriter(&outputs[i], f, gzip.DefaultCompression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("same binary"))
+			w.Close()
+			time.Sleep(time.Second / 100)
+		}
+		if !bytes.Equal(outputs[0].Bytes(), outputs[1].Bytes()) {
+			t.Errorf("%s output differs between runs", f)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, _ := newCompressWriter(&buf, "gzip", gzip.DefaultCompression)
+	w.Close()
+	header := buf.Bytes()[:10]
+	if !bytes.Equal(header[4:8], []byte{0, 0, 0, 0}) || header[9] != gzipOSUnknown {
+		t.Errorf("gzip header should have no mtime and an unknown OS, got % x", header)
+	}
+}
+
+func TestGenerateUpdatePatchIndex(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0", "1.1", "1.2")
+
+	b, err := os.ReadFile(filepath.Join(dir, "1.2", "linux-amd64.patches.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index patchIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Version != "1.2" || index.Platform != "linux-amd64" || len(index.Patches) != 2 {
+		t.Fatalf("Unexpected index %+v", index)
+	}
+	if index.Hash.Algo != "sha256" || !bytes.Equal(index.Hash.Value, sha256Sum([]byte("binary 1.2"))) {
+		t.Errorf("Expected the index to carry the checksum of the binary, got %+v", index.Hash)
+	}
+	for i, from := range []string{"1.0", "1.1"} {
+		p := index.Patches[i]
+		patch, err := os.ReadFile(filepath.Join(dir, from, "1.2", "linux-amd64"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.From != from || p.Length != int64(len(patch)) || !bytes.Equal(p.Sha256, sha256Sum(patch)) {
+			t.Errorf("Patches[%d] = %+v doesn't describe the patch from %s", i, p, from)
+		}
+	}
+}
+
+func TestGenerateUpdatePatchFormat(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, PatchFormat: "zstd"}, "1.0", "1.1")
+
+	stored, err := os.ReadFile(filepath.Join(dir, "1.0", "1.1", "linux-amd64.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zstd.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var bin bytes.Buffer
+	if err := binarydist.Patch(strings.NewReader("binary 1.0"), &bin, zr); err != nil {
+		t.Fatalf("Decompressed patch doesn't apply: %s", err)
+	}
+	if bin.String() != "binary 1.1" {
+		t.Errorf("Patched binary = %q; want %q", bin.String(), "binary 1.1")
+	}
+
+	c := readManifest(t, dir, "linux-amd64")
+	if c.PatchCompression != "zstd" || c.PatchLengths["1.0"] != int64(len(stored)) {
+		t.Errorf("Manifest should describe the stored zstd patch, got %s %v", c.PatchCompression, c.PatchLengths)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "1.1", "linux-amd64.patches.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index patchIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Compression != "zstd" {
+		t.Errorf("Index Compression = %q; want zstd", index.Compression)
+	}
+}
+
+func TestGenerateUpdateDiffAlgo(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "myapp")
+	publish(t, Options{OutputDir: dir, DiffAlgo: "zstd"}, "1.0", "1.1")
+
+	c := readManifest(t, dir, "linux-amd64")
+	if c.DiffAlgo != "zstd" {
+		t.Errorf("Manifest DiffAlgo = %q; want zstd", c.DiffAlgo)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "1.1", "linux-amd64.patches.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index patchIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.DiffAlgo != "zstd" {
+		t.Errorf("Index DiffAlgo = %q; want zstd", index.DiffAlgo)
+	}
+
+	if runtime.GOOS+"-"+runtime.GOARCH != "linux-amd64" {
+		t.Skip("the client fetches the manifest of its own platform")
+	}
+
+	// without the full binary, the client can only update by the patch
+	if err := os.Remove(filepath.Join(dir, "1.1", "linux-amd64.gz")); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(target, []byte("binary 1.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.0",
+		ApiURL:         "https://updates.example.com/",
+		BinURL:         "https://updates.example.com/",
+		DiffURL:        "https://updates.example.com/",
+		CmdName:        "myapp",
+		Requester:      dirRequester(root),
+		TargetPath:     target,
+	}
+	if err := u.Update(); err != nil {
+		t.Fatalf("Update returned error: %s", err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "binary 1.1" {
+		t.Errorf("Updated file = %q; want %q", b, "binary 1.1")
+	}
+}
+
+func TestGenerateUpdateVersionManifest(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "myapp")
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0", "1.2.0")
+
+	b, err := os.ReadFile(filepath.Join(dir, "1.1.0", "linux-amd64.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c current
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != "1.1.0" {
+		t.Errorf("Version manifest is for %q; want 1.1.0", c.Version)
+	}
+
+	if runtime.GOOS+"-"+runtime.GOARCH != "linux-amd64" {
+		t.Skip("the client fetches the manifest of its own platform")
+	}
+
+	target := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(target, []byte("binary 1.0.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.0.0",
+		ApiURL:         "https://updates.example.com/",
+		BinURL:         "https://updates.example.com/",
+		DiffURL:        "https://updates.example.com/",
+		CmdName:        "myapp",
+		Requester:      dirRequester(root),
+		TargetPath:     target,
+	}
+	ctx := context.Background()
+	if err := u.UpdateTo(ctx, "1.1.0"); err != nil {
+		t.Fatalf("UpdateTo returned error: %s", err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "binary 1.1.0" {
+		t.Errorf("Updated file = %q; want %q", b, "binary 1.1.0")
+	}
+
+	u.CurrentVersion = "1.2.0"
+	if err := u.UpdateTo(ctx, "1.0.0"); err == nil {
+		t.Error("Expected an error for a downgrade without AllowDowngrade")
+	}
+	u.AllowDowngrade = true
+	if err := u.UpdateTo(ctx, "1.0.0"); err != nil {
+		t.Fatalf("UpdateTo returned error: %s", err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "binary 1.0.0" {
+		t.Errorf("Downgraded file = %q; want %q", b, "binary 1.0.0")
+	}
+	if err := u.UpdateTo(ctx, "0.9.0"); err == nil {
+		t.Error("Expected an error for an unpublished version")
+	}
+}
+
+func TestRunWorkersOrdered(t *testing.T) {
+	var out bytes.Buffer
+	log := NewLogger(&out, &out, LevelNormal)
+	files := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	errs := runWorkers(log, 4, true, files, func(i int, log *Logger) error {
+		// later files finish first
+		time.Sleep(time.Duration(len(files)-i) * time.Millisecond)
+		log.Printf("start %d", i)
+		log.Printf("end %d", i)
+		if i%3 == 0 {
+			return fmt.Errorf("file %d", i)
+		}
+		return nil
+	})
+
+	var want strings.Builder
+	for _, i := range files {
+		fmt.Fprintf(&want, "start %d\nend %d\n", i, i)
+	}
+	if out.String() != want.String() {
+		t.Errorf("Log = %q; want %q", out.String(), want.String())
+	}
+	if err := errors.Join(errs...); err == nil || err.Error() != "file 0\nfile 3\nfile 6" {
+		t.Errorf("Errors = %v; want them in file order", err)
+	}
+}
+
+// publishDir generates each of versions in turn from a directory holding a
+// binary for each of platforms.
+func publishDir(t *testing.T, opts Options, platforms []string, versions ...string) {
+	t.Helper()
+	opts.AllowAnyVersion = true
+	for _, v := range versions {
+		opts.Version = v
+		opts.InputPath = t.TempDir()
+		for _, p := range platforms {
+			if err := os.WriteFile(filepath.Join(opts.InputPath, p), []byte(p+" binary "+v), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := GenerateUpdate(opts); err != nil {
+			t.Fatalf("GenerateUpdate(%s) returned error: %s", v, err)
+		}
+	}
+}
+
+func TestGenerateUpdateIncremental(t *testing.T) {
+	dir := t.TempDir()
+	publishDir(t, Options{OutputDir: dir}, []string{"linux-amd64"}, "1.0", "1.1")
+	patchPath := filepath.Join(dir, "1.0", "1.1", "linux-amd64")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(patchPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// adding a platform to 1.1 keeps the patch of the unchanged one
+	publishDir(t, Options{OutputDir: dir, Incremental: true}, []string{"linux-amd64", "darwin-arm64"}, "1.1")
+	if fi, err := os.Stat(patchPath); err != nil || !fi.ModTime().Equal(old) {
+		t.Errorf("Expected the patch to be kept, got %v", err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); c.PatchLengths["1.0"] == 0 {
+		t.Errorf("Expected the kept patch in the manifest, got %v", c.PatchLengths)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "darwin-arm64.json")); err != nil {
+		t.Errorf("Expected the new platform to be published: %s", err)
+	}
+
+	// a changed old release and Force regenerate it
+	var changed bytes.Buffer
+	zw := gzip.NewWriter(&changed)
+	zw.Write([]byte("linux-amd64 binary 1.0, rebuilt"))
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(dir, "1.0", "linux-amd64.gz"), changed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	publishDir(t, Options{OutputDir: dir, Incremental: true}, []string{"linux-amd64"}, "1.1")
+	if fi, err := os.Stat(patchPath); err != nil || fi.ModTime().Equal(old) {
+		t.Errorf("Expected the patch from the changed release to be regenerated, got %v", err)
+	}
+	if err := os.Chtimes(patchPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	publishDir(t, Options{OutputDir: dir, Incremental: true, Force: true}, []string{"linux-amd64"}, "1.1")
+	if fi, err := os.Stat(patchPath); err != nil || fi.ModTime().Equal(old) {
+		t.Errorf("Expected Force to regenerate the patch, got %v", err)
+	}
+}
+
+func TestGenerateUpdateDirectoryParallel(t *testing.T) {
+	dir := t.TempDir()
+	platforms := []string{"linux-amd64", "linux-arm64", "darwin-amd64", "darwin-arm64", "windows-amd64"}
+	publishDir(t, Options{OutputDir: dir, PlatformWorkers: 4}, platforms, "1.0", "1.1")
+
+	for _, p := range platforms {
+		for _, f := range []string{p + ".json", "1.1/" + p + ".gz", "1.0/1.1/" + p} {
+			if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+				t.Errorf("Expected %s to exist: %s", f, err)
+			}
+		}
+	}
+}
+
+func TestGenerateUpdateConcurrentMkdir(t *testing.T) {
+	// the output directory and the version directories don't exist up
+	// front, so every worker races to create them
+	dir := filepath.Join(t.TempDir(), "public")
+	var platforms []string
+	for i := 0; i < 32; i++ {
+		platforms = append(platforms, fmt.Sprintf("os%d-arch", i))
+	}
+	publishDir(t, Options{OutputDir: dir, PlatformWorkers: 16}, platforms, "1.0", "1.1")
+
+	for _, p := range platforms {
+		for _, f := range []string{p + ".json", "1.0/" + p + ".gz", "1.1/" + p + ".gz", "1.0/1.1/" + p} {
+			if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+				t.Errorf("Expected %s to exist: %s", f, err)
+			}
+		}
+	}
+}
+
+func TestGenerateUpdateUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(bin, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	opts := Options{InputPath: bin, Version: "1.0.0", OutputDir: dir, Platform: "linux-amd64", Logger: NewLogger(&out, &out, LevelQuiet)}
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Version = "1.1.0"
+	opts.SkipUnchanged = true
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "identical to the published version 1.0.0") {
+		t.Errorf("Expected a warning about the unchanged binary, got %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.1.0")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written for the skipped version, got %v", err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); c.Version != "1.0.0" {
+		t.Errorf("Manifest should still point at 1.0.0, got %s", c.Version)
+	}
+
+	// without SkipUnchanged it is only a warning
+	opts.SkipUnchanged = false
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatal(err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); c.Version != "1.1.0" {
+		t.Errorf("Manifest should point at 1.1.0, got %s", c.Version)
+	}
+}
+
+func TestGenerateUpdateDirectorySkipsNonRegular(t *testing.T) {
+	input := t.TempDir()
+	if err := os.WriteFile(filepath.Join(input, "linux-amd64"), []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("linux-amd64", filepath.Join(input, "linux-386")); err != nil {
+		t.Skipf("symlinks not supported: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(input, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	opts := Options{InputPath: input, Version: "1.0.0", OutputDir: dir}
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatalf("GenerateUpdate returned error: %s", err)
+	}
+	for _, platform := range []string{"linux-386", "docs"} {
+		if _, err := os.Stat(filepath.Join(dir, platform+".json")); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be skipped, got %v", platform, err)
+		}
+	}
+
+	opts.Version = "1.1.0"
+	opts.FollowSymlinks = true
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatalf("GenerateUpdate with FollowSymlinks returned error: %s", err)
+	}
+	if c := readManifest(t, dir, "linux-386"); c.Version != "1.1.0" {
+		t.Errorf("Expected the symlink target to be published, got %+v", c)
+	}
+}
+
+func TestGenerateUpdateNormalizesPlatform(t *testing.T) {
+	input := t.TempDir()
+	for _, name := range []string{"linux-x86_64", "darwin-aarch64"} {
+		if err := os.WriteFile(filepath.Join(input, name), []byte("binary "+name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	opts := Options{InputPath: input, Version: "1.0.0", OutputDir: dir}
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatalf("GenerateUpdate returned error: %s", err)
+	}
+	for _, platform := range []string{"linux-amd64", "darwin-arm64"} {
+		if c := readManifest(t, dir, platform); c.Version != "1.0.0" {
+			t.Errorf("Expected %s to be published, got %+v", platform, c)
+		}
+	}
+
+	// a second name for an already present platform is ambiguous
+	if err := os.WriteFile(filepath.Join(input, "linux-amd64"), []byte("other binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts.Version = "1.1.0"
+	err := GenerateUpdate(opts)
+	if err == nil || !strings.Contains(err.Error(), "same platform linux-amd64") {
+		t.Errorf("Expected an error about the duplicate platform, got %v", err)
+	}
+	// both inputs are named, and the first in name order is still published
+	if err != nil && !strings.Contains(err.Error(), filepath.Join(input, "linux-x86_64")+": same platform linux-amd64 as "+filepath.Join(input, "linux-amd64")) {
+		t.Errorf("Expected the error to name both inputs, got %v", err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); c.Version != "1.1.0" {
+		t.Errorf("Expected the first input of linux-amd64 to be published, got %+v", c)
+	}
+
+	dir = t.TempDir()
+	opts = Options{InputPath: input, Version: "1.0.0", OutputDir: dir, NoNormalizePlatform: true}
+	if err := GenerateUpdate(opts); err != nil {
+		t.Fatalf("GenerateUpdate with NoNormalizePlatform returned error: %s", err)
+	}
+	if c := readManifest(t, dir, "linux-x86_64"); c.Version != "1.0.0" {
+		t.Errorf("Expected linux-x86_64 to keep its name, got %+v", c)
+	}
+}
+
+// dirRequester serves the requests of a selfupdate.Updater from the files
+// below a directory, like a static web server would.
+type dirRequester string
+
+func (d dirRequester) Fetch(url string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(strings.TrimPrefix(url, "https://updates.example.com/"))))
+}
+
+func TestGenerateUpdateClientRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	publish(t, Options{OutputDir: filepath.Join(root, "myapp"), Platform: platform}, "1.0.0", "1.1.0")
+
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.0.0",
+		ApiURL:         "https://updates.example.com/",
+		BinURL:         "https://updates.example.com/",
+		DiffURL:        "https://updates.example.com/",
+		CmdName:        "myapp",
+		Requester:      dirRequester(root),
+	}
+	version, err := u.UpdateAvailable()
+	if err != nil {
+		t.Fatalf("UpdateAvailable returned error: %s", err)
+	}
+	if version != "1.1.0" {
+		t.Errorf("Expected the client to see 1.1.0, got %q", version)
+	}
+	c := readManifest(t, filepath.Join(root, "myapp"), platform)
+	if u.Info.Hash.Algo != c.Hash.Algo || !bytes.Equal(u.Info.Hash.Value, c.Hash.Value) {
+		t.Errorf("Expected the client to read hash %+v, got %+v", c.Hash, u.Info.Hash)
+	}
+	if u.Info.PatchLengths["1.0.0"] != c.PatchLengths["1.0.0"] {
+		t.Errorf("Expected the client to read the patch length %d, got %d", c.PatchLengths["1.0.0"], u.Info.PatchLengths["1.0.0"])
+	}
+}
+
+func TestGenerateUpdateChannel(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "myapp")
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	publish(t, Options{OutputDir: dir, Platform: platform}, "1.0.0", "1.1.0")
+	publish(t, Options{OutputDir: dir, Platform: platform, Channel: "beta"}, "1.2.0-beta.1", "1.2.0-beta.2")
+
+	if c := readManifest(t, dir, platform); c.Version != "1.1.0" {
+		t.Errorf("Expected the stable manifest to stay at 1.1.0, got %s", c.Version)
+	}
+	c := readManifest(t, filepath.Join(dir, "beta"), platform)
+	if c.Version != "1.2.0-beta.2" {
+		t.Errorf("Expected the beta manifest at 1.2.0-beta.2, got %s", c.Version)
+	}
+	if len(c.PatchLengths) != 1 || c.PatchLengths["1.2.0-beta.1"] == 0 {
+		t.Errorf("Expected only a patch from the previous beta, got %v", c.PatchLengths)
+	}
+
+	for channel, want := range map[string]string{"": "1.1.0", "beta": "1.2.0-beta.2"} {
+		u := &selfupdate.Updater{
+			CurrentVersion: "1.0.0",
+			ApiURL:         "https://updates.example.com/",
+			CmdName:        "myapp",
+			Channel:        channel,
+			Requester:      dirRequester(root),
+		}
+		version, err := u.UpdateAvailable()
+		if err != nil {
+			t.Fatalf("UpdateAvailable(%q) returned error: %s", channel, err)
+		}
+		if version != want {
+			t.Errorf("Expected channel %q to offer %s, got %q", channel, want, version)
+		}
+	}
+
+	if err := (&Options{InputPath: "myapp", Version: "1.0.0", OutputDir: dir, Channel: "../beta"}).Validate(); err == nil {
+		t.Error("Expected an error for a channel that isn't a directory name")
+	}
+}
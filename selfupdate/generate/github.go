@@ -0,0 +1,168 @@
+package generate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// GitHubUploader attaches the files to a GitHub release as assets. Asset
+// names can't contain slashes, so the path of each file is flattened by
+// joining its elements with "_", which is what selfupdate.Updater fetches
+// with FlatNames set. Uploading an asset that already exists replaces it,
+// so a run can be repeated.
+//
+// A GitHubUploader is not safe for concurrent use.
+type GitHubUploader struct {
+	Repo  string // owner/name
+	Tag   string // Tag of the release, created along with it if missing
+	Token string
+	// APIURL is the REST API root. Defaults to https://api.github.com.
+	APIURL string
+
+	// Client is used for the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	release *githubRelease
+}
+
+type githubRelease struct {
+	ID        int64
+	UploadURL string `json:"upload_url"`
+	Assets    []githubAsset
+}
+
+type githubAsset struct {
+	ID   int64
+	Name string
+}
+
+// NewGitHubUploader returns an uploader to the release tagged tag in repo,
+// with the token taken from GITHUB_TOKEN or GH_TOKEN and the API root from
+// GITHUB_API_URL, as set in GitHub Actions.
+func NewGitHubUploader(repo, tag string) (*GitHubUploader, error) {
+	if owner, name, ok := strings.Cut(repo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid GitHub repository %q: want owner/name", repo)
+	}
+	if tag == "" {
+		return nil, errors.New("no GitHub release tag")
+	}
+	g := &GitHubUploader{Repo: repo, Tag: tag, Token: firstEnv("GITHUB_TOKEN", "GH_TOKEN"), APIURL: os.Getenv("GITHUB_API_URL")}
+	if g.Token == "" {
+		return nil, errors.New("no GitHub token: set GITHUB_TOKEN or GH_TOKEN")
+	}
+	return g, nil
+}
+
+// AssetName returns the name of the asset holding the file at the slash
+// separated path name.
+func AssetName(name string) string {
+	return strings.ReplaceAll(name, "/", "_")
+}
+
+// Upload attaches the file at localPath as AssetName(name), replacing an
+// existing asset of that name.
+func (g *GitHubUploader) Upload(name, localPath, contentType string) error {
+	body, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	if g.release == nil {
+		if g.release, err = g.findOrCreateRelease(); err != nil {
+			return err
+		}
+	}
+	asset := AssetName(name)
+	for i, a := range g.release.Assets {
+		if a.Name != asset {
+			continue
+		}
+		if err := g.do(http.MethodDelete, g.api("releases/assets/%d", a.ID), "", nil, nil); err != nil {
+			return fmt.Errorf("replacing asset %s: %w", asset, err)
+		}
+		g.release.Assets = append(g.release.Assets[:i], g.release.Assets[i+1:]...)
+		break
+	}
+
+	// upload_url is a URI template like https://uploads.github.com/.../assets{?name,label}
+	uploadURL, _, _ := strings.Cut(g.release.UploadURL, "{")
+	var uploaded githubAsset
+	if err := g.do(http.MethodPost, uploadURL+"?name="+url.QueryEscape(asset), contentType, body, &uploaded); err != nil {
+		return fmt.Errorf("uploading asset %s: %w", asset, err)
+	}
+	g.release.Assets = append(g.release.Assets, uploaded)
+	return nil
+}
+
+// findOrCreateRelease returns the release tagged Tag, creating it if there
+// is none yet.
+func (g *GitHubUploader) findOrCreateRelease() (*githubRelease, error) {
+	var r githubRelease
+	err := g.do(http.MethodGet, g.api("releases/tags/%s", url.PathEscape(g.Tag)), "", nil, &r)
+	var statusErr *githubStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		req, _ := json.Marshal(map[string]string{"tag_name": g.Tag, "name": g.Tag})
+		err = g.do(http.MethodPost, g.api("releases"), "application/json", req, &r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("finding release %s of %s: %w", g.Tag, g.Repo, err)
+	}
+	return &r, nil
+}
+
+func (g *GitHubUploader) api(format string, args ...interface{}) string {
+	root := g.APIURL
+	if root == "" {
+		root = "https://api.github.com"
+	}
+	return strings.TrimSuffix(root, "/") + "/repos/" + g.Repo + "/" + fmt.Sprintf(format, args...)
+}
+
+// githubStatusError is returned for a response that isn't a success.
+type githubStatusError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *githubStatusError) Error() string {
+	return e.Status + ": " + e.Message
+}
+
+// do sends a request to the API and decodes the JSON response into out,
+// unless it is nil.
+func (g *GitHubUploader) do(method, target, contentType string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+g.Token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &githubStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
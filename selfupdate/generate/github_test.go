@@ -0,0 +1,126 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+// fakeGitHub serves the release endpoints used by GitHubUploader for a
+// single release, and its assets at /download/<name>.
+type fakeGitHub struct {
+	mu      sync.Mutex
+	srv     *httptest.Server
+	created bool
+	assets  map[string][]byte
+	ids     map[int64]string
+	deleted []string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" && !strings.HasPrefix(r.URL.Path, "/download/") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	release := func() {
+		var assets []githubAsset
+		for id, name := range f.ids {
+			assets = append(assets, githubAsset{ID: id, Name: name})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1, "upload_url": f.srv.URL + "/upload{?name,label}", "assets": assets})
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/app/releases/tags/v1.1.0":
+		if !f.created {
+			http.NotFound(w, r)
+			return
+		}
+		release()
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/app/releases":
+		f.created = true
+		w.WriteHeader(http.StatusCreated)
+		release()
+	case r.Method == http.MethodPost && r.URL.Path == "/upload":
+		name := r.URL.Query().Get("name")
+		for _, existing := range f.ids {
+			if existing == name {
+				http.Error(w, "already_exists", http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		f.assets[name], _ = io.ReadAll(r.Body)
+		id := int64(len(f.ids) + len(f.deleted) + 1)
+		f.ids[id] = name
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(githubAsset{ID: id, Name: name})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/repos/owner/app/releases/assets/"):
+		var id int64
+		fmt.Sscan(strings.TrimPrefix(r.URL.Path, "/repos/owner/app/releases/assets/"), &id)
+		f.deleted = append(f.deleted, f.ids[id])
+		delete(f.ids, id)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/download/"):
+		b, ok := f.assets[strings.TrimPrefix(r.URL.Path, "/download/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	default:
+		http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+func TestGitHubUpload(t *testing.T) {
+	f := &fakeGitHub{assets: map[string][]byte{}, ids: map[int64]string{}}
+	f.srv = httptest.NewServer(f)
+	defer f.srv.Close()
+
+	t.Setenv("GITHUB_TOKEN", "token")
+	t.Setenv("GITHUB_API_URL", f.srv.URL)
+	dir := t.TempDir()
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	publish(t, Options{OutputDir: dir, Platform: platform}, "1.0.0")
+	for i := 0; i < 2; i++ {
+		// a repeated run replaces the assets
+		up, err := NewGitHubUploader("owner/app", "v1.1.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		publish(t, Options{OutputDir: dir, Platform: platform, Upload: up, Force: true}, "1.1.0")
+	}
+
+	for _, name := range []string{"1.1.0_" + platform + ".gz", "1.0.0_1.1.0_" + platform, platform + ".json"} {
+		if _, ok := f.assets[name]; !ok {
+			t.Errorf("Expected asset %s, got %v", name, f.ids)
+		}
+	}
+	if len(f.deleted) != len(f.ids) {
+		t.Errorf("Expected every asset to be replaced once, deleted %v", f.deleted)
+	}
+
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.0.0",
+		ApiURL:         f.srv.URL + "/download/",
+		CmdName:        "app",
+		FlatNames:      true,
+	}
+	version, err := u.UpdateAvailable()
+	if err != nil || version != "1.1.0" {
+		t.Errorf("Expected the client to find 1.1.0, got %q, %v", version, err)
+	}
+
+	if _, err := NewGitHubUploader("app", "v1"); err == nil {
+		t.Error("Expected an error for a repository without owner")
+	}
+}
@@ -0,0 +1,122 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultPlatformRegexp finds the platform in names like myapp-linux-amd64
+// or myapp_windows_x86_64.exe: a GOOS, then the architecture up to the end
+// of the name.
+var defaultPlatformRegexp = regexp.MustCompile(`(?:^|[-_.])(?P<os>aix|android|darwin|dragonfly|freebsd|illumos|ios|js|linux|netbsd|openbsd|plan9|solaris|wasip1|windows)[-_](?P<arch>[A-Za-z0-9_]+?)(?:\.exe)?$`)
+
+// isGlob reports whether path contains glob metacharacters.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// globPlatform returns the platform of the input file name, or false if
+// the name has none. With PlatformRegex it is taken from the "platform"
+// group, the "os" and "arch" groups or the first group, in that order.
+func (g *generator) globPlatform(name string) (string, bool) {
+	re := g.platformRegexp
+	m := re.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	var goos, arch string
+	for i, group := range re.SubexpNames() {
+		switch group {
+		case "platform":
+			return m[i], m[i] != ""
+		case "os":
+			goos = m[i]
+		case "arch":
+			arch = m[i]
+		}
+	}
+	if goos != "" && arch != "" {
+		return goos + "-" + arch, true
+	}
+	return m[1], m[1] != ""
+}
+
+// globInput is a file matching the input glob, with its platform.
+type globInput struct {
+	path     string
+	platform string
+}
+
+// globInputs returns the files matching pattern that are published, and
+// the errors for those that conflict. Skipped files are reported if report
+// is set.
+func (g *generator) globInputs(pattern string, report bool) ([]globInput, []error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, []error{err}
+	}
+	if len(matches) == 0 {
+		return nil, []error{fmt.Errorf("no files match %s", pattern)}
+	}
+	skip := func(path, reason string) {
+		if report {
+			g.log.Printf("%s %s, skipped", path, reason)
+			g.summary.skipped("", path, reason)
+		}
+	}
+	var (
+		inputs  []globInput
+		seen    = map[string]string{} // path by platform
+		errList []error
+	)
+	for _, path := range matches {
+		name := filepath.Base(path)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			errList = append(errList, err)
+			continue
+		}
+		if !g.matchesFilters(name) {
+			skip(path, "doesn't match -include/-exclude")
+			continue
+		}
+		if reason := g.notABinary(path, fs.FileInfoToDirEntry(fi)); reason != "" {
+			skip(path, reason)
+			continue
+		}
+		if g.InputCompressed {
+			name = strings.TrimSuffix(name, ".gz")
+		}
+		platform, ok := g.globPlatform(name)
+		if !ok {
+			skip(path, "has no platform in its name")
+			continue
+		}
+		platform = g.platformName(platform)
+		if other, ok := seen[platform]; ok {
+			errList = append(errList, fmt.Errorf("%s: same platform %s as %s, skipped", path, platform, other))
+			continue
+		}
+		seen[platform] = path
+		inputs = append(inputs, globInput{path: path, platform: platform})
+	}
+	return inputs, errList
+}
+
+// runGlob publishes every file matching pattern, taking the platform from
+// its name, see globPlatform.
+func (g *generator) runGlob(pattern string) error {
+	inputs, errList := g.globInputs(pattern, true)
+	errList = append(errList, runWorkers(g.log, g.PlatformWorkers, g.Ordered, inputs, func(in globInput, log *Logger) error {
+		if err := g.withLog(log).createUpdate(in.path, in.platform); err != nil {
+			return fmt.Errorf("%s: %w", in.path, err)
+		}
+		return nil
+	})...)
+	return errors.Join(errList...)
+}
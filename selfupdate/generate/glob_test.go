@@ -0,0 +1,64 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobPlatform(t *testing.T) {
+	tests := []struct {
+		regex, name, want string
+	}{
+		{"", "myapp-linux-amd64", "linux-amd64"},
+		{"", "myapp_linux_x86_64", "linux-x86_64"},
+		{"", "myapp-windows-amd64.exe", "windows-amd64"},
+		{"", "darwin-arm64", "darwin-arm64"},
+		{"", "myapp-v1.2", ""},
+		{"", "myapp-linux-amd64.sha256", ""},
+		{`^myapp\.(?P<platform>\w+-\w+)$`, "myapp.linux-arm64", "linux-arm64"},
+		{`^(?P<arch>\w+)\.(?P<os>\w+)$`, "amd64.freebsd", "freebsd-amd64"},
+		{`^app-(.+)\.bin$`, "app-linux-386.bin", "linux-386"},
+		{`^app-(.+)\.bin$`, "other", ""},
+	}
+	for _, tt := range tests {
+		g, err := newGenerator(Options{InputPath: "x", Version: "1.0.0", OutputDir: "x", PlatformRegex: tt.regex})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := g.globPlatform(tt.name)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("globPlatform(%q) with %q = %q, %v; want %q", tt.name, tt.regex, got, ok, tt.want)
+		}
+	}
+
+	if err := (&Options{InputPath: "x", Version: "1.0.0", OutputDir: "x", PlatformRegex: "linux"}).Validate(); err == nil {
+		t.Error("Expected an error for a platform regex without a group")
+	}
+}
+
+func TestGenerateUpdateGlob(t *testing.T) {
+	in := t.TempDir()
+	for _, name := range []string{"myapp-linux-amd64", "myapp-linux-x86_64", "myapp-windows-amd64.exe", "myapp-notes"} {
+		if err := os.WriteFile(filepath.Join(in, name), []byte(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	s, err := GenerateUpdateSummary(Options{InputPath: filepath.Join(in, "myapp-*"), Version: "1.0.0", OutputDir: dir})
+	if err == nil {
+		t.Error("Expected an error for the two linux-amd64 binaries")
+	}
+	for _, f := range []string{"linux-amd64.json", "windows-amd64.json", "1.0.0/windows-amd64.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("Expected %s to exist: %s", f, err)
+		}
+	}
+	if len(s.Skipped) != 1 || s.Skipped[0].Item != filepath.Join(in, "myapp-notes") {
+		t.Errorf("Expected myapp-notes to be skipped, got %+v", s.Skipped)
+	}
+
+	if err := GenerateUpdate(Options{InputPath: filepath.Join(in, "none-*"), Version: "1.0.0", OutputDir: dir}); err == nil {
+		t.Error("Expected an error for a glob without matches")
+	}
+}
@@ -0,0 +1,68 @@
+package generate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// readPatchIndex returns the patch index of platform in version, or nil if
+// there is none or it can't be read.
+func (g *generator) readPatchIndex(version, platform string) *patchIndex {
+	b, err := os.ReadFile(filepath.Join(g.OutputDir, version, platform+".patches.json"))
+	if err != nil {
+		return nil
+	}
+	var index patchIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil
+	}
+	return &index
+}
+
+// storedSum returns the sha256 of the full binary of platform in dir, as
+// stored, which is cheaper than decompressing it.
+func storedSum(dir, platform string) ([]byte, error) {
+	f, err := os.Open(filepath.Join(dir, platform+formatExt["gzip"]))
+	if os.IsNotExist(err) {
+		f, err = os.Open(filepath.Join(dir, platform+formatExt["zstd"]))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// reusablePatch returns the entry of prev, the patch index written by an
+// earlier run, for the patch from version if it can be kept as it is: it
+// was generated from the same stored full binary, to a binary with the
+// same checksum as sum, in the current patch format and diff algorithm,
+// and the patch file is intact.
+func (g *generator) reusablePatch(prev *patchIndex, sum []byte, from string, fromSum []byte, platform string) (patchEntry, bool) {
+	compression := ""
+	if g.PatchFormat != "none" {
+		compression = g.PatchFormat
+	}
+	if prev == nil || prev.Hash.Algo != g.Hash || !bytes.Equal(prev.Hash.Value, sum) || prev.Compression != compression || prev.DiffAlgo != diffAlgoName(g.DiffAlgo) {
+		return patchEntry{}, false
+	}
+	for _, p := range prev.Patches {
+		if p.From != from || p.FromSha256 == nil || !bytes.Equal(p.FromSha256, fromSum) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(g.OutputDir, from, prev.Version, platform+formatExt[g.PatchFormat]))
+		if err != nil || !bytes.Equal(sha256Sum(b), p.Sha256) {
+			return patchEntry{}, false
+		}
+		return p, true
+	}
+	return patchEntry{}, false
+}
@@ -0,0 +1,189 @@
+package generate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+// indexFile is the name of the multi-platform manifest in OutputDir.
+const indexFile = "index.json"
+
+// latestFile is the name of the pointer to the newest version in OutputDir.
+const latestFile = "latest.json"
+
+// index is an overview of the published platforms, written to
+// OutputDir/index.json after every run.
+type index struct {
+	Version     string // Version published by the run that wrote the index
+	GeneratedAt time.Time
+	Platforms   map[string]indexEntry // Keyed by platform
+}
+
+// latest points at the newest published version, so clients can check for
+// a newer release without knowing any version up front.
+type latest struct {
+	Version     string
+	GeneratedAt time.Time
+	Platforms   []string // Platforms whose manifest, at Platform.json, is at Version
+}
+
+// indexEntry summarizes the manifest of a platform.
+type indexEntry struct {
+	Version          string
+	Hash             digest
+	Compression      string
+	Length           int64
+	CompressedLength int64
+	GeneratedAt      time.Time
+}
+
+// isPlatformManifest reports whether name, a file in the root of OutputDir,
+// is the manifest of a platform.
+func isPlatformManifest(name string) bool {
+	return filepath.Ext(name) == ".json" && name != indexFile && name != latestFile
+}
+
+// manifestSet holds the manifests written by a run, keyed by platform.
+type manifestSet struct {
+	sync.Mutex
+	m map[string]current
+}
+
+// recordManifest remembers the manifest written for platform by this run.
+func (g *generator) recordManifest(platform string, c current) {
+	g.manifests.Lock()
+	defer g.manifests.Unlock()
+	g.manifests.m[platform] = c
+}
+
+// published reports whether this run wrote any manifest.
+func (g *generator) published() bool {
+	g.manifests.Lock()
+	defer g.manifests.Unlock()
+	return len(g.manifests.m) > 0
+}
+
+// writeIndex writes index.json covering every platform manifest in
+// OutputDir, so platforms published by earlier runs stay listed, and the
+// files derived from it.
+func (g *generator) writeIndex() error {
+	idx := index{Version: g.Version, GeneratedAt: g.GeneratedAt, Platforms: map[string]indexEntry{}}
+
+	entries, err := os.ReadDir(g.OutputDir)
+	if err != nil && !(g.DryRun && os.IsNotExist(err)) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isPlatformManifest(entry.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(g.OutputDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var c current
+		if err := json.Unmarshal(b, &c); err != nil {
+			// not every json file next to the manifests has to be ours
+			g.log.Printf("%s is not a manifest, left out of %s: %s", entry.Name(), indexFile, err)
+			continue
+		}
+		idx.Platforms[strings.TrimSuffix(entry.Name(), ".json")] = newIndexEntry(c)
+	}
+	// in dry-run mode the manifests of this run only exist in memory
+	g.manifests.Lock()
+	for platform, c := range g.manifests.m {
+		idx.Platforms[platform] = newIndexEntry(c)
+	}
+	g.manifests.Unlock()
+
+	b, err := json.MarshalIndent(idx, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := g.writeFile(filepath.Join(g.OutputDir, indexFile), b, false); err != nil {
+		return err
+	}
+	if g.AppcastURL != "" {
+		if err := g.writeAppcast(idx); err != nil {
+			return err
+		}
+	}
+	return g.writeLatest(idx)
+}
+
+// writeLatest writes latest.json pointing at the newest version in idx,
+// see newestVersion. Nothing is written if idx lists no platform.
+func (g *generator) writeLatest(idx index) error {
+	newest := newestVersion(idx)
+	if newest == "" {
+		return nil
+	}
+
+	l := latest{Version: newest, GeneratedAt: g.GeneratedAt, Platforms: []string{}}
+	for platform, e := range idx.Platforms {
+		if e.Version == newest {
+			l.Platforms = append(l.Platforms, platform)
+		}
+	}
+	sort.Strings(l.Platforms)
+
+	b, err := json.MarshalIndent(l, "", "    ")
+	if err != nil {
+		return err
+	}
+	// written atomically like every file, so the pointer flips cleanly
+	return g.writeFile(filepath.Join(g.OutputDir, latestFile), b, false)
+}
+
+// newestVersion returns the newest version the platforms of idx are at.
+// Versions are compared as semver when they all are one. Otherwise the
+// version of the run that wrote idx is taken to be the newest if a
+// platform is at it, and the most recently generated one if not.
+func newestVersion(idx index) string {
+	allSemver := true
+	for _, e := range idx.Platforms {
+		if !selfupdate.IsSemver(e.Version) {
+			allSemver = false
+		}
+	}
+	var newest indexEntry
+	for _, e := range idx.Platforms {
+		switch {
+		case newest.Version == "":
+		case allSemver:
+			if c, _ := selfupdate.CompareVersions(e.Version, newest.Version); c <= 0 {
+				continue
+			}
+		case newest.Version == idx.Version:
+			continue
+		case e.Version == idx.Version:
+		case !e.GeneratedAt.After(newest.GeneratedAt) && !(e.GeneratedAt.Equal(newest.GeneratedAt) && e.Version > newest.Version):
+			continue
+		}
+		newest = e
+	}
+	return newest.Version
+}
+
+func newIndexEntry(c current) indexEntry {
+	h := c.Hash
+	if h.Algo == "" {
+		// written by a generator predating Hash
+		h = digest{Algo: "sha256", Value: c.Sha256}
+	}
+	return indexEntry{
+		Version:          c.Version,
+		Hash:             h,
+		Compression:      c.Compression,
+		Length:           c.Length,
+		CompressedLength: c.CompressedLength,
+		GeneratedAt:      c.GeneratedAt,
+	}
+}
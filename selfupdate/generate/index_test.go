@@ -0,0 +1,104 @@
+package generate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteIndex(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0")
+	// a later run adding another platform keeps linux-amd64 listed
+	publish(t, Options{OutputDir: dir, Platform: "darwin-arm64", Format: "zstd"}, "1.1.0")
+
+	b, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idx index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Version != "1.1.0" || len(idx.Platforms) != 2 {
+		t.Fatalf("Unexpected index %+v", idx)
+	}
+	for platform, compression := range map[string]string{"linux-amd64": "gzip", "darwin-arm64": "zstd"} {
+		e := idx.Platforms[platform]
+		c := readManifest(t, dir, platform)
+		if e.Version != "1.1.0" || e.Compression != compression || e.Length != c.Length || string(e.Hash.Value) != string(c.Hash.Value) {
+			t.Errorf("%s: entry %+v doesn't match its manifest", platform, e)
+		}
+	}
+}
+
+func TestWriteLatest(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.2.0")
+	publish(t, Options{OutputDir: dir, Platform: "darwin-arm64"}, "1.2.0")
+	// a hotfix of an older line doesn't move the pointer back
+	publish(t, Options{OutputDir: dir, Platform: "windows-amd64"}, "1.1.5")
+
+	b, err := os.ReadFile(filepath.Join(dir, latestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l latest
+	if err := json.Unmarshal(b, &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Version != "1.2.0" || len(l.Platforms) != 2 || l.Platforms[0] != "darwin-arm64" || l.Platforms[1] != "linux-amd64" {
+		t.Errorf("Unexpected latest %+v", l)
+	}
+}
+
+func TestWriteLatestFailedRun(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0")
+	before, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a run failing before anything is published leaves the index and
+	// latest.json alone
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "missing"), Version: "2.0.0", OutputDir: dir, Platform: "linux-amd64"}
+	if err := GenerateUpdate(opts); err == nil {
+		t.Fatal("Expected an error for a missing input")
+	}
+	if after, _ := os.ReadFile(filepath.Join(dir, indexFile)); string(after) != string(before) {
+		t.Errorf("Expected the index to be unchanged, got %s", after)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, latestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l latest
+	if err := json.Unmarshal(b, &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Version != "1.0.0" || len(l.Platforms) != 1 {
+		t.Errorf("Expected latest to stay at 1.0.0, got %+v", l)
+	}
+}
+
+func TestNewestVersion(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tt := range []struct {
+		run       string
+		platforms map[string]indexEntry
+		want      string
+	}{
+		{"2.0.0", nil, ""},
+		{"2.0.0", map[string]indexEntry{"linux-amd64": {Version: "1.0.0"}}, "1.0.0"},
+		{"1.1.5", map[string]indexEntry{"linux-amd64": {Version: "1.2.0"}, "windows-amd64": {Version: "1.1.5"}}, "1.2.0"},
+		{"build-3", map[string]indexEntry{"linux-amd64": {Version: "build-20", GeneratedAt: at.Add(time.Hour)}, "windows-amd64": {Version: "build-3", GeneratedAt: at}}, "build-3"},
+		{"build-4", map[string]indexEntry{"linux-amd64": {Version: "build-20", GeneratedAt: at.Add(time.Hour)}, "windows-amd64": {Version: "build-3", GeneratedAt: at}}, "build-20"},
+	} {
+		if got := newestVersion(index{Version: tt.run, Platforms: tt.platforms}); got != tt.want {
+			t.Errorf("newestVersion(%s, %v) = %q, want %q", tt.run, tt.platforms, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+// ParseLayout parses a comma separated list of file=template pairs into a
+// selfupdate.Layout, as given to -layout. The files are manifest,
+// version-manifest, binary, patch and patch-index; those not listed keep
+// the default layout.
+func ParseLayout(s string) (selfupdate.Layout, error) {
+	var l selfupdate.Layout
+	if s == "" {
+		return l, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		file, template, ok := strings.Cut(pair, "=")
+		if !ok {
+			return l, fmt.Errorf("invalid layout %q: want file=template", pair)
+		}
+		var t *string
+		switch file {
+		case "manifest":
+			t = &l.Manifest
+		case "version-manifest":
+			t = &l.VersionManifest
+		case "binary":
+			t = &l.Binary
+		case "patch":
+			t = &l.Patch
+		case "patch-index":
+			t = &l.PatchIndex
+		default:
+			return l, fmt.Errorf("unknown layout file %q: want manifest, version-manifest, binary, patch or patch-index", file)
+		}
+		if *t != "" {
+			return l, fmt.Errorf("layout of %s given twice", file)
+		}
+		*t = template
+	}
+	return l, l.Validate()
+}
+
+// publishedName returns the name of the file at the slash separated path
+// name relative to OutputDir, in the default layout, in Layout. Files
+// clients don't fetch by version and platform keep their name.
+func (g *generator) publishedName(name string) string {
+	if g.Layout == (selfupdate.Layout{}) {
+		return name
+	}
+	sig := ""
+	if strings.HasSuffix(name, ".json.sig") {
+		sig = ".sig"
+		name = strings.TrimSuffix(name, sig)
+	}
+	parts := strings.Split(name, "/")
+	file := parts[len(parts)-1]
+	switch {
+	case len(parts) == 1 && isPlatformManifest(file):
+		return g.Layout.ManifestPath(strings.TrimSuffix(file, ".json")) + sig
+	case len(parts) == 2 && strings.HasSuffix(file, ".patches.json"):
+		return g.Layout.PatchIndexPath(parts[0], strings.TrimSuffix(file, ".patches.json"))
+	case len(parts) == 2 && strings.HasSuffix(file, ".json"):
+		return g.Layout.VersionManifestPath(parts[0], strings.TrimSuffix(file, ".json")) + sig
+	case len(parts) == 2 && sig == "":
+		platform, ext := splitArtifactExt(file)
+		return g.Layout.BinaryPath(parts[0], platform, ext)
+	case len(parts) == 3 && sig == "":
+		platform, ext := splitArtifactExt(file)
+		return g.Layout.PatchPath(parts[0], parts[1], platform, ext)
+	}
+	return name + sig
+}
+
+// splitArtifactExt splits the name of a full binary or patch into the
+// platform and the extension of its compression, if any.
+func splitArtifactExt(name string) (string, string) {
+	for _, ext := range formatExt {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext), ext
+		}
+	}
+	return name, ""
+}
@@ -0,0 +1,120 @@
+package generate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+// dirUploader copies the uploaded files below Dir.
+type dirUploader struct {
+	Dir string
+}
+
+func (d dirUploader) Upload(name, localPath, _ string) error {
+	b, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+func TestLayout(t *testing.T) {
+	layout, err := ParseLayout("manifest=manifests/{platform}.json,version-manifest=manifests/{platform}-{version}.json,binary=bin/{platform}-{version},patch=patches/{platform}-{old}-{version},patch-index=manifests/{platform}-{version}.patches.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := t.TempDir()
+	dir := t.TempDir()
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	publish(t, Options{OutputDir: dir, Platform: platform, Upload: dirUploader{filepath.Join(served, "app")}, Layout: layout}, "1.0.0", "1.1.0")
+
+	for _, name := range []string{"manifests/" + platform + ".json", "manifests/" + platform + "-1.1.0.json", "manifests/" + platform + "-1.1.0.patches.json", "bin/" + platform + "-1.1.0.gz", "patches/" + platform + "-1.0.0-1.1.0", "index.json"} {
+		if _, err := os.Stat(filepath.Join(served, "app", filepath.FromSlash(name))); err != nil {
+			t.Errorf("Expected %s to be uploaded: %s", name, err)
+		}
+	}
+	// OutputDir keeps the default layout for later runs
+	if !hasFullBin(filepath.Join(dir, "1.1.0"), platform) {
+		t.Error("Expected OutputDir to keep the default layout")
+	}
+
+	var mu sync.Mutex
+	var requested []string
+	fs := http.FileServer(http.Dir(served))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		fs.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	target := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(target, []byte("binary 1.0.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	u := &selfupdate.Updater{
+		CurrentVersion: "1.0.0",
+		ApiURL:         srv.URL + "/",
+		BinURL:         srv.URL + "/",
+		DiffURL:        srv.URL + "/",
+		CmdName:        "app",
+		Layout:         layout,
+		TargetPath:     target,
+		Dir:            t.TempDir(),
+	}
+	if err := u.Update(); err != nil {
+		t.Fatalf("Update returned error: %s", err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "binary 1.1.0" {
+		t.Errorf("Expected the client to install 1.1.0, got %q", b)
+	}
+	if want := "/app/patches/" + platform + "-1.0.0-1.1.0"; !strings.Contains(strings.Join(requested, " "), want) {
+		t.Errorf("Expected the client to fetch %s, got %v", want, requested)
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	layout, err := ParseLayout("binary={platform}/{version}/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := layout.BinaryPath("1.2.0", "linux-amd64", ".gz"); got != "linux-amd64/1.2.0/app.gz" {
+		t.Errorf("BinaryPath = %q", got)
+	}
+	if got := layout.PatchPath("1.1.0", "1.2.0", "linux-amd64", ""); got != "1.1.0/1.2.0/linux-amd64" {
+		t.Errorf("Expected the default patch path, got %q", got)
+	}
+
+	for _, s := range []string{
+		"binary=bin/{platform}",
+		"patch={platform}-{version}",
+		"manifest={platform}-{version}.json",
+		"binary={date}/{platform}-{version}",
+		"manifest=/{platform}.json",
+		"binary=../{version}/{platform}",
+		"binary",
+		"sums={platform}",
+		"manifest={platform}.json,manifest=x/{platform}.json",
+	} {
+		if _, err := ParseLayout(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+
+	opts := Options{InputPath: "myapp", OutputDir: "public", Version: "1.0.0", Layout: layout}
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for a layout without uploads or appcast")
+	}
+}
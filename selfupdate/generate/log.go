@@ -0,0 +1,106 @@
+package generate
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LogLevel selects how much a Logger reports.
+type LogLevel int
+
+const (
+	LevelQuiet   LogLevel = iota // errors and the final summary only
+	LevelNormal                  // per-file progress
+	LevelVerbose                 // progress plus sizes and timings
+)
+
+// Logger writes progress to out and errors and warnings to errOut,
+// dropping messages above its level. It is safe for concurrent use.
+type Logger struct {
+	mu       sync.Mutex
+	out      io.Writer
+	errOut   io.Writer
+	level    LogLevel
+	buffered bool      // Keep messages in lines until flushed, see buffer
+	lines    []logLine // Kept messages
+}
+
+// logLine is a message kept by a buffered Logger.
+type logLine struct {
+	err  bool // Meant for errOut
+	text string
+}
+
+// NewLogger returns a Logger reporting up to level.
+func NewLogger(out, errOut io.Writer, level LogLevel) *Logger {
+	return &Logger{out: out, errOut: errOut, level: level}
+}
+
+func (l *Logger) write(toErr bool, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.emit(logLine{err: toErr, text: fmt.Sprintf(format+"\n", args...)})
+}
+
+// emit writes line, or keeps it if l is buffered. l.mu must be held.
+func (l *Logger) emit(line logLine) {
+	if l.buffered {
+		l.lines = append(l.lines, line)
+		return
+	}
+	w := l.out
+	if line.err {
+		w = l.errOut
+	}
+	io.WriteString(w, line.text)
+}
+
+// buffer returns a Logger at the level of l that keeps its messages until
+// they are passed on to l with flush.
+func (l *Logger) buffer() *Logger {
+	return &Logger{level: l.level, buffered: true}
+}
+
+// flush passes the messages kept by b on to l in one go, so they aren't
+// interleaved with others.
+func (l *Logger) flush(b *Logger) {
+	b.mu.Lock()
+	lines := b.lines
+	b.lines = nil
+	b.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range lines {
+		l.emit(line)
+	}
+}
+
+// Errorf reports an error at every level.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write(true, "go-selfupdate: "+format, args...)
+}
+
+// Warnf reports a warning at every level.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.write(true, "go-selfupdate: warning: "+format, args...)
+}
+
+// Summaryf reports the outcome of a run at every level.
+func (l *Logger) Summaryf(format string, args ...interface{}) {
+	l.write(false, format, args...)
+}
+
+// Printf reports progress unless quiet.
+func (l *Logger) Printf(format string, args ...interface{}) {
+	if l.level >= LevelNormal {
+		l.write(false, format, args...)
+	}
+}
+
+// Verbosef reports details such as sizes and timings in verbose mode.
+func (l *Logger) Verbosef(format string, args ...interface{}) {
+	if l.level >= LevelVerbose {
+		l.write(false, format, args...)
+	}
+}
@@ -0,0 +1,32 @@
+package generate
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	cases := []struct {
+		level   LogLevel
+		wantOut string
+	}{
+		{LevelQuiet, "summary\n"},
+		{LevelNormal, "progress\nsummary\n"},
+		{LevelVerbose, "progress\ndetail\nsummary\n"},
+	}
+	for _, c := range cases {
+		var out, errOut bytes.Buffer
+		l := NewLogger(&out, &errOut, c.level)
+		l.Printf("progress")
+		l.Verbosef("detail")
+		l.Errorf("failed")
+		l.Summaryf("summary")
+
+		if out.String() != c.wantOut {
+			t.Errorf("level %d: stdout = %q; want %q", c.level, out.String(), c.wantOut)
+		}
+		if errOut.String() != "go-selfupdate: failed\n" {
+			t.Errorf("level %d: stderr = %q; want the error", c.level, errOut.String())
+		}
+	}
+}
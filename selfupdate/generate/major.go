@@ -0,0 +1,39 @@
+package generate
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+// withoutOtherMajors drops the versions whose major version differs from
+// the one of Version from files, for SameMajorPatches. Versions that
+// aren't semver are kept.
+func (g *generator) withoutOtherMajors(files []fs.DirEntry, platform string) []fs.DirEntry {
+	major, _ := semverMajor(g.Version)
+	var kept []fs.DirEntry
+	for _, file := range files {
+		m, ok := semverMajor(file.Name())
+		if !file.IsDir() || file.Name() == g.Version || !ok || m == major {
+			kept = append(kept, file)
+			continue
+		}
+		if hasFullBin(filepath.Join(g.OutputDir, file.Name()), platform) {
+			g.log.Printf("%s is from major version %s, not %s, no patch for %s with -same-major-patches", file.Name(), m, major, platform)
+			g.summary.skipped(platform, file.Name(), "different major version, see -same-major-patches")
+		}
+	}
+	return kept
+}
+
+// semverMajor returns the major version of v. ok is false unless v is a
+// semantic version.
+func semverMajor(v string) (major string, ok bool) {
+	if !selfupdate.IsSemver(v) {
+		return "", false
+	}
+	major, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), ".")
+	return major, true
+}
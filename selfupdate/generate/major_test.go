@@ -0,0 +1,40 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateUpdateSameMajorPatches(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0", "v2.0.0")
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "2.1.0", OutputDir: dir, Platform: "linux-amd64", SameMajorPatches: true, DiffDepth: 1}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 2.1.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := GenerateUpdateSummary(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the older major versions don't count towards DiffDepth
+	if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 1 || c.PatchLengths["v2.0.0"] == 0 {
+		t.Errorf("Expected only the patch from v2.0.0, got %v", c.PatchLengths)
+	}
+	if len(s.Skipped) != 2 || s.Skipped[0].Item != "1.0.0" || s.Skipped[1].Item != "1.1.0" || !strings.Contains(s.Skipped[0].Reason, "major version") {
+		t.Errorf("Expected the older major versions in the summary, got %+v", s.Skipped)
+	}
+
+	// publishing a new major version makes no patch at all
+	publish(t, Options{OutputDir: dir, SameMajorPatches: true}, "3.0.0")
+	if c := readManifest(t, dir, "linux-amd64"); len(c.PatchLengths) != 0 {
+		t.Errorf("Expected no patches to 3.0.0, got %v", c.PatchLengths)
+	}
+
+	opts = Options{InputPath: "myapp", Version: "build-7", OutputDir: dir, AllowAnyVersion: true, SameMajorPatches: true}
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for a version that isn't semver")
+	}
+}
@@ -0,0 +1,76 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// matrixInputs returns the binaries below root in Matrix mode, each with
+// the platform named by the directories leading to it, and the errors for
+// those that conflict. Skipped files are reported if report is set.
+func (g *generator) matrixInputs(root string, report bool) ([]globInput, []error) {
+	skip := func(rel, reason string) {
+		if report {
+			g.log.Printf("%s %s, skipped", rel, reason)
+			g.summary.skipped("", rel, reason)
+		}
+	}
+	var (
+		inputs  []globInput
+		seen    = map[string]string{} // path by platform
+		errList []error
+	)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errList = append(errList, err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		dir := filepath.Dir(rel)
+		switch {
+		case !g.matchesFilters(d.Name()):
+			skip(rel, "doesn't match -include/-exclude")
+			return nil
+		case dir == ".":
+			skip(rel, "is not in a platform directory")
+			return nil
+		}
+		if reason := g.notABinary(path, d); reason != "" {
+			skip(rel, reason)
+			return nil
+		}
+		platform := g.platformName(strings.Join(strings.Split(filepath.ToSlash(dir), "/"), g.MatrixSep))
+		if other, ok := seen[platform]; ok {
+			errList = append(errList, fmt.Errorf("%s: same platform %s as %s, skipped", rel, platform, other))
+			return nil
+		}
+		seen[platform] = rel
+		inputs = append(inputs, globInput{path: path, platform: platform})
+		return nil
+	})
+	if err != nil {
+		errList = append(errList, err)
+	}
+	if len(inputs) == 0 && len(errList) == 0 {
+		errList = append(errList, fmt.Errorf("no binaries below %s", root))
+	}
+	return inputs, errList
+}
+
+// runMatrix publishes every binary below root, see Matrix.
+func (g *generator) runMatrix(root string) error {
+	inputs, errList := g.matrixInputs(root, true)
+	errList = append(errList, runWorkers(g.log, g.PlatformWorkers, g.Ordered, inputs, func(in globInput, log *Logger) error {
+		if err := g.withLog(log).createUpdate(in.path, in.platform); err != nil {
+			return fmt.Errorf("%s: %w", in.path, err)
+		}
+		return nil
+	})...)
+	return errors.Join(errList...)
+}
@@ -0,0 +1,45 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateUpdateMatrix(t *testing.T) {
+	in := t.TempDir()
+	for _, name := range []string{"linux/amd64/myapp", "linux/x86_64/myapp", "darwin/arm64/myapp", "darwin/arm64/README.md", "windows/amd64/myapp.exe", "notes.txt"} {
+		path := filepath.Join(in, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	s, err := GenerateUpdateSummary(Options{InputPath: in, Version: "1.0.0", OutputDir: dir, Matrix: true, Exclude: []string{"*.md"}})
+	if err == nil {
+		t.Error("Expected an error for the two linux-amd64 binaries")
+	}
+	for _, f := range []string{"linux-amd64.json", "darwin-arm64.json", "windows-amd64.json", "1.0.0/darwin-arm64.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("Expected %s to exist: %s", f, err)
+		}
+	}
+	if len(s.Skipped) != 2 {
+		t.Errorf("Expected README.md and notes.txt to be skipped, got %+v", s.Skipped)
+	}
+
+	dir = t.TempDir()
+	if err := GenerateUpdate(Options{InputPath: in, Version: "1.0.0", OutputDir: dir, Matrix: true, MatrixSep: "_", Include: []string{"myapp", "myapp.exe"}, NoNormalizePlatform: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "linux_x86_64.json")); err != nil {
+		t.Errorf("Expected the platform joined with the separator: %s", err)
+	}
+
+	if err := (&Options{InputPath: "x", Version: "1.0.0", OutputDir: "x", MatrixSep: "/"}).Validate(); err == nil {
+		t.Error("Expected an error for a separator containing a slash")
+	}
+}
@@ -0,0 +1,66 @@
+package generate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ParseMetadata parses key=value pairs, as given to -meta, into the
+// Metadata of Options. A key given twice is an error.
+func ParseMetadata(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid metadata %q: want key=value", pair)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("invalid metadata: key %q given twice", key)
+		}
+		m[key] = value
+	}
+	return m, validateMetadata(m)
+}
+
+// validateMetadata checks that every key of m starts with a letter,
+// followed by letters, digits, '.', '_' or '-', and isn't the name of a
+// manifest field, which it could be mistaken for.
+func validateMetadata(m map[string]string) error {
+	for key := range m {
+		if !validMetadataKey(key) {
+			return fmt.Errorf("invalid metadata key %q: want a letter followed by letters, digits, '.', '_' or '-'", key)
+		}
+		if manifestField(key) {
+			return fmt.Errorf("invalid metadata key %q: reserved for the manifest field of that name", key)
+		}
+	}
+	return nil
+}
+
+func validMetadataKey(key string) bool {
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return key != ""
+}
+
+// manifestField reports whether name is that of a field of the manifest,
+// ignoring case as clients decoding it do.
+func manifestField(name string) bool {
+	t := reflect.TypeOf(current{})
+	for i := 0; i < t.NumField(); i++ {
+		if strings.EqualFold(t.Field(i).Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package generate
+
+import "testing"
+
+func TestGenerateUpdateMetadata(t *testing.T) {
+	dir := t.TempDir()
+	meta, err := ParseMetadata([]string{"notes=https://example.com/1.1?a=b", "commit=abc123", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, Options{OutputDir: dir, Metadata: meta}, "1.1")
+	c := readManifest(t, dir, "linux-amd64")
+	if len(c.Metadata) != 3 || c.Metadata["notes"] != "https://example.com/1.1?a=b" || c.Metadata["commit"] != "abc123" {
+		t.Errorf("Expected the metadata in the manifest, got %v", c.Metadata)
+	}
+
+	publish(t, Options{OutputDir: dir}, "1.2")
+	if c := readManifest(t, dir, "linux-amd64"); c.Metadata != nil {
+		t.Errorf("Expected no metadata, got %v", c.Metadata)
+	}
+
+	for _, bad := range [][]string{
+		{"notes"},
+		{"=x"},
+		{"1st=x"},
+		{"a b=x"},
+		{"commit=a", "commit=b"},
+		{"version=2.0"},
+		{"Rollout=5"},
+		{"metadata=x"},
+	} {
+		if _, err := ParseMetadata(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+	opts := Options{InputPath: "myapp", Version: "1.0.0", OutputDir: dir, Metadata: map[string]string{"Hash": "x"}}
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for a reserved metadata key")
+	}
+}
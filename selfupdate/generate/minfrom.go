@@ -0,0 +1,42 @@
+package generate
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+// validateMinFrom checks MinFromVersion, which has to be a valid version
+// no newer than Version.
+func (o *Options) validateMinFrom() error {
+	if o.MinFromVersion == "" {
+		return nil
+	}
+	if err := validateVersion(o.MinFromVersion, o.AllowAnyVersion); err != nil {
+		return fmt.Errorf("min from version: %w", err)
+	}
+	if c, ok := selfupdate.CompareVersions(o.MinFromVersion, o.Version); ok && c > 0 {
+		return fmt.Errorf("invalid min from version %s: newer than the version %s", o.MinFromVersion, o.Version)
+	}
+	return nil
+}
+
+// withoutBelowMinFrom drops the versions older than MinFromVersion from
+// files, as their clients can't install Version directly and have no use
+// for a patch. Versions that can't be compared to it are kept.
+func (g *generator) withoutBelowMinFrom(files []fs.DirEntry, platform string) []fs.DirEntry {
+	var kept []fs.DirEntry
+	for _, file := range files {
+		if c, ok := selfupdate.CompareVersions(file.Name(), g.MinFromVersion); !file.IsDir() || !ok || c >= 0 {
+			kept = append(kept, file)
+			continue
+		}
+		if hasFullBin(filepath.Join(g.OutputDir, file.Name()), platform) {
+			g.log.Printf("%s is older than -min-from-version %s, no patch for %s", file.Name(), g.MinFromVersion, platform)
+			g.summary.skipped(platform, file.Name(), "older than -min-from-version")
+		}
+	}
+	return kept
+}
@@ -0,0 +1,31 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateUpdateMinFromVersion(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0")
+	publish(t, Options{OutputDir: dir, MinFromVersion: "1.1.0"}, "2.0.0")
+
+	c := readManifest(t, dir, "linux-amd64")
+	if c.MinFromVersion != "1.1.0" {
+		t.Errorf("Expected MinFromVersion 1.1.0, got %q", c.MinFromVersion)
+	}
+	if _, ok := c.PatchLengths["1.0.0"]; ok || c.PatchLengths["1.1.0"] == 0 {
+		t.Errorf("Expected only a patch from 1.1.0, got %v", c.PatchLengths)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.0.0", "2.0.0", "linux-amd64")); !os.IsNotExist(err) {
+		t.Errorf("Expected no patch from 1.0.0, got %v", err)
+	}
+
+	for _, from := range []string{"2.1.0", "not a version/"} {
+		opts := Options{InputPath: "myapp", Version: "2.0.0", OutputDir: dir, MinFromVersion: from}
+		if err := opts.Validate(); err == nil {
+			t.Errorf("Expected an error for min from version %q", from)
+		}
+	}
+}
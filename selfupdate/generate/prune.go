@@ -0,0 +1,417 @@
+package generate
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PruneOptions configures Prune.
+type PruneOptions struct {
+	// OutputDir is the directory holding the published versions.
+	OutputDir string
+	// Channel prunes the OutputDir/Channel subdirectory instead, see
+	// Options.Channel.
+	Channel string
+	// Keep is the number of newest versions kept.
+	Keep int
+	// KeepFor keeps versions modified within this duration.
+	KeepFor time.Duration
+	// VersionRegex is the regular expression the names of version
+	// directories match in full, see Options.VersionRegex. By default they
+	// have to be semantic versions. Other directories are left alone.
+	VersionRegex string
+	// AllowAnyVersion considers every directory that isn't a channel a
+	// version, see Options.AllowAnyVersion.
+	AllowAnyVersion bool
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+	// Storage removes the files, and writes the manifests and patch
+	// indexes rewritten, see Options.Storage. The versions to prune are
+	// still listed in OutputDir. Defaults to LocalStorage.
+	Storage Storage
+	// Signer signs the manifests rewritten to drop the patches from removed
+	// versions again, see Options.Signer. It is needed if they have a
+	// detached signature.
+	Signer crypto.Signer
+	// Logger receives progress and the summary. Nothing is logged when nil.
+	Logger *Logger
+}
+
+// Prune removes version directories from OutputDir that are neither among
+// the Keep newest versions nor modified within KeepFor. Versions referenced
+// by a platform manifest in OutputDir are always kept, and so is the
+// MinFromVersion of the manifests of every version kept. Patches into a
+// removed version, stored in the directories of older versions, are removed
+// too. The patches from a removed version are dropped from the manifests and
+// patch indexes kept, and from SHA256SUMS, before anything is removed, so
+// they never list a missing patch.
+func Prune(opts PruneOptions) error {
+	if opts.Keep <= 0 && opts.KeepFor <= 0 {
+		return fmt.Errorf("-prune needs -keep or -keep-for")
+	}
+	if err := validateChannel(opts.Channel); err != nil {
+		return err
+	}
+	g := &generator{Options: Options{OutputDir: filepath.Join(opts.OutputDir, opts.Channel), AllowAnyVersion: opts.AllowAnyVersion, DryRun: opts.DryRun, Storage: opts.Storage, Signer: opts.Signer}, log: opts.Logger}
+	if opts.VersionRegex != "" {
+		re, err := regexp.Compile(fullMatch(opts.VersionRegex))
+		if err != nil {
+			return fmt.Errorf("invalid version regex: %w", err)
+		}
+		g.versionRegexp = re
+	}
+	if g.Storage == nil {
+		g.Storage = LocalStorage{}
+	}
+	if g.log == nil {
+		g.log = NewLogger(io.Discard, io.Discard, LevelQuiet)
+	}
+	return g.prune(opts.Keep, opts.KeepFor)
+}
+
+func (g *generator) prune(keep int, keepFor time.Duration) error {
+	genDir := g.OutputDir
+
+	entries, err := os.ReadDir(genDir)
+	if err != nil {
+		return err
+	}
+
+	referenced, err := referencedVersions(genDir, entries)
+	if err != nil {
+		return err
+	}
+
+	var versions []fs.DirEntry
+	for _, entry := range entries {
+		switch {
+		case !entry.IsDir() || isChannelDir(filepath.Join(genDir, entry.Name())):
+		case g.isVersion(entry.Name()):
+			versions = append(versions, entry)
+		default:
+			g.log.Printf("%s is not named like a version, left alone", entry.Name())
+		}
+	}
+	sortNewestFirst(versions)
+
+	removing := map[string]bool{}
+	for i, entry := range versions {
+		name := entry.Name()
+		if referenced[name] || (keep > 0 && i < keep) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && keepFor > 0 && time.Since(info.ModTime()) < keepFor {
+			continue
+		}
+		removing[name] = true
+	}
+	if err := keepMinFrom(genDir, versions, removing); err != nil {
+		return err
+	}
+	var removed []string
+	for _, entry := range versions {
+		if removing[entry.Name()] {
+			removed = append(removed, entry.Name())
+		}
+	}
+
+	if len(removed) > 0 {
+		if err := g.dropPatchesFrom(genDir, entries, versions, removing); err != nil {
+			return err
+		}
+	}
+
+	var freed int64
+	for _, name := range removed {
+		paths := []string{filepath.Join(genDir, name)}
+		for _, entry := range versions {
+			patchDir := filepath.Join(genDir, entry.Name(), name)
+			if _, err := os.Stat(patchDir); err == nil {
+				paths = append(paths, patchDir)
+			}
+		}
+		for _, path := range paths {
+			size, err := dirSize(path)
+			if err != nil {
+				return err
+			}
+			if err := g.removeAll(path); err != nil {
+				return err
+			}
+			freed += size
+		}
+		g.log.Printf("Removed version %s", name)
+	}
+
+	verb := "Removed"
+	if g.DryRun {
+		verb = "Would remove"
+	}
+	g.log.Summaryf("%s %d versions (%s), freeing %d bytes", verb, len(removed), strings.Join(removed, ", "), freed)
+	return nil
+}
+
+// dropPatchesFrom rewrites the platform manifests in genDir, and the
+// manifests and patch indexes of the versions kept, without the patches
+// from the versions in removing. Nothing is written unless every file can
+// be rewritten, detached signatures included.
+func (g *generator) dropPatchesFrom(genDir string, entries, versions []fs.DirEntry, removing map[string]bool) error {
+	rewrites := map[string][]byte{}
+	rewrite := func(path string) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var out []byte
+		if strings.HasSuffix(path, ".patches.json") {
+			out, err = dropFromPatchIndex(b, removing)
+		} else {
+			out, err = dropFromManifest(b, removing)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if out == nil {
+			return nil
+		}
+		rewrites[path] = out
+		if _, err := os.Stat(path + ".sig"); err == nil {
+			if g.Signer == nil {
+				return fmt.Errorf("%s is signed, pruning needs the private key to rewrite it without the patches from removed versions", path)
+			}
+			sig, err := sign(g.Signer, out)
+			if err != nil {
+				return err
+			}
+			rewrites[path+".sig"] = sig
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isPlatformManifest(entry.Name()) {
+			if err := rewrite(filepath.Join(genDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range versions {
+		if removing[entry.Name()] {
+			continue
+		}
+		dir := filepath.Join(genDir, entry.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if !f.IsDir() && filepath.Ext(f.Name()) == ".json" {
+				if err := rewrite(filepath.Join(dir, f.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	sums, err := prunedChecksums(genDir, rewrites, removing)
+	if err != nil {
+		return err
+	}
+	if sums != nil {
+		rewrites[filepath.Join(genDir, "SHA256SUMS")] = sums
+	}
+
+	paths := make([]string, 0, len(rewrites))
+	for path := range rewrites {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if g.DryRun {
+			g.log.Printf("Would rewrite %s without the patches from removed versions", path)
+			continue
+		}
+		if err := g.Storage.Write(path, rewrites[path]); err != nil {
+			return err
+		}
+		g.log.Verbosef("Rewrote %s without the patches from removed versions", path)
+	}
+	return nil
+}
+
+// dropFromManifest returns the manifest b without the patches from the
+// versions in removing, or nil if it lists none.
+func dropFromManifest(b []byte, removing map[string]bool) ([]byte, error) {
+	var c current
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	changed := false
+	for from := range c.PatchLengths {
+		if removing[from] {
+			delete(c.PatchLengths, from)
+			changed = true
+		}
+	}
+	for from := range c.PatchSums {
+		if removing[from] {
+			delete(c.PatchSums, from)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return json.MarshalIndent(c, "", "    ")
+}
+
+// dropFromPatchIndex returns the patch index b without the patches from
+// the versions in removing, or nil if it lists none.
+func dropFromPatchIndex(b []byte, removing map[string]bool) ([]byte, error) {
+	var index patchIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, err
+	}
+	patches := index.Patches[:0]
+	for _, p := range index.Patches {
+		if !removing[p.From] {
+			patches = append(patches, p)
+		}
+	}
+	if len(patches) == len(index.Patches) {
+		return nil, nil
+	}
+	index.Patches = patches
+	return json.MarshalIndent(index, "", "    ")
+}
+
+// prunedChecksums returns SHA256SUMS of genDir without the files of the
+// versions in removing, and with the checksums of the files rewritten, or
+// nil if there is none or it doesn't change.
+func prunedChecksums(genDir string, rewrites map[string][]byte, removing map[string]bool) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(genDir, "SHA256SUMS"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		sum, path, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "  ")
+		if !ok {
+			out.WriteString(line)
+			continue
+		}
+		elems := strings.Split(path, "/")
+		// a version directory, or a patch into a version in the directory
+		// of an older one
+		if removing[elems[0]] || (len(elems) > 2 && removing[elems[1]]) {
+			continue
+		}
+		if data, ok := rewrites[filepath.Join(genDir, filepath.FromSlash(path))]; ok {
+			sum = fmt.Sprintf("%x", sha256.Sum256(data))
+		}
+		fmt.Fprintf(&out, "%s  %s\n", sum, path)
+	}
+	if out.String() == string(b) {
+		return nil, nil
+	}
+	return []byte(out.String()), nil
+}
+
+// isChannelDir reports whether dir holds a channel rather than a version:
+// only the root of a channel has an index.
+func isChannelDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, indexFile))
+	return err == nil
+}
+
+// referencedVersions returns the versions named by the platform manifests
+// among entries of genDir, and the MinFromVersion they require clients to
+// install first.
+func referencedVersions(genDir string, entries []fs.DirEntry) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isPlatformManifest(entry.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(genDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var c current
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		referenced[c.Version] = true
+		if c.MinFromVersion != "" {
+			referenced[c.MinFromVersion] = true
+		}
+	}
+	return referenced, nil
+}
+
+// keepMinFrom takes the MinFromVersion of the manifests of every version
+// kept out of removing, as clients told to install it first, or to install
+// a kept version with UpdateTo, need it, repeating for the versions it
+// keeps.
+func keepMinFrom(genDir string, versions []fs.DirEntry, removing map[string]bool) error {
+	for changed := true; changed; {
+		changed = false
+		for _, entry := range versions {
+			if removing[entry.Name()] {
+				continue
+			}
+			files, err := os.ReadDir(filepath.Join(genDir, entry.Name()))
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if f.IsDir() || filepath.Ext(f.Name()) != ".json" || strings.HasSuffix(f.Name(), ".patches.json") {
+					continue
+				}
+				b, err := os.ReadFile(filepath.Join(genDir, entry.Name(), f.Name()))
+				if err != nil {
+					return err
+				}
+				var c current
+				if err := json.Unmarshal(b, &c); err != nil {
+					return fmt.Errorf("%s: %w", filepath.Join(entry.Name(), f.Name()), err)
+				}
+				if removing[c.MinFromVersion] {
+					delete(removing, c.MinFromVersion)
+					changed = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// dirSize returns the total size of the regular files under path.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
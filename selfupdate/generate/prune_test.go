@@ -0,0 +1,180 @@
+package generate
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0", "1.2.0", "1.3.0")
+	// an older platform manifest still points at 1.0.0
+	publish(t, Options{OutputDir: dir, Platform: "windows-amd64"}, "1.0.0")
+	// channels live next to the versions and are left alone
+	publish(t, Options{OutputDir: dir, Channel: "beta"}, "1.4.0-beta.1")
+	// so are directories that aren't versions, and they don't count
+	// towards Keep
+	if err := os.MkdirAll(filepath.Join(dir, "tmp", "staging"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "tmp"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Prune(PruneOptions{OutputDir: dir, Keep: 1}); err != nil {
+		t.Fatalf("Prune returned error: %s", err)
+	}
+
+	for _, p := range []string{"1.3.0", "1.0.0", "1.0.0/1.3.0", "beta/1.4.0-beta.1", "tmp/staging"} {
+		if _, err := os.Stat(filepath.Join(dir, p)); err != nil {
+			t.Errorf("Expected %s to be kept: %s", p, err)
+		}
+	}
+	for _, p := range []string{"1.1.0", "1.2.0", "1.0.0/1.1.0", "1.0.0/1.2.0"} {
+		if _, err := os.Stat(filepath.Join(dir, p)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", p, err)
+		}
+	}
+
+	if err := Prune(PruneOptions{OutputDir: dir}); err == nil {
+		t.Error("Expected an error without Keep or KeepFor")
+	}
+}
+
+func TestPruneDropsPatches(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, SigningKey: key, Checksums: true}, "1.0.0", "1.1.0", "1.2.0")
+
+	// the manifests are signed, so rewriting them takes the key
+	if err := Prune(PruneOptions{OutputDir: dir, Keep: 2}); err == nil {
+		t.Fatal("Expected an error pruning signed manifests without the key")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.0.0")); err != nil {
+		t.Fatalf("Expected nothing to be removed after the error: %s", err)
+	}
+
+	if err := Prune(PruneOptions{OutputDir: dir, Keep: 2, Signer: key}); err != nil {
+		t.Fatalf("Prune returned error: %s", err)
+	}
+	if err := Verify(VerifyOptions{OutputDir: dir}); err != nil {
+		t.Errorf("Verify returned error for a pruned tree: %s", err)
+	}
+	for _, platformDir := range []string{dir, filepath.Join(dir, "1.2.0")} {
+		c := readManifest(t, platformDir, "linux-amd64")
+		if _, ok := c.PatchLengths["1.0.0"]; ok || c.PatchLengths["1.1.0"] == 0 || c.PatchSums["1.0.0"] != nil {
+			t.Errorf("Expected only the patch from 1.1.0 in %s, got %v", platformDir, c.PatchLengths)
+		}
+		b, _ := os.ReadFile(filepath.Join(platformDir, "linux-amd64.json"))
+		sig, _ := os.ReadFile(filepath.Join(platformDir, "linux-amd64.json.sig"))
+		if !ed25519.Verify(key.Public().(ed25519.PublicKey), b, sig) {
+			t.Errorf("Expected the rewritten manifest in %s to be signed again", platformDir)
+		}
+	}
+	sums, err := os.ReadFile(filepath.Join(dir, "SHA256SUMS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := os.ReadFile(filepath.Join(dir, "linux-amd64.json"))
+	if strings.Contains(string(sums), "1.0.0/") || !strings.Contains(string(sums), fmt.Sprintf("%x  linux-amd64.json\n", sha256.Sum256(manifest))) {
+		t.Errorf("Expected SHA256SUMS to match the pruned tree, got:\n%s", sums)
+	}
+}
+
+func TestPruneStorage(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0")
+	m := &memStorage{root: dir, files: map[string][]byte{}, dirs: map[string]bool{}}
+
+	if err := Prune(PruneOptions{OutputDir: dir, Keep: 1, Storage: m}); err != nil {
+		t.Fatalf("Prune returned error: %s", err)
+	}
+	// everything goes through the storage, the manifests and patch index
+	// rewritten without the patch from 1.0.0 first, and then the removals,
+	// children before their directory
+	for _, name := range []string{"linux-amd64.json", "1.1.0/linux-amd64.json", "1.1.0/linux-amd64.patches.json"} {
+		if len(m.files[name]) == 0 {
+			t.Errorf("Expected %s to be rewritten in the storage, got %v", name, m.files)
+		}
+	}
+	want := []string{"1.0.0/linux-amd64.gz", "1.0.0/linux-amd64.patches.json", "1.0.0/linux-amd64.json", "1.0.0/1.1.0/linux-amd64", "1.0.0/1.1.0", "1.0.0"}
+	sort.Strings(want)
+	got := append([]string(nil), m.removed...)
+	sort.Strings(got)
+	if strings.Join(got, " ") != strings.Join(want, " ") || m.removed[len(m.removed)-1] != "1.0.0" {
+		t.Errorf("Expected the removals of 1.0.0 in the storage, got %v", m.removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.0.0")); err != nil {
+		t.Errorf("Expected the output directory to be left to the storage: %s", err)
+	}
+}
+
+func TestPruneKeepsMinFromVersion(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0")
+	publish(t, Options{OutputDir: dir, MinFromVersion: "1.1.0"}, "1.2.0")
+
+	// clients before 1.1.0 are told to install it first
+	if err := Prune(PruneOptions{OutputDir: dir, Keep: 1}); err != nil {
+		t.Fatalf("Prune returned error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.1.0", "linux-amd64.json")); err != nil {
+		t.Errorf("Expected 1.1.0 to be kept for -min-from-version: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.0.0")); !os.IsNotExist(err) {
+		t.Errorf("Expected 1.0.0 to be removed, got %v", err)
+	}
+
+	// and still once the manifest moved on, as long as 1.2.0 is kept for
+	// UpdateTo
+	publish(t, Options{OutputDir: dir}, "1.3.0")
+	if err := Prune(PruneOptions{OutputDir: dir, Keep: 2}); err != nil {
+		t.Fatalf("Prune returned error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.1.0", "linux-amd64.json")); err != nil {
+		t.Errorf("Expected 1.1.0 to be kept for the manifest of 1.2.0: %s", err)
+	}
+}
+
+func TestPruneAllowAnyVersion(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "build-1", "build-2", "build-3")
+	// ordered by modification time
+	for i, v := range []string{"build-1", "build-2", "build-3"} {
+		mtime := time.Now().Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, v), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without AllowAnyVersion none of them is named like a version
+	if err := Prune(PruneOptions{OutputDir: dir, Keep: 1}); err != nil {
+		t.Fatalf("Prune returned error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "build-1")); err != nil {
+		t.Errorf("Expected build-1 to be left alone: %s", err)
+	}
+
+	if err := Prune(PruneOptions{OutputDir: dir, Keep: 1, AllowAnyVersion: true}); err != nil {
+		t.Fatalf("Prune returned error: %s", err)
+	}
+	for _, v := range []string{"build-1", "build-2"} {
+		if _, err := os.Stat(filepath.Join(dir, v)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", v, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "build-3", "linux-amd64.json")); err != nil {
+		t.Errorf("Expected build-3 to be kept: %s", err)
+	}
+}
@@ -0,0 +1,99 @@
+package generate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"maps"
+	"os"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+// rollout returns the Rollout to write to manifests, nil if the version is
+// offered to every installation. Without Rollout, publishing the version of
+// the published manifest prev again keeps its rollout.
+func (g *generator) rollout(prev *current) *int {
+	switch {
+	case g.Rollout == nil && prev != nil && prev.Version == g.Version:
+		return prev.Rollout
+	case g.Rollout == nil || *g.Rollout >= 100:
+		return nil
+	}
+	return g.Rollout
+}
+
+// republishRollout rewrites only the manifests of platform if the binary
+// at path is the one of the published version and just Rollout changed,
+// so widening a staged rollout doesn't regenerate the binary and patches.
+// Nothing but the rollout changes in the manifests. It reports whether it
+// did.
+func (g *generator) republishRollout(path, platform string) (bool, error) {
+	if g.Force || path == "-" {
+		return false, nil
+	}
+	prev := g.publishedManifest(platform)
+	if prev == nil || prev.Version != g.Version || sameRollout(prev.Rollout, g.rollout(prev)) {
+		return false, nil
+	}
+	same, err := g.sameInput(path, prev)
+	if err != nil || !same {
+		return false, err
+	}
+
+	// everything else about the release, like its Metadata and
+	// MinFromVersion, stays as published
+	if (g.Metadata != nil && !maps.Equal(g.Metadata, prev.Metadata)) || (g.MinFromVersion != "" && g.MinFromVersion != prev.MinFromVersion) {
+		g.log.Warnf("%s of %s is unchanged, so only its rollout is updated: -meta and -min-from-version are kept as published, use -force to change them", platform, g.Version)
+	}
+	c := *prev
+	c.SchemaVersion = selfupdate.SchemaVersion
+	c.Rollout = g.rollout(prev)
+	c.GeneratedAt = g.GeneratedAt
+	c.GeneratorVersion = g.GeneratorVersion
+	if c.Rollout != nil {
+		g.log.Printf("%s of %s is unchanged, only its rollout is set to %d%%", platform, g.Version, *c.Rollout)
+	} else {
+		g.log.Printf("%s of %s is unchanged, only its rollout is set to everyone", platform, g.Version)
+	}
+	if err := g.writeManifest(platform, c); err != nil {
+		return false, err
+	}
+	g.summary.platform(PlatformSummary{Platform: platform, Length: c.Length, CompressedLength: c.CompressedLength, Patches: []PatchSummary{}})
+	return true, nil
+}
+
+// sameInput reports whether the binary at path, decompressed with
+// InputCompressed, is the one the manifest c describes.
+func (g *generator) sameInput(path string, c *current) (bool, error) {
+	h, err := newHash(c.Hash.Algo)
+	if err != nil {
+		return false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if g.InputCompressed {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			// reported as such when the binary is published
+			return false, nil
+		}
+		r = zr
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(h.Sum(nil), c.Hash.Value), nil
+}
+
+// sameRollout reports whether the rollouts a and b are the same.
+func sameRollout(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,94 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerateUpdateRollout(t *testing.T) {
+	dir := t.TempDir()
+	rollout := func(n int) *int { return &n }
+	publish(t, Options{OutputDir: dir}, "1.0")
+	publish(t, Options{OutputDir: dir, Rollout: rollout(10)}, "1.1")
+	if c := readManifest(t, dir, "linux-amd64"); c.Rollout == nil || *c.Rollout != 10 {
+		t.Fatalf("Expected a rollout of 10, got %+v", c.Rollout)
+	}
+
+	// widening the rollout leaves the binary and patch alone
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	artifacts := []string{filepath.Join(dir, "1.1", "linux-amd64.gz"), filepath.Join(dir, "1.0", "1.1", "linux-amd64")}
+	for _, path := range artifacts {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(t, Options{OutputDir: dir, Rollout: rollout(50)}, "1.1")
+	for _, platformDir := range []string{dir, filepath.Join(dir, "1.1")} {
+		if c := readManifest(t, platformDir, "linux-amd64"); c.Rollout == nil || *c.Rollout != 50 || c.PatchLengths["1.0"] == 0 {
+			t.Errorf("Expected a rollout of 50 with the patch from 1.0 in %s, got %+v", platformDir, c)
+		}
+	}
+	for _, path := range artifacts {
+		if fi, err := os.Stat(path); err != nil || !fi.ModTime().Equal(old) {
+			t.Errorf("Expected %s to be left alone, got %v", path, err)
+		}
+	}
+
+	publish(t, Options{OutputDir: dir, Rollout: rollout(100)}, "1.1")
+	if c := readManifest(t, dir, "linux-amd64"); c.Rollout != nil {
+		t.Errorf("Expected no rollout for everyone, got %d", *c.Rollout)
+	}
+
+	// a different binary still isn't republished without -force
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "1.1", OutputDir: dir, Platform: "linux-amd64", AllowAnyVersion: true, Rollout: rollout(20)}
+	if err := os.WriteFile(opts.InputPath, []byte("other binary 1.1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateUpdate(opts); err == nil {
+		t.Error("Expected an error republishing a different binary")
+	}
+
+	opts.Rollout = rollout(101)
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for a rollout over 100")
+	}
+}
+
+func TestGenerateUpdateRolloutKeepsManifest(t *testing.T) {
+	dir := t.TempDir()
+	rollout := func(n int) *int { return &n }
+	publish(t, Options{OutputDir: dir}, "1.0", "1.1")
+	publish(t, Options{OutputDir: dir, Rollout: rollout(10), Metadata: map[string]string{"notes": "https://example.com/2.0"}, MinFromVersion: "1.1"}, "2.0")
+
+	// only -rollout is repeated to widen it
+	publish(t, Options{OutputDir: dir, Rollout: rollout(50)}, "2.0")
+	for _, platformDir := range []string{dir, filepath.Join(dir, "2.0")} {
+		c := readManifest(t, platformDir, "linux-amd64")
+		if c.Rollout == nil || *c.Rollout != 50 || c.Metadata["notes"] != "https://example.com/2.0" || c.MinFromVersion != "1.1" {
+			t.Errorf("Expected only the rollout to change in %s, got %+v", platformDir, c)
+		}
+	}
+}
+
+func TestGenerateUpdateRolloutKeptWithoutRollout(t *testing.T) {
+	dir := t.TempDir()
+	rollout := func(n int) *int { return &n }
+	publish(t, Options{OutputDir: dir}, "1.0")
+	publish(t, Options{OutputDir: dir, Rollout: rollout(10)}, "1.1")
+
+	// publishing 1.1 again without Rollout doesn't offer it to everyone
+	publish(t, Options{OutputDir: dir, Force: true}, "1.1")
+	for _, platformDir := range []string{dir, filepath.Join(dir, "1.1")} {
+		if c := readManifest(t, platformDir, "linux-amd64"); c.Rollout == nil || *c.Rollout != 10 {
+			t.Errorf("Expected the rollout of 10 to be kept in %s, got %+v", platformDir, c.Rollout)
+		}
+	}
+
+	// a new version is
+	publish(t, Options{OutputDir: dir}, "1.2")
+	if c := readManifest(t, dir, "linux-amd64"); c.Rollout != nil {
+		t.Errorf("Expected no rollout for 1.2, got %d", *c.Rollout)
+	}
+}
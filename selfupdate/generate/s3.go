@@ -0,0 +1,208 @@
+package generate
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3Uploader uploads to an S3 bucket, or a service with a compatible API,
+// signing the requests with AWS Signature Version 4.
+type S3Uploader struct {
+	Bucket string
+	Prefix string // Key prefix, without leading or trailing slash
+	Region string
+	// Endpoint, if set, is the URL of an S3 compatible service. The bucket
+	// is then addressed by path, Endpoint/Bucket/Key, instead of as a
+	// virtual host of s3.Region.amazonaws.com.
+	Endpoint string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Only set for temporary credentials
+
+	// Client is used for the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// NewS3Uploader returns an uploader to target, "s3://bucket/prefix" or
+// "bucket/prefix", with the credentials, region and endpoint taken from the
+// standard AWS environment variables: AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION or
+// AWS_DEFAULT_REGION (us-east-1 if neither is set) and AWS_ENDPOINT_URL_S3
+// or AWS_ENDPOINT_URL. Shared config files and instance roles aren't
+// supported.
+func NewS3Uploader(target string) (*S3Uploader, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(target, "s3://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid S3 target %q: want s3://bucket/prefix", target)
+	}
+	u := &S3Uploader{
+		Bucket:          bucket,
+		Prefix:          strings.Trim(prefix, "/"),
+		Region:          firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Endpoint:        firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if u.Region == "" {
+		u.Region = "us-east-1"
+	}
+	if u.AccessKeyID == "" || u.SecretAccessKey == "" {
+		return nil, errors.New("no S3 credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return u, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Upload puts the file at localPath to Prefix/name. The file is streamed,
+// after a first pass over it for the payload hash the request is signed
+// with.
+func (u *S3Uploader) Upload(name, localPath, contentType string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	key := path.Join(u.Prefix, name)
+
+	var target string
+	if u.Endpoint != "" {
+		target = strings.TrimSuffix(u.Endpoint, "/") + "/" + u.Bucket + "/" + key
+	} else {
+		target = "https://" + u.Bucket + ".s3." + u.Region + ".amazonaws.com/" + key
+	}
+	reqURL, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	// the path is signed exactly as sent
+	reqURL.RawPath = s3EscapePath(reqURL.Path)
+
+	// the client closes the body, f is closed once the upload is done
+	req, err := http.NewRequest(http.MethodPut, reqURL.String(), io.NopCloser(f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		// for the transport to retry on a new connection
+		return os.Open(localPath)
+	}
+	if size == 0 {
+		// or it's sent chunked, as of unknown length
+		req.Body, req.GetBody = http.NoBody, nil
+	}
+	req.Header.Set("Content-Type", contentType)
+	u.sign(req, hex.EncodeToString(h.Sum(nil)), time.Now())
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("uploading s3://%s/%s: %s: %s", u.Bucket, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req, whose body hashes
+// to payloadHash, hex encoded.
+func (u *S3Uploader) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if u.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", u.SessionToken)
+	}
+
+	signed := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if u.SessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	var headers strings.Builder
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", h, strings.TrimSpace(v))
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + u.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sha256Sum([]byte(canonical)))
+
+	key := hmacSHA256([]byte("AWS4"+u.SecretAccessKey), date)
+	key = hmacSHA256(key, u.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		u.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath escapes every byte of p but the unreserved characters and
+// the slashes, as Signature Version 4 expects the canonical path.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package generate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestS3Upload(t *testing.T) {
+	var mu sync.Mutex
+	var puts []string
+	types := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		// streamed with its length, not chunked
+		if r.Method != http.MethodPut || r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) ||
+			r.ContentLength != int64(len(body)) || len(r.TransferEncoding) != 0 ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=") {
+			t.Errorf("Unexpected request %s %s %v", r.Method, r.URL, r.Header)
+		}
+		mu.Lock()
+		puts = append(puts, r.URL.EscapedPath())
+		types[r.URL.EscapedPath()] = r.Header.Get("Content-Type")
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	up, err := NewS3Uploader("s3://bucket/apps/myapp/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, Options{OutputDir: t.TempDir(), Channel: "beta", Upload: up}, "1.1.0+build.1")
+
+	want := []string{
+		"/bucket/apps/myapp/beta/1.1.0%2Bbuild.1/linux-amd64.gz",
+		"/bucket/apps/myapp/beta/1.1.0%2Bbuild.1/linux-amd64.json",
+		"/bucket/apps/myapp/beta/1.1.0%2Bbuild.1/linux-amd64.patches.json",
+		"/bucket/apps/myapp/beta/index.json",
+		"/bucket/apps/myapp/beta/latest.json",
+		"/bucket/apps/myapp/beta/linux-amd64.json",
+	}
+	if strings.Join(puts, " ") != strings.Join(want, " ") {
+		t.Errorf("Uploaded %v; want %v", puts, want)
+	}
+	if types[want[0]] != "application/gzip" || types[want[5]] != "application/json" {
+		t.Errorf("Unexpected content types %v", types)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := up.Upload("empty", empty, "application/octet-stream"); err != nil {
+		t.Errorf("Upload of an empty file returned error: %s", err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	if _, err := NewS3Uploader("bucket"); err == nil {
+		t.Error("Expected an error without credentials")
+	}
+}
@@ -0,0 +1,50 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+// validateVersion checks the version argument before anything is written.
+// Unless allowAny is set it must be valid semver. Even then it has to be
+// usable as a directory name.
+func validateVersion(v string, allowAny bool) error {
+	switch {
+	case strings.TrimSpace(v) == "":
+		return fmt.Errorf("invalid version %q: must not be empty", v)
+	case !isDirName(v):
+		return fmt.Errorf("invalid version %q: must be usable as a directory name", v)
+	}
+	if allowAny {
+		return nil
+	}
+	if !selfupdate.IsSemver(v) {
+		return fmt.Errorf("invalid version %q: want semver like 1.2.3 or v1.2.3-rc.1, or use -allow-any-version", v)
+	}
+	return nil
+}
+
+// validateChannel checks the channel option. An empty channel publishes
+// to the output directory itself.
+func validateChannel(c string) error {
+	if c != "" && !isDirName(c) {
+		return fmt.Errorf("invalid channel %q: must be usable as a directory name", c)
+	}
+	return nil
+}
+
+// isDirName reports whether s can be used as a single path element.
+func isDirName(s string) bool {
+	return strings.TrimSpace(s) != "" && s != "." && s != ".." && !strings.ContainsAny(s, `/\`)
+}
+
+// versionLess orders version a before b, as semantic versions if both are
+// one and by name otherwise.
+func versionLess(a, b string) bool {
+	if c, ok := selfupdate.CompareVersions(a, b); ok && c != 0 {
+		return c < 0
+	}
+	return a < b
+}
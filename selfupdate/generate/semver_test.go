@@ -0,0 +1,28 @@
+package generate
+
+import "testing"
+
+func TestValidateVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		allowAny bool
+		ok       bool
+	}{
+		{"1.2.3", false, true},
+		{"v2.0.0-rc.1", false, true},
+		{"v1..2", false, false},
+		{"1.0", false, false},
+		{"1.0", true, true},
+		{"nightly-20240101", true, true},
+		{"", true, false},
+		{" ", false, false},
+		{"..", true, false},
+		{"1.0/evil", true, false},
+	}
+	for _, tt := range tests {
+		err := validateVersion(tt.version, tt.allowAny)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateVersion(%q, %v) = %v, want ok %v", tt.version, tt.allowAny, err, tt.ok)
+		}
+	}
+}
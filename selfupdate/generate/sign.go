@@ -0,0 +1,144 @@
+package generate
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadPrivateKey reads a PEM encoded PKCS #8 ed25519 private key, as written
+// by GenerateKeys or `openssl genpkey -algorithm ed25519`.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// LoadPrivateKeyEnv reads the private key from the environment variable
+// name, for CI systems keeping it as a secret rather than in a file, see
+// ParsePrivateKey.
+func LoadPrivateKeyEnv(name string) (ed25519.PrivateKey, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	key, err := ParsePrivateKey([]byte(v))
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// ParsePrivateKey parses a PKCS #8 ed25519 private key, PEM encoded like
+// LoadPrivateKey reads it, or base64 encoded, either the PEM or the DER
+// inside, which is easier to keep in a single line variable.
+func ParsePrivateKey(b []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, errors.New("no PEM or base64 encoded private key found")
+		}
+		if block, _ = pem.Decode(der); block == nil {
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+	}
+	if block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an ed25519 private key", key)
+	}
+	return priv, nil
+}
+
+// GenerateKeys writes a new ed25519 private key to path and its public key
+// to path.pub, both PEM encoded, and returns the key ID recorded in the
+// manifests it signs.
+func GenerateKeys(path string) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		return "", err
+	}
+	return keyID(pub), nil
+}
+
+// keyID is the fingerprint of pub recorded in signed manifests: the hex
+// encoded first 8 bytes of its sha256.
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// digestMessage is the message signed for the binary, binding the checksum
+// to the algorithm that produced it.
+func digestMessage(d digest) []byte {
+	return append([]byte(d.Algo+":"), d.Value...)
+}
+
+// signManifest sets the binary signature and key ID of c.
+func signManifest(c *current, signer crypto.Signer) error {
+	pub, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return errors.New("invalid ed25519 key")
+	}
+	sig, err := sign(signer, digestMessage(c.Hash))
+	if err != nil {
+		return err
+	}
+	c.Signature = sig
+	c.KeyID = keyID(pub)
+	return nil
+}
+
+// sign returns the ed25519 signature of message made by signer, checked
+// against its public key, so a remote signer using another key than it
+// claims fails the run rather than every client.
+func sign(signer crypto.Signer, message []byte) ([]byte, error) {
+	sig, err := signer.Sign(rand.Reader, message, crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("can't sign: %w", err)
+	}
+	pub, ok := signer.Public().(ed25519.PublicKey)
+	if !ok || !ed25519.Verify(pub, message, sig) {
+		return nil, errors.New("signer made a signature that doesn't verify with its ed25519 public key")
+	}
+	return sig, nil
+}
@@ -0,0 +1,224 @@
+package generate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/dongshuzhao/go-selfupdate/selfupdate"
+)
+
+func TestSignedManifest(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "release.key")
+	id, err := GenerateKeys(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateKeys(keyPath); err == nil {
+		t.Error("Expected an error when the key already exists")
+	}
+	key, err := LoadPrivateKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.Public().(ed25519.PublicKey)
+
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, SigningKey: key}, "1.0")
+
+	b, err := os.ReadFile(filepath.Join(dir, "linux-amd64.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := os.ReadFile(filepath.Join(dir, "linux-amd64.json.sig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(pub, b, sig) {
+		t.Error("Manifest signature doesn't verify")
+	}
+
+	var c current
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(pub, digestMessage(c.Hash), c.Signature) {
+		t.Error("Binary signature doesn't verify")
+	}
+	if c.KeyID != keyID(pub) || c.KeyID != id {
+		t.Errorf("KeyID = %s; want %s", c.KeyID, keyID(pub))
+	}
+}
+
+func TestSignedManifestClient(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "release.key")
+	if _, err := GenerateKeys(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPrivateKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemPub, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := selfupdate.ParsePublicKey(pemPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "myapp")
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	check := func(pub ed25519.PublicKey) error {
+		u := &selfupdate.Updater{
+			CurrentVersion: "1.0.0",
+			ApiURL:         "https://updates.example.com/",
+			CmdName:        "myapp",
+			Requester:      dirRequester(root),
+			PublicKey:      pub,
+		}
+		_, err := u.UpdateAvailable()
+		return err
+	}
+
+	publish(t, Options{OutputDir: dir, Platform: platform, SigningKey: key}, "1.1.0")
+	if err := check(pub); err != nil {
+		t.Errorf("Expected the signed release to verify, got %v", err)
+	}
+	var sigErr *selfupdate.SignatureError
+	if err := check(otherKey.Public().(ed25519.PublicKey)); !errors.As(err, &sigErr) {
+		t.Errorf("Expected a *SignatureError for another key, got %v", err)
+	}
+
+	// a manifest edited after signing
+	manifest := filepath.Join(dir, platform+".json")
+	b, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manifest, bytes.Replace(b, []byte("1.1.0"), []byte("1.1.1"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(pub); !errors.As(err, &sigErr) {
+		t.Errorf("Expected a *SignatureError for a tampered manifest, got %v", err)
+	}
+
+	publish(t, Options{OutputDir: dir, Platform: platform, Force: true}, "1.2.0")
+	if err := os.Remove(manifest + ".sig"); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(pub); !errors.As(err, &sigErr) {
+		t.Errorf("Expected a *SignatureError for an unsigned release, got %v", err)
+	}
+	if err := check(nil); err != nil {
+		t.Errorf("Expected an unsigned release to pass without a key, got %v", err)
+	}
+}
+
+func TestLoadPrivateKeyEnv(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "release.key")
+	if _, err := GenerateKeys(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	want, err := LoadPrivateKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	for name, value := range map[string]string{
+		"PEM":        string(b),
+		"base64 PEM": base64.StdEncoding.EncodeToString(b),
+		"base64 DER": base64.StdEncoding.EncodeToString(block.Bytes) + "\n",
+	} {
+		t.Setenv("RELEASE_KEY", value)
+		key, err := LoadPrivateKeyEnv("RELEASE_KEY")
+		if err != nil || !key.Equal(want) {
+			t.Errorf("%s: LoadPrivateKeyEnv returned %v", name, err)
+		}
+	}
+
+	t.Setenv("RELEASE_KEY", "")
+	if _, err := LoadPrivateKeyEnv("RELEASE_KEY"); err == nil {
+		t.Error("Expected an error for an empty variable")
+	}
+	t.Setenv("RELEASE_KEY", "not a key")
+	if _, err := LoadPrivateKeyEnv("RELEASE_KEY"); err == nil {
+		t.Error("Expected an error for a variable without a key")
+	}
+}
+
+// remoteSigner signs with key, standing in for a KMS, and reports pub as
+// its public key.
+type remoteSigner struct {
+	key   ed25519.PrivateKey
+	pub   crypto.PublicKey
+	calls int
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *remoteSigner) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls++
+	return s.key.Sign(rand, message, opts)
+}
+
+func TestSigner(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &remoteSigner{key: key, pub: pub}
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, Signer: signer}, "1.0")
+	if signer.calls == 0 {
+		t.Fatal("Expected the signer to be used")
+	}
+	c := readManifest(t, dir, "linux-amd64")
+	if !ed25519.Verify(pub, digestMessage(c.Hash), c.Signature) || c.KeyID != keyID(pub) {
+		t.Error("Binary signature doesn't verify")
+	}
+
+	// a signer using another key than it claims fails the run
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), OutputDir: t.TempDir(), Version: "1.0", Platform: "linux-amd64", Signer: &remoteSigner{key: key, pub: otherPub}}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 1.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateUpdate(opts); err == nil {
+		t.Error("Expected an error for a signer with the wrong public key")
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Signer = ecKey
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for a signer with an ecdsa key")
+	}
+	opts.Signer, opts.SigningKey = signer, key
+	if err := opts.Validate(); err == nil {
+		t.Error("Expected an error for both a signing key and a signer")
+	}
+}
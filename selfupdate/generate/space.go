@@ -0,0 +1,113 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errSpaceUnknown is returned by freeSpace where it isn't supported.
+var errSpaceUnknown = errors.New("free space unknown on this platform")
+
+// spaceMargin covers the manifests, patch indexes and the overhead of the
+// compression formats on top of the estimated artifacts.
+const spaceMargin = 1 << 20
+
+// checkSpace fails if the filesystem of OutputDir has less free space than
+// the run is estimated to need, before anything is written.
+func (g *generator) checkSpace() error {
+	need, err := g.estimateSpace()
+	if err != nil {
+		// the run itself reports unreadable input
+		return nil
+	}
+	dir := g.OutputDir
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		g.log.Verbosef("Skipping the free space check: %s", err)
+		return nil
+	}
+	g.log.Verbosef("Estimated space needed: %d bytes, %d available", need, free)
+	if need > free {
+		return fmt.Errorf("not enough free space in %s: need about %d bytes, %d available (use -skip-space-check to publish anyway)", dir, need, free)
+	}
+	return nil
+}
+
+// estimateSpace returns a conservative estimate of the bytes the run
+// writes: per platform, the full binary at its uncompressed size, again
+// if KeepUncompressed, and a patch of the same size from every prior
+// version a patch is generated from.
+func (g *generator) estimateSpace() (int64, error) {
+	sizes := map[string]int64{} // binary size by platform
+	if g.InputPath == "-" {
+		// the size of stdin is unknown up front
+		return 0, nil
+	}
+	fi, err := os.Stat(g.InputPath)
+	switch {
+	case os.IsNotExist(err) && isGlob(g.InputPath):
+		inputs, _ := g.globInputs(g.InputPath, false)
+		for _, in := range inputs {
+			if fi, err := os.Stat(in.path); err == nil {
+				sizes[in.platform] = fi.Size()
+			}
+		}
+	case err != nil:
+		return 0, err
+	case fi.IsDir() && g.Matrix:
+		inputs, _ := g.matrixInputs(g.InputPath, false)
+		for _, in := range inputs {
+			if fi, err := os.Stat(in.path); err == nil {
+				sizes[in.platform] = fi.Size()
+			}
+		}
+	case fi.IsDir():
+		files, err := os.ReadDir(g.InputPath)
+		if err != nil {
+			return 0, err
+		}
+		for _, file := range files {
+			path := filepath.Join(g.InputPath, file.Name())
+			if !g.matchesFilters(file.Name()) || g.notABinary(path, file) != "" {
+				continue
+			}
+			if fi, err := os.Stat(path); err == nil {
+				sizes[g.platformName(file.Name())] = fi.Size()
+			}
+		}
+	default:
+		sizes[g.platformName(g.Platform)] = fi.Size()
+	}
+
+	var versions []os.DirEntry
+	if !g.NoPatch {
+		// a missing OutputDir just has no prior versions
+		versions, _ = os.ReadDir(g.OutputDir)
+	}
+	need := int64(spaceMargin)
+	for platform, size := range sizes {
+		patches := 0
+		for _, v := range versions {
+			if v.IsDir() && v.Name() != g.Version && !g.excludedOld(v.Name()) && hasFullBin(filepath.Join(g.OutputDir, v.Name()), platform) {
+				patches++
+			}
+		}
+		if g.DiffDepth > 0 && patches > g.DiffDepth {
+			patches = g.DiffDepth
+		}
+		copies := 1 + patches
+		if g.KeepUncompressed {
+			copies++
+		}
+		need += size * int64(copies)
+	}
+	return need, nil
+}
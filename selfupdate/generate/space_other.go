@@ -0,0 +1,7 @@
+//go:build !unix && !windows
+
+package generate
+
+func freeSpace(dir string) (int64, error) {
+	return 0, errSpaceUnknown
+}
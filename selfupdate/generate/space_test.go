@@ -0,0 +1,44 @@
+package generate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimateSpace(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0")
+	publish(t, Options{OutputDir: dir, Platform: "darwin-arm64"}, "1.1.0")
+
+	bin := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(bin, bytes.Repeat([]byte("x"), 1000), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		opts    Options
+		patches int64
+	}{
+		{Options{Platform: "linux-amd64"}, 2},
+		{Options{Platform: "linux-amd64", DiffDepth: 1}, 1},
+		{Options{Platform: "linux-amd64", NoPatch: true}, 0},
+		{Options{Platform: "windows-amd64"}, 0},
+	} {
+		tt.opts.InputPath, tt.opts.Version, tt.opts.OutputDir = bin, "1.2.0", dir
+		g, err := newGenerator(tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		need, err := g.estimateSpace()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := spaceMargin + 1000*(1+tt.patches); need != want {
+			t.Errorf("%+v: estimated %d bytes, want %d", tt.opts, need, want)
+		}
+		if err := g.checkSpace(); err != nil {
+			t.Errorf("checkSpace returned error: %s", err)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package generate
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// freeSpace returns the bytes available to the current user on the volume
+// holding dir.
+func freeSpace(dir string) (int64, error) {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	getDiskFreeSpaceEx := kernel32.NewProc("GetDiskFreeSpaceExW")
+
+	var available uint64
+	r1, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(dir))), uintptr(unsafe.Pointer(&available)), 0, 0)
+	if r1 == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
@@ -0,0 +1,87 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Storage receives the files and directories a run writes, so the output
+// can go somewhere other than the local filesystem. Paths are below
+// OutputDir, as the generator builds them. Older releases, to generate
+// patches from, are still read from OutputDir.
+type Storage interface {
+	// Write stores data at path, replacing any file there. Clients must
+	// never see a partial file.
+	Write(path string, data []byte) error
+	// Mkdir creates the directory path and any missing parents. It is
+	// called concurrently for the same or overlapping paths, and a
+	// directory that already exists is not an error.
+	Mkdir(path string) error
+	// Remove removes the file or empty directory at path. A directory
+	// that isn't empty is an error, and it is left as it is.
+	Remove(path string) error
+}
+
+// LocalStorage writes to the local filesystem. It is the default Storage.
+type LocalStorage struct {
+	FileMode os.FileMode // Permission of the files written, 0644 if unset
+}
+
+// Write writes data to a temporary file next to path and renames it into
+// place, so clients polling the directory see either the previous or the
+// complete new file.
+func (s LocalStorage) Write(path string, data []byte) error {
+	f, err := s.create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return commit(f, path)
+}
+
+// Mkdir creates path with mode 0755.
+func (s LocalStorage) Mkdir(path string) error {
+	return os.MkdirAll(path, 0755)
+}
+
+// Remove removes path with os.Remove.
+func (s LocalStorage) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// create opens a temporary file for path, to be moved into place by
+// commit.
+func (s LocalStorage) create(path string) (*os.File, error) {
+	mode := s.FileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// commit closes the temporary file f and renames it to path, removing it
+// if that fails.
+func commit(f *os.File, path string) error {
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package generate
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Summary describes what a GenerateUpdate run did, for CI dashboards and
+// other tools. It is filled in even when the run fails part way, so it
+// shows exactly what got published.
+type Summary struct {
+	Version      string
+	OutputDir    string
+	DryRun       bool `json:",omitempty"` // Nothing was written, the counts are what would have been
+	Platforms    []PlatformSummary
+	Skipped      []SkippedItem
+	Errors       []string
+	FilesWritten int
+	BytesWritten int64
+	Write        *Timing `json:",omitempty"` // Time spent storing the files, with Options.Timings
+}
+
+// PlatformSummary describes a platform whose manifest was written.
+type PlatformSummary struct {
+	Platform         string
+	Length           int64   // Size of the binary
+	CompressedLength int64   // Size of the full binary as stored
+	Compress         *Timing `json:",omitempty"` // With Options.Timings
+	Patches          []PatchSummary
+}
+
+// PatchSummary describes a patch generated from an older version.
+type PatchSummary struct {
+	From   string
+	Length int64   // Size of the patch as stored
+	Diff   *Timing `json:",omitempty"` // Time spent making the patch, with Options.Timings
+}
+
+// SkippedItem is an input file, old version or patch that was left out,
+// along with the reason.
+type SkippedItem struct {
+	Platform string `json:",omitempty"`
+	Item     string
+	Reason   string
+}
+
+// summaryRecorder collects the Summary of a run from concurrent workers.
+type summaryRecorder struct {
+	mu        sync.Mutex
+	s         Summary
+	timings   bool          // Record Write, see Options.Timings
+	writeTime time.Duration // Spent writing so far
+}
+
+func (r *summaryRecorder) platform(p PlatformSummary) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.Platforms = append(r.s.Platforms, p)
+}
+
+func (r *summaryRecorder) skipped(platform, item, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.Skipped = append(r.s.Skipped, SkippedItem{Platform: platform, Item: item, Reason: reason})
+}
+
+func (r *summaryRecorder) wrote(n int64, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.s.FilesWritten++
+	r.s.BytesWritten += n
+	r.writeTime += d
+}
+
+// writeTiming returns the time spent writing so far.
+func (r *summaryRecorder) writeTiming() *Timing {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return newTiming(r.writeTime, r.s.BytesWritten)
+}
+
+// summary returns the collected Summary in a stable order, patches and
+// skipped versions sorted by version, with err, the error of the run,
+// split into its messages.
+func (r *summaryRecorder) summary(err error) Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.s
+	sort.Slice(s.Platforms, func(i, j int) bool { return s.Platforms[i].Platform < s.Platforms[j].Platform })
+	for _, p := range s.Platforms {
+		sort.Slice(p.Patches, func(i, j int) bool { return versionLess(p.Patches[i].From, p.Patches[j].From) })
+	}
+	sort.SliceStable(s.Skipped, func(i, j int) bool {
+		if s.Skipped[i].Platform != s.Skipped[j].Platform {
+			return s.Skipped[i].Platform < s.Skipped[j].Platform
+		}
+		return versionLess(s.Skipped[i].Item, s.Skipped[j].Item)
+	})
+	// empty lists rather than null, for consumers that don't expect it
+	if s.Platforms == nil {
+		s.Platforms = []PlatformSummary{}
+	}
+	if s.Skipped == nil {
+		s.Skipped = []SkippedItem{}
+	}
+	if r.timings {
+		s.Write = newTiming(r.writeTime, s.BytesWritten)
+	}
+	s.Errors = []string{}
+	if err != nil {
+		s.Errors = strings.Split(err.Error(), "\n")
+	}
+	return s
+}
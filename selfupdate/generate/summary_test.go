@@ -0,0 +1,102 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateUpdateSummary(t *testing.T) {
+	dir := t.TempDir()
+	publishDir(t, Options{OutputDir: dir}, []string{"linux-amd64", "darwin-arm64"}, "1.0.0")
+	if err := os.WriteFile(filepath.Join(dir, "1.0.0", "darwin-arm64.gz"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := t.TempDir()
+	for name, content := range map[string]string{
+		"linux-amd64":  "linux 1.1.0",
+		"linux-x86_64": "also linux 1.1.0",
+		"darwin-arm64": "darwin 1.1.0",
+		"README.md":    "not a binary",
+	} {
+		if err := os.WriteFile(filepath.Join(in, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := GenerateUpdateSummary(Options{InputPath: in, Version: "1.1.0", OutputDir: dir, Exclude: []string{"*.md"}, SkipCorrupt: true})
+	if err == nil {
+		t.Fatal("Expected an error for the duplicate platform")
+	}
+
+	if s.Version != "1.1.0" || len(s.Errors) != 1 || s.FilesWritten == 0 || s.BytesWritten == 0 {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+	if len(s.Platforms) != 2 || s.Platforms[0].Platform != "darwin-arm64" || s.Platforms[1].Platform != "linux-amd64" {
+		t.Fatalf("Unexpected platforms %+v", s.Platforms)
+	}
+	if len(s.Platforms[0].Patches) != 0 || len(s.Platforms[1].Patches) != 1 || s.Platforms[1].Patches[0].From != "1.0.0" {
+		t.Errorf("Unexpected patches %+v", s.Platforms)
+	}
+	if len(s.Skipped) != 2 || s.Skipped[0].Item != "README.md" || s.Skipped[1].Platform != "darwin-arm64" || s.Skipped[1].Item != "1.0.0" {
+		t.Errorf("Unexpected skipped items %+v", s.Skipped)
+	}
+
+	if s, err := GenerateUpdateSummary(Options{InputPath: in, OutputDir: dir}); err == nil || len(s.Errors) != 1 {
+		t.Errorf("Expected the invalid options in the summary, got %+v", s)
+	}
+}
+
+func TestGenerateUpdateSummaryTimings(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0")
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "1.1.0", OutputDir: dir, Platform: "linux-amd64"}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 1.1.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := GenerateUpdateSummary(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Write != nil || s.Platforms[0].Compress != nil || s.Platforms[0].Patches[0].Diff != nil {
+		t.Errorf("Expected no timings by default, got %+v", s)
+	}
+
+	opts.Version, opts.Timings = "1.2.0", true
+	if s, err = GenerateUpdateSummary(opts); err != nil {
+		t.Fatal(err)
+	}
+	p := s.Platforms[0]
+	if p.Compress == nil || p.Compress.Bytes != p.Length || p.Compress.Seconds <= 0 {
+		t.Errorf("Unexpected compress timing %+v", p.Compress)
+	}
+	for _, patch := range p.Patches {
+		if patch.Diff == nil || patch.Diff.Bytes != p.Length {
+			t.Errorf("Unexpected diff timing of the patch from %s: %+v", patch.From, patch.Diff)
+		}
+	}
+	if s.Write == nil || s.Write.Bytes != s.BytesWritten {
+		t.Errorf("Unexpected write timing %+v", s.Write)
+	}
+}
+
+func TestGenerateUpdateSummaryOrder(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.9.0", "1.10.0", "1.2.0")
+	opts := Options{InputPath: filepath.Join(t.TempDir(), "myapp"), Version: "1.11.0", OutputDir: dir, Platform: "linux-amd64", Ordered: true}
+	if err := os.WriteFile(opts.InputPath, []byte("binary 1.11.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := GenerateUpdateSummary(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var from []string
+	for _, p := range s.Platforms[0].Patches {
+		from = append(from, p.From)
+	}
+	if strings.Join(from, " ") != "1.2.0 1.9.0 1.10.0" {
+		t.Errorf("Patches from %v; want them by version", from)
+	}
+}
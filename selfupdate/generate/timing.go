@@ -0,0 +1,44 @@
+package generate
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timing is how long a phase of a run took, recorded in the Summary with
+// Options.Timings.
+type Timing struct {
+	Seconds float64
+	Bytes   int64   // Bytes processed: the binary compressed, the new binary diffed or the files written
+	MBps    float64 // Throughput in MB (10^6 bytes) per second
+}
+
+func newTiming(d time.Duration, n int64) *Timing {
+	t := &Timing{Seconds: d.Seconds(), Bytes: n}
+	if d > 0 {
+		t.MBps = float64(n) / 1e6 / d.Seconds()
+	}
+	return t
+}
+
+func (t *Timing) String() string {
+	d := time.Duration(t.Seconds * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf("%d bytes in %s, %.1f MB/s", t.Bytes, d, t.MBps)
+}
+
+// now returns the current time if the run is timed, otherwise the zero
+// time, so writes don't read the clock for nothing.
+func (g *generator) now() time.Time {
+	if !g.timed {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// since returns the time elapsed since start, as returned by now.
+func (g *generator) since(start time.Time) time.Duration {
+	if !g.timed {
+		return 0
+	}
+	return time.Since(start)
+}
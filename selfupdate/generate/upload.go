@@ -0,0 +1,69 @@
+package generate
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Uploader publishes the files written by a run to a remote store, after
+// they were written to OutputDir.
+type Uploader interface {
+	// Upload stores the file at localPath as name, its slash separated
+	// path relative to the output directory, including the channel.
+	Upload(name, localPath, contentType string) error
+}
+
+// contentTypes are the MIME types of the published files, by extension.
+// Anything else is uploaded as application/octet-stream.
+var contentTypes = map[string]string{
+	".gz":   "application/gzip",
+	".zst":  "application/zstd",
+	".json": "application/json",
+	".xml":  "application/xml",
+}
+
+func contentType(name string) string {
+	if name == "SHA256SUMS" {
+		return "text/plain; charset=utf-8"
+	}
+	if t, ok := contentTypes[path.Ext(name)]; ok {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// upload passes every file written by this run to Upload, named after
+// Layout. The files in the root of OutputDir, the manifests and the index,
+// go last, so a client never sees a manifest pointing at a binary that
+// isn't uploaded yet. The first failure stops the upload for the same
+// reason.
+func (g *generator) upload() error {
+	g.written.Lock()
+	names := make([]string, 0, len(g.written.sums))
+	for name := range g.written.sums {
+		names = append(names, name)
+	}
+	g.written.Unlock()
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := !strings.Contains(names[i], "/"), !strings.Contains(names[j], "/")
+		if ri != rj {
+			return rj
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		remote := path.Join(filepath.ToSlash(g.Channel), g.publishedName(name))
+		if g.DryRun {
+			g.log.Printf("Would upload %s", remote)
+			continue
+		}
+		if err := g.Upload.Upload(remote, filepath.Join(g.OutputDir, filepath.FromSlash(name)), contentType(name)); err != nil {
+			return err
+		}
+		g.log.Verbosef("Uploaded %s", remote)
+	}
+	return nil
+}
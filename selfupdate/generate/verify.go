@@ -0,0 +1,312 @@
+package generate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	// OutputDir is the directory holding the published versions.
+	OutputDir string
+	// Channel verifies the OutputDir/Channel subdirectory instead, see
+	// Options.Channel.
+	Channel string
+	// Logger receives the problems found and the summary. Nothing is
+	// logged when nil.
+	Logger *Logger
+}
+
+// Verify checks a release tree written by Generate before clients are
+// pointed at it: every platform manifest, in the root and in the version
+// directories, parses and its full binary decompresses to a binary with
+// the recorded checksum and length, and every patch listed in a patch
+// index is intact and turns the full binary of the version it patches
+// from into the binary of its version. Channels in subdirectories are
+// verified too. Verify doesn't stop at the first problem: each is logged
+// and they are all returned in a *VerifyError.
+func Verify(opts VerifyOptions) error {
+	if err := validateChannel(opts.Channel); err != nil {
+		return err
+	}
+	v := &verifier{log: opts.Logger, checked: map[string]error{}}
+	if v.log == nil {
+		v.log = NewLogger(io.Discard, io.Discard, LevelQuiet)
+	}
+	genDir := filepath.Join(opts.OutputDir, opts.Channel)
+	if err := v.verifyDir(genDir); err != nil {
+		return err
+	}
+	v.log.Summaryf("Verified %d manifests, %d full binaries and %d patches: %d problems", v.manifests, len(v.checked), v.patches, len(v.problems))
+	if len(v.problems) > 0 {
+		return &VerifyError{Dir: genDir, Problems: v.problems}
+	}
+	return nil
+}
+
+// VerifyError is returned by Verify for the problems found in Dir.
+type VerifyError struct {
+	Dir      string
+	Problems []error
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%d problems found in %s", len(e.Problems), e.Dir)
+}
+
+func (e *VerifyError) Unwrap() []error {
+	return e.Problems
+}
+
+type verifier struct {
+	log                *Logger
+	problems           []error
+	checked            map[string]error // full binaries verified, by path
+	manifests, patches int
+}
+
+func (v *verifier) fail(err error) {
+	v.log.Errorf("%s", err)
+	v.problems = append(v.problems, err)
+}
+
+// verifyDir verifies the manifests and version directories in genDir, and
+// the channels in its subdirectories.
+func (v *verifier) verifyDir(genDir string) error {
+	entries, err := os.ReadDir(genDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isPlatformManifest(entry.Name()) {
+			v.verifyManifest(genDir, filepath.Join(genDir, entry.Name()))
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(genDir, entry.Name())
+		if isChannelDir(dir) {
+			if err := v.verifyDir(dir); err != nil {
+				return err
+			}
+			continue
+		}
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			path := filepath.Join(dir, f.Name())
+			switch {
+			case f.IsDir():
+			case strings.HasSuffix(f.Name(), ".patches.json"):
+				v.verifyPatchIndex(genDir, path)
+			case filepath.Ext(f.Name()) == ".json":
+				v.verifyManifest(genDir, path)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyManifest checks that the manifest at path parses and that the
+// full binary it describes, in genDir, matches it.
+func (v *verifier) verifyManifest(genDir, path string) {
+	v.manifests++
+	b, err := os.ReadFile(path)
+	if err != nil {
+		v.fail(err)
+		return
+	}
+	var c current
+	if err := json.Unmarshal(b, &c); err != nil {
+		v.fail(fmt.Errorf("%s: unreadable manifest: %w", path, err))
+		return
+	}
+	if !isDirName(c.Version) {
+		v.fail(fmt.Errorf("%s: invalid version %q", path, c.Version))
+		return
+	}
+	want := c.Hash
+	if want.Algo == "" {
+		// written before the manifest recorded the algorithm
+		want = digest{Algo: "sha256", Value: c.Sha256}
+	}
+	platform := strings.TrimSuffix(filepath.Base(path), ".json")
+	format := c.Compression
+	if format == "" {
+		format = "gzip"
+	}
+	binPath := filepath.Join(genDir, c.Version, platform+formatExt[format])
+	if err := v.verifyFullBin(binPath, format, want, c.Length); err != nil {
+		v.fail(fmt.Errorf("%s: %w", path, err))
+	}
+	if c.Uncompressed {
+		plainPath := filepath.Join(genDir, c.Version, platform)
+		if err := v.verifyFullBin(plainPath, "", want, c.Length); err != nil {
+			v.fail(fmt.Errorf("%s: %w", path, err))
+		}
+	}
+}
+
+// verifyFullBin checks that the full binary at path, written in format or
+// as is if format is empty, decompresses to length bytes hashing to want.
+// A negative length isn't checked. Each path is only read once.
+func (v *verifier) verifyFullBin(path, format string, want digest, length int64) error {
+	if err, ok := v.checked[path]; ok {
+		return err
+	}
+	err := matchFullBin(path, format, want, length)
+	v.checked[path] = err
+	return err
+}
+
+// matchFullBin is verifyFullBin without the cache.
+func matchFullBin(path, format string, want digest, length int64) error {
+	h, err := newHash(want.Algo)
+	if err != nil {
+		return err
+	}
+	bin, err := readArtifact(path, format)
+	if err != nil {
+		return fmt.Errorf("can't read full binary: %w", err)
+	}
+	if length >= 0 && int64(len(bin)) != length {
+		return fmt.Errorf("full binary %s is %d bytes, want %d", path, len(bin), length)
+	}
+	h.Write(bin)
+	if !bytes.Equal(h.Sum(nil), want.Value) {
+		return fmt.Errorf("full binary %s doesn't match its %s checksum", path, want.Algo)
+	}
+	return nil
+}
+
+// verifyPatchIndex checks the full binary the patch index at path
+// describes, and every patch it lists, in genDir.
+func (v *verifier) verifyPatchIndex(genDir, path string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		v.fail(err)
+		return
+	}
+	var index patchIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		v.fail(fmt.Errorf("%s: unreadable patch index: %w", path, err))
+		return
+	}
+	if !isDirName(index.Version) {
+		v.fail(fmt.Errorf("%s: invalid version %q", path, index.Version))
+		return
+	}
+	if index.Hash.Algo == "" || len(index.Patches) == 0 {
+		return
+	}
+	platform := index.Platform
+	if platform == "" {
+		platform = strings.TrimSuffix(filepath.Base(path), ".patches.json")
+	}
+	dir := filepath.Join(genDir, index.Version)
+	binPath, format := fullBinPath(dir, platform)
+	if err := v.verifyFullBin(binPath, format, index.Hash, -1); err != nil {
+		v.fail(fmt.Errorf("%s: %w", path, err))
+		return
+	}
+	algo := index.DiffAlgo
+	if algo == "" {
+		algo = "bsdiff"
+	}
+	for _, p := range index.Patches {
+		v.patches++
+		if err := v.verifyPatch(genDir, platform, index, algo, p); err != nil {
+			v.fail(fmt.Errorf("patch from %s to %s for %s: %w", p.From, index.Version, platform, err))
+		}
+	}
+}
+
+// verifyPatch checks that the patch p of index is the one recorded and
+// turns the full binary of p.From into one hashing to index.Hash.
+func (v *verifier) verifyPatch(genDir, platform string, index patchIndex, algo string, p patchEntry) error {
+	if !isDirName(p.From) {
+		return fmt.Errorf("invalid version %q", p.From)
+	}
+	patchPath := filepath.Join(genDir, p.From, index.Version, platform+formatExt[index.Compression])
+	stored, err := os.ReadFile(patchPath)
+	if err != nil {
+		return err
+	}
+	if int64(len(stored)) != p.Length || !bytes.Equal(sha256Sum(stored), p.Sha256) {
+		return fmt.Errorf("%s doesn't match the patch index", patchPath)
+	}
+	patch := stored
+	if index.Compression != "" {
+		if patch, err = readArtifact(patchPath, index.Compression); err != nil {
+			return fmt.Errorf("can't decompress patch: %w", err)
+		}
+	}
+
+	fromDir := filepath.Join(genDir, p.From)
+	if p.FromSha256 != nil {
+		sum, err := storedSum(fromDir, platform)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(sum, p.FromSha256) {
+			return fmt.Errorf("full binary of %s changed since the patch was made", p.From)
+		}
+	}
+	oldPath, format := fullBinPath(fromDir, platform)
+	oldBin, err := readArtifact(oldPath, format)
+	if err != nil {
+		return err
+	}
+	out, err := applyPatch(algo, oldBin, patch)
+	if err != nil {
+		return fmt.Errorf("can't apply %s: %w", patchPath, err)
+	}
+	h, err := newHash(index.Hash.Algo)
+	if err != nil {
+		return err
+	}
+	h.Write(out)
+	if !bytes.Equal(h.Sum(nil), index.Hash.Value) {
+		return fmt.Errorf("patched binary doesn't match %s", index.Version)
+	}
+	return nil
+}
+
+// fullBinPath returns the path of the full binary for platform in dir and
+// its format, gzip unless only a zstd one is there.
+func fullBinPath(dir, platform string) (string, string) {
+	path := filepath.Join(dir, platform+formatExt["zstd"])
+	if _, err := os.Stat(path); err == nil {
+		if _, err := os.Stat(filepath.Join(dir, platform+formatExt["gzip"])); os.IsNotExist(err) {
+			return path, "zstd"
+		}
+	}
+	return filepath.Join(dir, platform+formatExt["gzip"]), "gzip"
+}
+
+// readArtifact returns the decompressed contents of the file at path,
+// written in format, or as they are if format is empty.
+func readArtifact(path, format string) ([]byte, error) {
+	if format == "" {
+		return os.ReadFile(path)
+	}
+	r, err := openArtifact(path, format)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,46 @@
+package generate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0.0", "1.1.0", "1.2.0")
+	publish(t, Options{OutputDir: dir, Format: "zstd", PatchFormat: "gzip", DiffAlgo: "zstd"}, "1.3.0")
+	publish(t, Options{OutputDir: dir, Channel: "beta", KeepUncompressed: true}, "1.4.0-beta.1")
+
+	if err := Verify(VerifyOptions{OutputDir: dir}); err != nil {
+		t.Fatalf("Verify returned error for an intact tree: %s", err)
+	}
+
+	// break a patch, a full binary and a channel manifest: every problem is
+	// reported
+	if err := os.WriteFile(filepath.Join(dir, "1.0.0", "1.1.0", "linux-amd64"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.3.0", "linux-amd64.zst"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "beta", "linux-amd64.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var verr *VerifyError
+	if err := Verify(VerifyOptions{OutputDir: dir}); !errors.As(err, &verr) {
+		t.Fatalf("Expected a *VerifyError for a corrupt tree, got %v", err)
+	}
+	report := filepath.ToSlash(errors.Join(verr.Problems...).Error())
+	for _, want := range []string{"patch from 1.0.0 to 1.1.0", "1.3.0/linux-amd64.zst", "beta/linux-amd64.json"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected a problem reporting %q, got:\n%s", want, report)
+		}
+	}
+
+	if err := Verify(VerifyOptions{OutputDir: filepath.Join(dir, "missing")}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing output directory to fail, got %v", err)
+	}
+}
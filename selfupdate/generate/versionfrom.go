@@ -0,0 +1,114 @@
+package generate
+
+import (
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// VersionSource reads the version to publish out of the binary itself, so
+// it can't get out of sync with the build.
+type VersionSource struct {
+	re *regexp.Regexp // nil reads the Go build info
+}
+
+// ParseVersionSource parses s, either "buildinfo" to take the main module
+// version Go records in the binary, or "regex:EXPR" to take the first
+// group of the first match of EXPR in the binary's bytes, or the whole
+// match if EXPR has no group.
+func ParseVersionSource(s string) (*VersionSource, error) {
+	if s == "buildinfo" {
+		return &VersionSource{}, nil
+	}
+	expr, ok := strings.CutPrefix(s, "regex:")
+	if !ok {
+		return nil, fmt.Errorf("invalid version source %q: want buildinfo or regex:EXPR", s)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version source: %w", err)
+	}
+	return &VersionSource{re: re}, nil
+}
+
+// Version returns the version of the binary at path.
+func (v *VersionSource) Version(path string) (string, error) {
+	if v.re == nil {
+		info, err := buildinfo.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if info.Main.Version == "" || info.Main.Version == "(devel)" {
+			return "", fmt.Errorf("%s: no module version in the build info", path)
+		}
+		return info.Main.Version, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	m := v.re.FindSubmatch(b)
+	if m == nil {
+		return "", fmt.Errorf("%s: no match for %s", path, v.re)
+	}
+	if len(m) > 1 {
+		return string(m[1]), nil
+	}
+	return string(m[0]), nil
+}
+
+// InputVersion returns the version of the binaries at inputPath, as given
+// in Options. The binaries of a directory or glob have to agree on it,
+// files it can't be read from, like notes, are ignored.
+func (v *VersionSource) InputVersion(inputPath string) (string, error) {
+	if inputPath == "-" {
+		return "", errors.New("can't read the version of a binary from stdin")
+	}
+	var paths []string
+	fi, err := os.Stat(inputPath)
+	switch {
+	case os.IsNotExist(err) && isGlob(inputPath):
+		if paths, err = filepath.Glob(inputPath); err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	case fi.IsDir():
+		entries, err := os.ReadDir(inputPath)
+		if err != nil {
+			return "", err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				paths = append(paths, filepath.Join(inputPath, e.Name()))
+			}
+		}
+	default:
+		return v.Version(inputPath)
+	}
+
+	var version, from string
+	var errList []error
+	for _, path := range paths {
+		found, err := v.Version(path)
+		if err != nil {
+			errList = append(errList, err)
+			continue
+		}
+		if version != "" && found != version {
+			return "", fmt.Errorf("%s has version %s, but %s has %s", path, found, from, version)
+		}
+		version, from = found, path
+	}
+	switch {
+	case len(paths) == 0:
+		return "", fmt.Errorf("no binaries in %s", inputPath)
+	case version == "":
+		return "", fmt.Errorf("no version found in %s: %w", inputPath, errors.Join(errList...))
+	}
+	return version, nil
+}
@@ -0,0 +1,57 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVersionSource(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"linux-amd64":  "\x7fELF...myapp version 1.4.2\x00...",
+		"darwin-arm64": "\xcf\xfa\xed\xfe...myapp version 1.4.2\x00...",
+		"NOTES":        "release notes",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := ParseVersionSource(`regex:myapp version (\d+\.\d+\.\d+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{dir, filepath.Join(dir, "linux-amd64"), filepath.Join(dir, "*-*")} {
+		if v, err := src.InputVersion(input); err != nil || v != "1.4.2" {
+			t.Errorf("InputVersion(%s) = %q, %v; want 1.4.2", input, v, err)
+		}
+	}
+	if _, err := src.InputVersion(filepath.Join(dir, "NOTES")); err == nil {
+		t.Error("Expected an error for a file without a version")
+	}
+	if _, err := src.InputVersion("-"); err == nil {
+		t.Error("Expected an error for stdin")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "windows-amd64"), []byte("myapp version 1.4.1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.InputVersion(dir); err == nil {
+		t.Error("Expected an error for binaries disagreeing on the version")
+	}
+
+	buildinfo, err := ParseVersionSource("buildinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildinfo.Version(filepath.Join(dir, "NOTES")); err == nil {
+		t.Error("Expected an error for a file that isn't a Go binary")
+	}
+
+	for _, s := range []string{"", "ldflags", "regex:("} {
+		if _, err := ParseVersionSource(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package generate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writePlan records the writes skipped in dry-run mode.
+type writePlan struct {
+	mu      sync.Mutex
+	files   int
+	patches int
+	bytes   int64
+}
+
+func (p *writePlan) add(size int, isPatch bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files++
+	p.bytes += int64(size)
+	if isPatch {
+		p.patches++
+	}
+}
+
+// summary describes the planned writes.
+func (p *writePlan) summary() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Sprintf("Dry run: would write %d files (%d patches), %d bytes in total", p.files, p.patches, p.bytes)
+}
+
+// checksumSet holds the sha256 of each file written, keyed by its path
+// relative to OutputDir, so a SHA256SUMS file can be produced and the files
+// uploaded at the end of the run.
+type checksumSet struct {
+	sync.Mutex
+	sums map[string][sha256.Size]byte
+}
+
+func (g *generator) recordChecksum(path string, sum [sha256.Size]byte) {
+	rel, err := filepath.Rel(g.OutputDir, path)
+	if err != nil {
+		rel = path
+	}
+	g.written.Lock()
+	g.written.sums[filepath.ToSlash(rel)] = sum
+	g.written.Unlock()
+}
+
+// writeChecksums writes SHA256SUMS to the root of OutputDir in the format
+// of coreutils' sha256sum, covering every file written during this run.
+func (g *generator) writeChecksums() error {
+	g.written.Lock()
+	paths := make([]string, 0, len(g.written.sums))
+	for path := range g.written.sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%x  %s\n", g.written.sums[path], path)
+	}
+	g.written.Unlock()
+
+	return g.writeFile(filepath.Join(g.OutputDir, "SHA256SUMS"), []byte(b.String()), false)
+}
+
+// artifactFile streams an artifact to Storage. With LocalStorage it is
+// written to a temporary file in the same directory and renamed into place
+// by Close, so clients polling the output directory see either the
+// previous or the complete new file, never a partial one. Other Storage
+// gets the whole content on Close. In dry-run mode the content is only
+// counted. Close records the write for Checksums and DryRun.
+type artifactFile struct {
+	g       *generator
+	path    string
+	isPatch bool
+	f       *os.File      // the temporary file of LocalStorage
+	buf     *bytes.Buffer // the content for other Storage
+	sum     hash.Hash
+	n       int64
+	elapsed time.Duration // Spent writing, if timed
+}
+
+// createFile starts writing path to Storage, unless in dry-run mode.
+func (g *generator) createFile(path string, isPatch bool) (*artifactFile, error) {
+	a := &artifactFile{g: g, path: path, isPatch: isPatch, sum: sha256.New()}
+	if g.DryRun {
+		return a, nil
+	}
+	local, ok := g.Storage.(LocalStorage)
+	if !ok {
+		a.buf = new(bytes.Buffer)
+		return a, nil
+	}
+	f, err := local.create(path)
+	if err != nil {
+		return nil, err
+	}
+	a.f = f
+	return a, nil
+}
+
+func (a *artifactFile) Write(p []byte) (int, error) {
+	if a.f != nil {
+		start := a.g.now()
+		n, err := a.f.Write(p)
+		a.elapsed += a.g.since(start)
+		if err != nil {
+			return n, err
+		}
+	}
+	if a.buf != nil {
+		a.buf.Write(p)
+	}
+	a.sum.Write(p)
+	a.n += int64(len(p))
+	return len(p), nil
+}
+
+// Close finishes the file and moves it into place. In dry-run mode it
+// reports the write, noting when an existing file would be overwritten.
+// The write is counted in the Summary either way.
+func (a *artifactFile) Close() error {
+	start := a.g.now()
+	switch {
+	case a.f != nil:
+		if err := commit(a.f, a.path); err != nil {
+			return err
+		}
+	case a.buf != nil:
+		if err := a.g.Storage.Write(a.path, a.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	a.elapsed += a.g.since(start)
+	a.g.summary.wrote(a.n, a.elapsed)
+	if a.g.Checksums || a.g.Upload != nil {
+		var sum [sha256.Size]byte
+		copy(sum[:], a.sum.Sum(nil))
+		a.g.recordChecksum(a.path, sum)
+	}
+	if a.g.DryRun {
+		note := ""
+		if _, err := os.Stat(a.path); err == nil {
+			note = " (overwrite)"
+		}
+		a.g.log.Printf("Would write %s, %d bytes%s", a.path, a.n, note)
+		a.g.plan.add(int(a.n), a.isPatch)
+	}
+	return nil
+}
+
+// discard abandons a file that couldn't be written completely, leaving any
+// previous file at its path untouched.
+func (a *artifactFile) discard() {
+	if a.f != nil {
+		a.f.Close()
+		os.Remove(a.f.Name())
+	}
+	a.buf = nil
+}
+
+// writeFile writes data to path in Storage, see createFile.
+func (g *generator) writeFile(path string, data []byte, isPatch bool) error {
+	_, err := g.writeFileSum(path, data, isPatch)
+	return err
+}
+
+// writeFileSum is writeFile, also returning the sha256 of data computed on
+// the way.
+func (g *generator) writeFileSum(path string, data []byte, isPatch bool) ([]byte, error) {
+	a, err := g.createFile(path, isPatch)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.Write(data); err != nil {
+		a.discard()
+		return nil, err
+	}
+	if err := a.Close(); err != nil {
+		return nil, err
+	}
+	return a.sum.Sum(nil), nil
+}
+
+// mkdirAll creates path and any missing parents in Storage, unless in
+// dry-run mode. It is safe to call concurrently for the same or
+// overlapping paths: a directory created by another caller in the
+// meantime is not an error.
+func (g *generator) mkdirAll(path string) error {
+	if g.DryRun {
+		return nil
+	}
+	return g.Storage.Mkdir(path)
+}
+
+// removeAll removes path and everything below it from Storage, unless in
+// dry-run mode. What is below it is listed in OutputDir and removed
+// children first.
+func (g *generator) removeAll(path string) error {
+	if g.DryRun {
+		g.log.Printf("Would remove %s", path)
+		return nil
+	}
+	var paths []string
+	err := filepath.WalkDir(path, func(p string, _ fs.DirEntry, err error) error {
+		paths = append(paths, p)
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := g.Storage.Remove(paths[i]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
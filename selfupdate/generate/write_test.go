@@ -0,0 +1,161 @@
+package generate
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteChecksums(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir, Checksums: true}, "1.0", "1.1")
+
+	b, err := os.ReadFile(filepath.Join(dir, "SHA256SUMS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var paths []string
+	for _, line := range lines {
+		sum, path, ok := strings.Cut(line, "  ")
+		if !ok {
+			t.Fatalf("Malformed line %q", line)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("%x", sha256.Sum256(data)); sum != want {
+			t.Errorf("%s: checksum %s; want %s", path, sum, want)
+		}
+		paths = append(paths, path)
+	}
+	// only the files written by the last run are covered
+	want := "1.0/1.1/linux-amd64 1.1/linux-amd64.gz 1.1/linux-amd64.json 1.1/linux-amd64.patches.json index.json latest.json linux-amd64.json"
+	if got := strings.Join(paths, " "); got != want {
+		t.Errorf("SHA256SUMS covers %s; want %s", got, want)
+	}
+}
+
+func TestWriteFileMode(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0")
+
+	for _, p := range []string{"linux-amd64.json", "1.0/linux-amd64.gz"} {
+		fi, err := os.Stat(filepath.Join(dir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm()&0111 != 0 {
+			t.Errorf("%s should not be executable, mode %s", p, fi.Mode())
+		}
+	}
+}
+
+func TestArtifactFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	g, err := newGenerator(Options{InputPath: "myapp", Version: "1.0.0", OutputDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "linux-amd64.json")
+	if err := g.writeFile(path, []byte("old"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := g.createFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Write([]byte("half written"))
+	if b, _ := os.ReadFile(path); string(b) != "old" {
+		t.Errorf("Readers should see the previous file while writing, got %q", b)
+	}
+	a.discard()
+	if b, _ := os.ReadFile(path); string(b) != "old" {
+		t.Errorf("A discarded write should leave the previous file, got %q", b)
+	}
+
+	if err := g.writeFile(path, []byte("new"), false); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "new" {
+		t.Errorf("Expected the new file, got %q", b)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, got %v", entries)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("Expected mode 0644, got %s", fi.Mode())
+	}
+}
+
+// memStorage is a Storage keeping the files in memory, keyed by their
+// slash separated path relative to root.
+type memStorage struct {
+	root    string
+	mu      sync.Mutex
+	files   map[string][]byte
+	dirs    map[string]bool
+	removed []string
+}
+
+func (m *memStorage) rel(path string) string {
+	rel, _ := filepath.Rel(m.root, path)
+	return filepath.ToSlash(rel)
+}
+
+func (m *memStorage) Write(path string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[m.rel(path)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memStorage) Mkdir(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dirs[m.rel(path)] = true
+	return nil
+}
+
+func (m *memStorage) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removed = append(m.removed, m.rel(path))
+	return nil
+}
+
+func TestGenerateUpdateStorage(t *testing.T) {
+	dir := t.TempDir()
+	publish(t, Options{OutputDir: dir}, "1.0")
+	m := &memStorage{root: dir, files: map[string][]byte{}, dirs: map[string]bool{}}
+	publish(t, Options{OutputDir: dir, Storage: m}, "1.1")
+
+	for _, name := range []string{"1.1/linux-amd64.gz", "1.0/1.1/linux-amd64", "linux-amd64.json", "index.json"} {
+		if len(m.files[name]) == 0 {
+			t.Errorf("Expected %s in the storage, got %v", name, m.files)
+		}
+	}
+	if !m.dirs["1.1"] || !m.dirs["1.0/1.1"] {
+		t.Errorf("Expected the version directories in the storage, got %v", m.dirs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.1")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written to the output directory, got %v", err)
+	}
+	if c := readManifest(t, dir, "linux-amd64"); c.Version != "1.0" {
+		t.Errorf("Expected the local manifest to stay at 1.0, got %s", c.Version)
+	}
+}
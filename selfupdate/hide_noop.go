@@ -6,3 +6,7 @@ package selfupdate
 func hideFile(path string) error {
 	return nil
 }
+
+func unhideFile(path string) error {
+	return nil
+}
@@ -5,11 +5,24 @@ import (
 	"unsafe"
 )
 
+const (
+	fileAttributeHidden = 0x2
+	fileAttributeNormal = 0x80
+)
+
 func hideFile(path string) error {
+	return setFileAttributes(path, fileAttributeHidden)
+}
+
+func unhideFile(path string) error {
+	return setFileAttributes(path, fileAttributeNormal)
+}
+
+func setFileAttributes(path string, attrs uintptr) error {
 	kernel32 := syscall.NewLazyDLL("kernel32.dll")
 	setFileAttributes := kernel32.NewProc("SetFileAttributesW")
 
-	r1, _, err := setFileAttributes.Call(uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(path))), 2)
+	r1, _, err := setFileAttributes.Call(uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(path))), attrs)
 
 	if r1 == 0 {
 		return err
@@ -0,0 +1,117 @@
+package selfupdate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Layout names the published files, below the base URLs and the command
+// name and channel, for hosting schemes that don't fit the default one.
+// Each field is a template of a slash separated path with the placeholders
+// {version}, {platform} and, for Patch, {old}, the version the patch
+// applies to. An empty field keeps the default path. The generator must
+// publish with the same Layout, see generate.Options.Layout.
+type Layout struct {
+	Manifest        string // Manifest of the latest version, "{platform}.json" by default
+	VersionManifest string // Manifest kept for every version, "{version}/{platform}.json" by default
+	Binary          string // Full binary, "{version}/{platform}" by default, plus the extension of its compression
+	Patch           string // Patch, "{old}/{version}/{platform}" by default, plus the extension of its compression
+	PatchIndex      string // Patch index, "{version}/{platform}.patches.json" by default
+}
+
+// defaultLayout is the layout of the generator's output directory.
+var defaultLayout = Layout{
+	Manifest:        "{platform}.json",
+	VersionManifest: "{version}/{platform}.json",
+	Binary:          "{version}/{platform}",
+	Patch:           "{old}/{version}/{platform}",
+	PatchIndex:      "{version}/{platform}.patches.json",
+}
+
+var layoutPlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// Validate checks that every template of l is a relative path using all
+// the placeholders it has, and no others.
+func (l Layout) Validate() error {
+	l = l.withDefaults()
+	for _, t := range []struct {
+		name, template string
+		required       []string
+	}{
+		{"manifest", l.Manifest, []string{"{platform}"}},
+		{"version-manifest", l.VersionManifest, []string{"{version}", "{platform}"}},
+		{"binary", l.Binary, []string{"{version}", "{platform}"}},
+		{"patch", l.Patch, []string{"{old}", "{version}", "{platform}"}},
+		{"patch-index", l.PatchIndex, []string{"{version}", "{platform}"}},
+	} {
+		placeholders := strings.Join(t.required, " ")
+		for _, p := range layoutPlaceholder.FindAllString(t.template, -1) {
+			if !strings.Contains(placeholders, p) {
+				return fmt.Errorf("invalid %s layout %q: unknown placeholder %s", t.name, t.template, p)
+			}
+		}
+		for _, p := range t.required {
+			if !strings.Contains(t.template, p) {
+				return fmt.Errorf("invalid %s layout %q: missing %s", t.name, t.template, p)
+			}
+		}
+		for _, elem := range strings.Split(t.template, "/") {
+			if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(layoutPlaceholder.ReplaceAllString(elem, ""), `\{}`) {
+				return fmt.Errorf("invalid %s layout %q: want a relative slash separated path", t.name, t.template)
+			}
+		}
+	}
+	return nil
+}
+
+// ManifestPath returns the path of the manifest of the latest version for
+// platform.
+func (l Layout) ManifestPath(platform string) string {
+	return expandLayout(l.withDefaults().Manifest, "", "", platform)
+}
+
+// VersionManifestPath returns the path of the manifest kept for version.
+func (l Layout) VersionManifestPath(version, platform string) string {
+	return expandLayout(l.withDefaults().VersionManifest, "", version, platform)
+}
+
+// BinaryPath returns the path of the full binary of version, with ext, the
+// extension of its compression.
+func (l Layout) BinaryPath(version, platform, ext string) string {
+	return expandLayout(l.withDefaults().Binary, "", version, platform) + ext
+}
+
+// PatchPath returns the path of the patch from old to version, with ext,
+// the extension of its compression.
+func (l Layout) PatchPath(old, version, platform, ext string) string {
+	return expandLayout(l.withDefaults().Patch, old, version, platform) + ext
+}
+
+// PatchIndexPath returns the path of the patch index of version.
+func (l Layout) PatchIndexPath(version, platform string) string {
+	return expandLayout(l.withDefaults().PatchIndex, "", version, platform)
+}
+
+// withDefaults returns l with the empty templates set from defaultLayout.
+func (l Layout) withDefaults() Layout {
+	for _, f := range []struct {
+		t   *string
+		def string
+	}{
+		{&l.Manifest, defaultLayout.Manifest},
+		{&l.VersionManifest, defaultLayout.VersionManifest},
+		{&l.Binary, defaultLayout.Binary},
+		{&l.Patch, defaultLayout.Patch},
+		{&l.PatchIndex, defaultLayout.PatchIndex},
+	} {
+		if *f.t == "" {
+			*f.t = f.def
+		}
+	}
+	return l
+}
+
+func expandLayout(template, old, version, platform string) string {
+	return strings.NewReplacer("{old}", old, "{version}", version, "{platform}", platform).Replace(template)
+}
@@ -0,0 +1,24 @@
+package selfupdate
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardLogger drops every event, for an Updater without Logger.
+var discardLogger = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// logger returns Logger, or a logger dropping everything if it isn't set.
+func (u *Updater) logger() *slog.Logger {
+	if u.Logger != nil {
+		return u.Logger
+	}
+	return discardLogger
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package selfupdate
+
+import "os"
+
+// preserveOwner is a no-op where files have no unix owner.
+func preserveOwner(path string, fi os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package selfupdate
+
+import (
+	"os"
+	"syscall"
+)
+
+// preserveOwner gives path the owner and group recorded in fi. Only root
+// can hand a file to another user, so a binary installed by someone else
+// and updated by its unprivileged user ends up owned by that user.
+func preserveOwner(path string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || (int(st.Uid) == os.Getuid() && int(st.Gid) == os.Getgid()) {
+		return nil
+	}
+	if err := os.Chown(path, int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/kr/binarydist"
+)
+
+// applyPatch applies patch, made with the diff algorithm algo, to old and
+// returns the result. An empty algo is bsdiff, the only one of manifests
+// and patch indexes written before it was recorded.
+func applyPatch(ctx context.Context, algo string, old io.Reader, patch []byte) ([]byte, error) {
+	switch algo {
+	case "", "bsdiff":
+		var buf bytes.Buffer
+		err := binarydist.Patch(&ctxReader{ctx, old}, &buf, bytes.NewReader(patch))
+		return buf.Bytes(), err
+	case "zstd":
+		// the patch is the new binary compressed with the old one as
+		// dictionary
+		dict, err := io.ReadAll(&ctxReader{ctx, old})
+		if err != nil {
+			return nil, err
+		}
+		d, err := zstd.NewReader(nil, zstd.WithDecoderDictRaw(0, dict), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		return d.DecodeAll(patch, nil)
+	}
+	return nil, fmt.Errorf("unsupported diff algorithm %q", algo)
+}
@@ -0,0 +1,32 @@
+package selfupdate
+
+import "strings"
+
+// archAliases maps the architecture names used by other tools, like uname
+// or Debian, to their GOARCH.
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x64":     "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"i686":    "386",
+	"x86":     "386",
+	"armv7l":  "arm",
+	"armhf":   "arm",
+}
+
+// NormalizePlatform canonicalizes the architecture of platform, an OS-ARCH
+// string like linux-x86_64, to its GOARCH name, so the generator and the
+// client agree that linux-x86_64 and linux-amd64 are the same platform. The
+// architecture is the part after the last dash; platforms without a known
+// alias are returned unchanged.
+func NormalizePlatform(platform string) string {
+	i := strings.LastIndex(platform, "-")
+	if i < 0 {
+		return platform
+	}
+	if arch, ok := archAliases[strings.ToLower(platform[i+1:])]; ok {
+		return platform[:i+1] + arch
+	}
+	return platform
+}
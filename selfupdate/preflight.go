@@ -0,0 +1,86 @@
+package selfupdate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errSpaceUnknown is returned by freeSpace where it isn't supported.
+var errSpaceUnknown = errors.New("free space unknown on this platform")
+
+// ErrNotWritable is returned before an update is downloaded when the new
+// binary can't be written to Dir, the directory of the file it replaces,
+// typically because the app was installed by another user.
+type ErrNotWritable struct {
+	Dir string
+	Err error
+}
+
+func (e *ErrNotWritable) Error() string {
+	return fmt.Sprintf("can't write the update to %s: %s", e.Dir, e.Err)
+}
+
+func (e *ErrNotWritable) Unwrap() error {
+	return e.Err
+}
+
+// ErrInsufficientSpace is returned before an update is downloaded when the
+// filesystem of Dir, the directory of the file it replaces, has less free
+// space than the update needs.
+type ErrInsufficientSpace struct {
+	Dir  string
+	Need int64 // Bytes the update writes to Dir
+	Free int64 // Bytes available in Dir
+}
+
+func (e *ErrInsufficientSpace) Error() string {
+	return fmt.Sprintf("not enough free space in %s for the update: need %d bytes, %d available", e.Dir, e.Need, e.Free)
+}
+
+// preflight checks that the binary described by u.Info can be installed at
+// path before anything is downloaded: the new binary can be written next
+// to it, and there is room for it there. The executable is backed up by
+// renaming it, which takes no space, but the backup of a TargetPath may be
+// a copy, so room for that is needed too. The space isn't checked if the
+// manifest doesn't record the size of the binary, or where the free space
+// is unknown.
+func (u *Updater) preflight(path string) error {
+	if err := checkWritable(path); err != nil {
+		return err
+	}
+	need := u.Info.Length
+	if need <= 0 {
+		return nil
+	}
+	if u.TargetPath != "" {
+		if fi, err := os.Stat(path); err == nil {
+			need += fi.Size()
+		}
+	}
+	dir := filepath.Dir(path)
+	free, err := freeSpace(dir)
+	if err != nil {
+		u.logger().Debug("update: skipping the free space check", "dir", dir, "error", err)
+		return nil
+	}
+	if need > free {
+		return &ErrInsufficientSpace{Dir: dir, Need: need, Free: free}
+	}
+	return nil
+}
+
+// checkWritable checks that the new binary for the file at path can be
+// created next to it, by creating and removing a temporary file. It isn't
+// .<name>.new itself, which Recover may still need.
+func checkWritable(path string) error {
+	dir := filepath.Dir(path)
+	fp, err := os.CreateTemp(dir, fmt.Sprintf(".%s.*.tmp", filepath.Base(path)))
+	if err != nil {
+		return &ErrNotWritable{Dir: dir, Err: err}
+	}
+	fp.Close()
+	_ = os.Remove(fp.Name())
+	return nil
+}
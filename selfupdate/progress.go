@@ -0,0 +1,77 @@
+package selfupdate
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// ProgressFunc is called as an update downloads, with the bytes received
+// so far and the total expected, or 0 if the manifest doesn't say. Both
+// count bytes as sent by the server, so compressed ones for a compressed
+// file. A retried download starts over from the bytes it resumes at.
+type ProgressFunc func(downloaded, total int64)
+
+// Phase is a step of installing an update, reported to OnPhase.
+type Phase string
+
+const (
+	PhaseDownloading Phase = "downloading"    // Fetching a patch or the full binary, see Progress
+	PhaseApplying    Phase = "applying patch" // Patching the running binary
+	PhaseVerifying   Phase = "verifying"      // Checking the new binary against its checksum
+)
+
+func (u *Updater) phase(p Phase) {
+	if u.OnPhase != nil {
+		u.OnPhase(p)
+	}
+}
+
+// reporter returns the function reporting n more bytes downloaded after
+// done to Progress, out of total, and each quarter of total to Logger at
+// debug level, or nil if neither is set.
+func (u *Updater) reporter(done, total int64) func(n int64) {
+	logged := false
+	if u.Logger != nil && total > 0 {
+		logged = u.Logger.Enabled(context.Background(), slog.LevelDebug)
+	}
+	if u.Progress == nil && !logged {
+		return nil
+	}
+	quarter := int64(0)
+	return func(n int64) {
+		if u.Progress != nil {
+			u.Progress(done+n, total)
+		}
+		if logged && (done+n)*4/total > quarter {
+			quarter = min((done+n)*4/total, 4)
+			u.Logger.Debug("update: download progress", "downloaded", done+n, "total", total, "percent", quarter*25)
+		}
+	}
+}
+
+// progressReader calls report with the number of bytes read from r so far,
+// starting from n.
+type progressReader struct {
+	r      io.Reader
+	n      int64
+	report func(int64)
+}
+
+// withProgress wraps r to call report, if not nil, starting from n.
+func withProgress(r io.Reader, n int64, report func(int64)) io.Reader {
+	if report == nil {
+		return r
+	}
+	report(n)
+	return &progressReader{r: r, n: n, report: report}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		r.report(r.n)
+	}
+	return n, err
+}
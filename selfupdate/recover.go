@@ -0,0 +1,174 @@
+package selfupdate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const installStatePath = "last-install.json" // path to the record of the last install relative to u.Dir
+
+// installState records the update install last swapped in, or was about
+// to, for Recover.
+type installState struct {
+	Version  string
+	Path     string // File the update replaced
+	Algo     string // Hash algorithm of Sum
+	Sum      []byte // Checksum of the new binary
+	Complete bool   // The new binary was swapped in
+}
+
+// Recover brings the file an update replaces, the running executable or
+// TargetPath, back to a consistent state after an update was interrupted,
+// say by a crash or power loss. Call it at startup, before checking for
+// updates. It looks for the files an update leaves next to it,
+// .<name>.new for the new binary before it is swapped in, .<name>.old for
+// the backup and .<name>.failed for a binary replaced by Rollback, and
+// for the record of the last install kept in Dir:
+//
+//   - the file is missing, as the update stopped between moving it to
+//     .<name>.old and moving .<name>.new in its place: the swap is
+//     completed if .<name>.new matches the checksum of the update being
+//     installed, and otherwise .<name>.old is moved back
+//   - the file is there next to a .<name>.new: the update stopped before
+//     the swap, so the file is the previous binary, and .<name>.new is
+//     removed
+//   - a .<name>.failed is left by Rollback: it is removed
+//
+// Then the file is checked against the checksum of the last install. An
+// install that stopped before the swap is forgotten, and one that swapped
+// the binary in without recording it is recorded as complete. If the file
+// was installed and CurrentVersion is the version installed, but the
+// checksum doesn't match, the error is an *ErrChecksumMismatch. It isn't
+// checked against a different CurrentVersion, as the file was replaced
+// since, for example by Rollback or a reinstall.
+//
+// The backup .<name>.old is always kept for Rollback. Recover does nothing
+// if no update was interrupted.
+func (u *Updater) Recover() error {
+	path, err := u.target()
+	if err != nil {
+		return err
+	}
+	state := u.readInstallState()
+	if state != nil && state.Path != path {
+		state = nil
+	}
+	if err := u.recoverSwap(path, state); err != nil {
+		return err
+	}
+	if err := os.Remove(failedPath(path)); err == nil {
+		u.logger().Info("update: removed the binary replaced by a rollback", "path", failedPath(path))
+	}
+	if state == nil {
+		return nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	errSum := verifyHash(b, state.Algo, state.Sum)
+	switch {
+	case errSum == nil && !state.Complete:
+		state.Complete = true
+		u.saveInstallState(state)
+		u.logger().Info("update: recorded an interrupted install as complete", "version", state.Version, "path", path)
+	case errSum == nil:
+	case !state.Complete:
+		// the swap never happened, the previous binary is in place
+		u.clearInstallState()
+		u.logger().Info("update: install was interrupted before the swap, the previous version is in place", "version", state.Version, "path", path)
+	case u.CurrentVersion != state.Version:
+		// replaced since, by Rollback or a reinstall
+		u.clearInstallState()
+	default:
+		u.logger().Error("update: installed binary doesn't match its checksum", "version", state.Version, "path", path, "error", errSum)
+		return fmt.Errorf("%s doesn't match version %s it was updated to: %w", path, state.Version, errSum)
+	}
+	return nil
+}
+
+// recoverSwap reconciles the file at path with the .new and .old files an
+// interrupted swap leaves, see Recover.
+func (u *Updater) recoverSwap(path string, state *installState) error {
+	newPath := newFilePath(path)
+	oldPath := backupPath(path)
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Remove(newPath); err == nil {
+			u.logger().Info("update: removed a new binary that wasn't swapped in", "path", newPath)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if state != nil {
+		if b, err := os.ReadFile(newPath); err == nil && verifyHash(b, state.Algo, state.Sum) == nil {
+			if err := os.Rename(newPath, path); err != nil {
+				return err
+			}
+			u.logger().Info("update: completed an interrupted install", "version", state.Version, "path", path)
+			return nil
+		}
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		return fmt.Errorf("%s is missing and there's no backup to restore it from: %w", path, err)
+	}
+	if err := os.Rename(oldPath, path); err != nil {
+		return err
+	}
+	_ = unhideFile(path)
+	_ = os.Remove(newPath)
+	u.logger().Info("update: restored the previous version after an interrupted install", "path", path)
+	return nil
+}
+
+// saveInstallState records state in Dir. The record only serves Recover,
+// so failing to write it is not an error.
+func (u *Updater) saveInstallState(state *installState) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	dir := u.getExecRelativeDir(u.Dir)
+	err = os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, installStatePath), b, 0644)
+	}
+	if err != nil {
+		u.logger().Warn("update: can't record the install", "error", err)
+	}
+}
+
+// readInstallState returns the record of the last install, or nil if
+// there is none or it can't be read.
+func (u *Updater) readInstallState() *installState {
+	b, err := os.ReadFile(filepath.Join(u.getExecRelativeDir(u.Dir), installStatePath))
+	if err != nil {
+		return nil
+	}
+	var state installState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil
+	}
+	return &state
+}
+
+func (u *Updater) clearInstallState() {
+	_ = os.Remove(filepath.Join(u.getExecRelativeDir(u.Dir), installStatePath))
+}
+
+// newFilePath returns where an update writes the new version of the file
+// at path before swapping it in.
+func newFilePath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.new", filepath.Base(path)))
+}
+
+// failedPath returns where Rollback moves the file at path it replaces.
+func failedPath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.failed", filepath.Base(path)))
+}
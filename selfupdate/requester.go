@@ -1,9 +1,12 @@
 package selfupdate
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
 // Requester interface allows developers to customize the method in which
@@ -12,23 +15,165 @@ type Requester interface {
 	Fetch(url string) (io.ReadCloser, error)
 }
 
+// ContextRequester is a Requester that can abort a request when ctx is
+// canceled. Updater uses FetchContext instead of Fetch when it is
+// implemented.
+type ContextRequester interface {
+	Requester
+	FetchContext(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+// RangeRequester is a Requester that can resume a download. Updater uses it
+// to continue an interrupted download of a full binary where it stopped.
+type RangeRequester interface {
+	Requester
+	// FetchRange requests url from offset on. If partial is false the body
+	// holds the whole file, as sent by servers not supporting ranges.
+	FetchRange(ctx context.Context, url string, offset int64) (body io.ReadCloser, partial bool, err error)
+}
+
+// ConditionalRequester is a Requester that can skip downloading a file
+// that didn't change since it was last downloaded. Updater uses it to poll
+// the manifest without downloading it again while it offers no update.
+type ConditionalRequester interface {
+	Requester
+	// FetchIfModified requests url unless it still has the Validators v of
+	// a previous response, in which case it returns ErrNotModified.
+	// Otherwise it returns the body and the Validators of the response,
+	// which are zero if it has none. A zero v requests url in any case.
+	FetchIfModified(ctx context.Context, url string, v Validators) (io.ReadCloser, Validators, error)
+}
+
+// Validators identify the version of a file served over HTTP, from the
+// ETag and Last-Modified headers of the response.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// ErrNotModified is returned by FetchIfModified for a file that didn't
+// change.
+var ErrNotModified = errors.New("not modified")
+
 // HTTPRequester is the normal requester that is used and does an HTTP
 // to the URL location requested to retrieve the specified data.
-type HTTPRequester struct{}
+//
+// Its zero value uses http.DefaultClient. Set Client for a custom TLS
+// config, proxy or timeout, and Header to add headers like Authorization
+// to every request:
+//
+//	updater.Requester = &selfupdate.HTTPRequester{
+//		Header: http.Header{"Authorization": {"Bearer " + token}},
+//	}
+type HTTPRequester struct {
+	Client *http.Client // Client making the requests, nil means http.DefaultClient
+	Header http.Header  // Headers added to every request
+}
 
 // Fetch will return an HTTP request to the specified url and return
 // the body of the result. An error will occur for a non 200 status code.
 func (httpRequester *HTTPRequester) Fetch(url string) (io.ReadCloser, error) {
-	resp, err := http.Get(url)
+	return httpRequester.FetchContext(context.Background(), url)
+}
+
+// FetchContext is Fetch, aborting the request and the reading of its body
+// when ctx is done.
+func (httpRequester *HTTPRequester) FetchContext(ctx context.Context, url string) (io.ReadCloser, error) {
+	resp, err := httpRequester.do(ctx, url, 0, Validators{})
 	if err != nil {
 		return nil, err
 	}
+	return resp.Body, nil
+}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad http status from %s: %v", url, resp.Status)
+// FetchRange is FetchContext with a Range header requesting url from
+// offset on. The body only starts at offset if the server answers with 206
+// Partial Content for that offset; servers ignoring ranges send the whole
+// file. If offset is past the end of the file, it is requested in full.
+func (httpRequester *HTTPRequester) FetchRange(ctx context.Context, url string, offset int64) (io.ReadCloser, bool, error) {
+	resp, err := httpRequester.do(ctx, url, offset, Validators{})
+	var status *StatusError
+	if errors.As(err, &status) && status.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp, err = httpRequester.do(ctx, url, 0, Validators{})
+	}
+	if err != nil {
+		return nil, false, err
 	}
+	partial := resp.StatusCode == http.StatusPartialContent &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset))
+	if resp.StatusCode == http.StatusPartialContent && !partial {
+		// a range we didn't ask for can't be used
+		resp.Body.Close()
+		resp, err = httpRequester.do(ctx, url, 0, Validators{})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return resp.Body, partial, nil
+}
 
-	return resp.Body, nil
+// FetchIfModified is FetchContext sending the Validators v of a previous
+// response as If-None-Match and If-Modified-Since headers, returning
+// ErrNotModified for a 304 Not Modified response.
+func (httpRequester *HTTPRequester) FetchIfModified(ctx context.Context, url string, v Validators) (io.ReadCloser, Validators, error) {
+	resp, err := httpRequester.do(ctx, url, 0, v)
+	if err != nil {
+		return nil, Validators{}, err
+	}
+	return resp.Body, Validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, nil
+}
+
+// do requests url, from offset on if it is above 0, and unless it still
+// has the Validators v if they are set.
+func (httpRequester *HTTPRequester) do(ctx context.Context, url string, offset int64, v Validators) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range httpRequester.Header {
+		req.Header[key] = values
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	client := httpRequester.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && v != (Validators{}) {
+		resp.Body.Close()
+		return nil, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK && !(offset > 0 && resp.StatusCode == http.StatusPartialContent) {
+		resp.Body.Close()
+		return nil, &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return resp, nil
+}
+
+// StatusError is returned by HTTPRequester for a response other than 200 OK.
+// Updater retries server errors, see MaxAttempts, but not client errors
+// like a 404 for a missing file.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("bad http status from %s: %v", e.URL, e.Status)
 }
 
 // mockRequester used for some mock testing to ensure the requester contract
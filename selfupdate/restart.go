@@ -0,0 +1,20 @@
+package selfupdate
+
+import "os"
+
+// Restart replaces the running process with a new one of the executable,
+// with the same arguments and environment, so an update takes effect. On
+// unix the process image is replaced in place, keeping its PID. On windows,
+// where that isn't possible, the new process is started with the same
+// standard streams and the running one exits. Restart only returns on
+// failure.
+//
+// Anything that must not be held by two processes at once, like a
+// listening socket or a lock file, has to be released before calling it.
+func Restart() error {
+	path, err := executable()
+	if err != nil {
+		return err
+	}
+	return restart(path, os.Args, os.Environ())
+}
@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package selfupdate
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// restart is unsupported where processes can't be started.
+func restart(path string, args, env []string) error {
+	return fmt.Errorf("restart is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package selfupdate
+
+import "syscall"
+
+// restart replaces the process with path, run with args and env.
+func restart(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
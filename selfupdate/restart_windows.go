@@ -0,0 +1,19 @@
+package selfupdate
+
+import (
+	"os"
+	"os/exec"
+)
+
+// restart starts path with args and env, passing on the standard streams,
+// and exits.
+func restart(path string, args, env []string) error {
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
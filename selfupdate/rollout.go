@@ -0,0 +1,61 @@
+package selfupdate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+const installIDPath = "install-id" // path to the generated install ID relative to u.Dir
+
+// inRollout reports whether this installation is among the Info.Rollout
+// percent of clients offered Info.Version. A hash of the install ID puts
+// each installation in one of 100 buckets, and it is in the rollout while
+// its bucket is below the percentage, so raising the percentage only ever
+// adds installations.
+func (u *Updater) inRollout() (bool, error) {
+	if u.Info.Rollout == nil || *u.Info.Rollout >= 100 {
+		return true, nil
+	}
+	id, err := u.installID()
+	if err != nil {
+		return false, err
+	}
+	return rolloutBucket(id) < *u.Info.Rollout, nil
+}
+
+// rolloutBucket returns the bucket, from 0 to 99, of the installation
+// with the given ID.
+func rolloutBucket(id string) int {
+	sum := sha256.Sum256([]byte(id))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// installID returns InstallID if set, or else the random ID kept in Dir,
+// generated on first use.
+func (u *Updater) installID() (string, error) {
+	if u.InstallID != "" {
+		return u.InstallID, nil
+	}
+	dir := u.getExecRelativeDir(u.Dir)
+	path := filepath.Join(dir, installIDPath)
+	if b, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(b)) > 0 {
+		return string(bytes.TrimSpace(b)), nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return id, nil
+}
@@ -3,35 +3,113 @@ package selfupdate
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"math/rand"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
-	"github.com/kr/binarydist"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/crypto/blake2b"
 )
 
 const (
 	// holds a timestamp which triggers the next update
 	upcktimePath = "cktime"                            // path to timestamp file relative to u.Dir
 	plat         = runtime.GOOS + "-" + runtime.GOARCH // ex: linux-amd64
+
+	defaultRetryDelay      = time.Second      // RetryDelay if unset
+	defaultManifestTimeout = 30 * time.Second // ManifestTimeout if unset
 )
 
+// SchemaVersion is the newest manifest schema this package reads, and the
+// one the generator stamps on the manifests it writes. It is only raised
+// for changes older clients would misread; fields they can do without are
+// added without raising it, as unknown fields are ignored.
+const SchemaVersion = 1
+
 var (
+	// ErrHashMismatch matches every *ErrChecksumMismatch with errors.Is.
 	ErrHashMismatch = errors.New("new file hash mismatch after patch")
 
+	// ErrSchemaVersion is returned, wrapped, for a manifest written in a
+	// newer schema than SchemaVersion. Installing it takes a build of the
+	// app with a newer go-selfupdate.
+	ErrSchemaVersion = errors.New("manifest schema is newer than this client supports")
+
+	// errNoPatch is returned by fetchAndApplyPatch when no patch from the
+	// running version is available.
+	errNoPatch = errors.New("no patch from the current version")
+
 	defaultHTTPRequester = HTTPRequester{}
 )
 
+// ErrChecksumMismatch is returned when a new binary, patched or downloaded
+// in full, doesn't have the checksum recorded in the manifest. It tells an
+// integrity failure apart from network errors, for example to try another
+// mirror. A binary failing the check is never installed.
+type ErrChecksumMismatch struct {
+	Algo     string // Hash algorithm from the manifest
+	Expected []byte // Checksum from the manifest
+	Got      []byte // Checksum of the new binary
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %x, got %x", e.Algo, e.Expected, e.Got)
+}
+
+// Is makes errors.Is(err, ErrHashMismatch) keep working for callers
+// predating ErrChecksumMismatch.
+func (e *ErrChecksumMismatch) Is(target error) bool {
+	return target == ErrHashMismatch
+}
+
+// ErrPatchChecksumMismatch is returned when a downloaded patch doesn't
+// have the checksum the generator recorded for it. It is caught before the
+// patch is applied and, unlike an *ErrChecksumMismatch of the patched
+// binary, points at a corrupt or truncated download rather than at the
+// patch itself: the download is retried, see MaxAttempts, before the full
+// binary is downloaded instead.
+type ErrPatchChecksumMismatch struct {
+	From     string // Version the patch applies to
+	Version  string // Version the patch leads to
+	Expected []byte // sha256 recorded by the generator
+	Got      []byte // sha256 of the downloaded patch
+}
+
+func (e *ErrPatchChecksumMismatch) Error() string {
+	return fmt.Sprintf("downloaded patch from %s to %s: sha256 checksum mismatch: expected %x, got %x", e.From, e.Version, e.Expected, e.Got)
+}
+
+// ErrMinFromVersion is returned when the published version can't be
+// installed from CurrentVersion directly, as its manifest sets a
+// MinFromVersion newer than CurrentVersion, say because a migration in
+// between has to run first. Installing MinFromVersion with UpdateTo, and
+// then updating from there, gets to the published version.
+type ErrMinFromVersion struct {
+	Current        string // Running version
+	Version        string // Published version
+	MinFromVersion string // Oldest version Version can be installed from
+}
+
+func (e *ErrMinFromVersion) Error() string {
+	return fmt.Sprintf("can't update from %s to %s directly, update to %s first", e.Current, e.Version, e.MinFromVersion)
+}
+
 // Updater is the configuration and runtime data for doing an update.
 //
 // Note that ApiURL, BinURL and DiffURL should have the same value if all files are available at the same location.
@@ -50,21 +128,65 @@ var (
 //		go updater.BackgroundRun()
 //	}
 type Updater struct {
-	CurrentVersion string    // Currently running version. `dev` is a special version here and will cause the updater to never update.
-	ApiURL         string    // Base URL for API requests (JSON files).
-	CmdName        string    // Command name is appended to the ApiURL like http://apiurl/CmdName/. This represents one binary.
-	BinURL         string    // Base URL for full binary downloads.
-	DiffURL        string    // Base URL for diff downloads.
-	Dir            string    // Directory to store selfupdate state.
-	ForceCheck     bool      // Check for update regardless of cktime timestamp
-	CheckTime      int       // Time in hours before next check
-	RandomizeTime  int       // Time in hours to randomize with CheckTime
-	Requester      Requester // Optional parameter to override existing HTTP request handler
+	CurrentVersion string        // Currently running version. `dev` is a special version here and will cause the updater to never update.
+	ApiURL         string        // Base URL for API requests (JSON files).
+	CmdName        string        // Command name is appended to the ApiURL like http://apiurl/CmdName/. This represents one binary.
+	Channel        string        // Optional release channel, e.g. beta, appended after CmdName like http://apiurl/CmdName/Channel/
+	FlatNames      bool          // Fetch files by their path without CmdName, joined with "_", like the assets of a GitHub release
+	Layout         Layout        // Optional paths of the published files, which the generator must publish with too, see -layout
+	PlainDownload  bool          // Download the plain binary, if published, leaving compression to the HTTP transport
+	BinURL         string        // Base URL for full binary downloads.
+	DiffURL        string        // Base URL for diff downloads.
+	Dir            string        // Directory to store selfupdate state.
+	ForceCheck     bool          // Check for update regardless of cktime timestamp
+	CheckTime      int           // Time in hours before next check
+	RandomizeTime  int           // Time in hours to randomize with CheckTime
+	Requester      Requester     // Optional parameter to override existing HTTP request handler
+	MaxAttempts    int           // Attempts made for each download failing with a network or server error, 0 or 1 disables retries
+	RetryDelay     time.Duration // Delay before the first retry, doubled for each further one. Defaults to 1s
+	Mirrors        []string      // Base URLs serving the same files as ApiURL, BinURL and DiffURL, tried in order when a download from those fails
+	ShuffleMirrors bool          // Try the base URL and Mirrors in random order to spread the load
+	Mirror         string        // Base URL the last download was served from
+	AllowDowngrade bool          // Install the published version even if it is older than CurrentVersion, e.g. to roll back a bad release
+	TargetPath     string        // Optional file to update instead of the running executable, such as a data file published like a binary. Symlinks are resolved
+	CacheDownloads bool          // Keep the verified new binary in Dir until it is installed, so retrying a failed install skips the download
+	RateLimit      int64         // Optional limit on the speed of downloading a patch or the full binary, in bytes per second. Unlimited if 0
+	InstallID      string        // Optional stable ID of this installation deciding whether it is in a staged rollout, defaults to a random ID kept in Dir
+	Logger         *slog.Logger  // Optional logger for checks, downloads, installs and failures, with structured fields. Nothing is logged if nil
 	Info           struct {
-		Version string
-		Sha256  []byte
+		SchemaVersion int // Schema of the manifest, 0 for manifests from before it was versioned
+		Version       string
+		Sha256        []byte // Legacy sha256 checksum, used when Hash is not set
+		Hash          struct {
+			Algo  string // sha256, sha512 or blake2b
+			Value []byte
+		}
+		Compression      string            // Compression format of the full binary, empty means gzip
+		Length           int64             // Size of the uncompressed binary, 0 if unknown
+		CompressedLength int64             // Size of the compressed full binary, 0 if unknown
+		PatchLengths     map[string]int64  // Size of each available patch, keyed by the version it patches from
+		PatchSums        map[string][]byte // sha256 of each patch as stored, keyed like PatchLengths, checked before applying it
+		PatchCompression string            // Compression of the patches, empty means raw
+		DiffAlgo         string            // Algorithm the patches were made with, bsdiff or zstd, empty means bsdiff
+		Uncompressed     bool              // The binary is also published as is, see PlainDownload
+		Signature        []byte            // ed25519 signature of the binary's checksum, verified if PublicKey is set
+		KeyID            string            // Fingerprint of the key that made Signature
+		Rollout          *int              // Percentage of installations offered the version, nil means all
+		Metadata         map[string]string // Additional fields the version was published with, like a release notes URL, see -meta
+		MinFromVersion   string            // Oldest version the update can be installed from, older ones get an *ErrMinFromVersion
 	}
-	OnSuccessfulUpdate func() // Optional function to run after an update has successfully taken place
+	ManifestTimeout    time.Duration                        // Limit on fetching a manifest, signature or patch index, retries included. Defaults to 30s, negative for none
+	DownloadTimeout    time.Duration                        // Limit on downloading a patch or the full binary, retries included. None if 0
+	IsNewer            func(current, available string) bool // Optional function deciding if available is newer than current, for versions that aren't semver
+	OnSuccessfulUpdate func()                               // Optional function to run after an update has successfully taken place
+	RestartAfterUpdate bool                                 // Re-execute the updated binary once an update is installed and OnSuccessfulUpdate has run, see Restart
+	BeforeRestart      func() error                         // Optional function releasing what the new process needs before RestartAfterUpdate restarts, an error cancels the restart
+	Progress           ProgressFunc                         // Optional function reporting the progress of downloading an update
+	OnPhase            func(Phase)                          // Optional function called as an update moves to each Phase
+	PublicKey          ed25519.PublicKey                    // Optional key releases must be signed with, see ParsePublicKey. Unsigned ones fail with a *SignatureError
+	ManifestCachePath  string                               // Optional file keeping the ETag and Last-Modified of the manifest between runs, see ConditionalRequester. Kept in memory if empty
+
+	manifestCache *cachedManifest // validators of the last manifest without update, without ManifestCachePath
 }
 
 func (u *Updater) getExecRelativeDir(dir string) string {
@@ -73,28 +195,6 @@ func (u *Updater) getExecRelativeDir(dir string) string {
 	return path
 }
 
-func canUpdate() (err error) {
-	// get the directory the file exists in
-	path, err := os.Executable()
-	if err != nil {
-		return
-	}
-
-	fileDir := filepath.Dir(path)
-	fileName := filepath.Base(path)
-
-	// attempt to open a file in the file's directory
-	newPath := filepath.Join(fileDir, fmt.Sprintf(".%s.new", fileName))
-	fp, err := os.OpenFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
-	if err != nil {
-		return
-	}
-	fp.Close()
-
-	_ = os.Remove(newPath)
-	return
-}
-
 // BackgroundRun starts the update check and apply cycle.
 func (u *Updater) BackgroundRun() error {
 	if err := os.MkdirAll(u.getExecRelativeDir(u.Dir), 0755); err != nil {
@@ -104,7 +204,11 @@ func (u *Updater) BackgroundRun() error {
 	// check to see if we want to check for updates based on version
 	// and last update time
 	if u.WantUpdate() {
-		if err := canUpdate(); err != nil {
+		path, err := u.target()
+		if err != nil {
+			return err
+		}
+		if err := checkWritable(path); err != nil {
 			// fail
 			return err
 		}
@@ -118,6 +222,51 @@ func (u *Updater) BackgroundRun() error {
 	return nil
 }
 
+// CheckResult is the outcome of an update check made by Poll.
+type CheckResult struct {
+	Version string // Version installed by the check, empty if there was no update
+	Err     error  // Error of the check, the next one is made regardless
+}
+
+// Poll checks for an update right away and then every interval, installing
+// it when the published version differs, until ctx is canceled. It is meant
+// to run in its own goroutine in long-running programs, independently of
+// CheckTime and the cktime state file. Checks never overlap: ticks that
+// pass while a slow check is running are dropped. If report is non-nil it
+// is called with the result of every check. After an update, CurrentVersion
+// is set to the installed version since that is what the executable on disk
+// now holds. Poll returns ctx.Err().
+func (u *Updater) Poll(ctx context.Context, interval time.Duration, report func(CheckResult)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if u.CurrentVersion != "dev" {
+			result := u.check(ctx)
+			if report != nil {
+				report(result)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// check runs Update and reports the version it installed, if any.
+func (u *Updater) check(ctx context.Context) CheckResult {
+	installed, err := u.update(ctx)
+	if err != nil {
+		return CheckResult{Err: err}
+	}
+	if !installed {
+		return CheckResult{}
+	}
+	u.CurrentVersion = u.Info.Version
+	return CheckResult{Version: u.CurrentVersion}
+}
+
 // WantUpdate returns boolean designating if an update is desired. If the app's version
 // is `dev` WantUpdate will return false. If u.ForceCheck is true or cktime is after now
 // WantUpdate will return true.
@@ -155,7 +304,13 @@ func (u *Updater) ClearUpdateState() {
 
 // UpdateAvailable checks if update is available and returns version
 func (u *Updater) UpdateAvailable() (string, error) {
-	path, err := os.Executable()
+	return u.UpdateAvailableContext(context.Background())
+}
+
+// UpdateAvailableContext is UpdateAvailable, aborting the request when ctx
+// is canceled or its deadline expires.
+func (u *Updater) UpdateAvailableContext(ctx context.Context) (string, error) {
+	path, err := u.target()
 	if err != nil {
 		return "", err
 	}
@@ -165,118 +320,300 @@ func (u *Updater) UpdateAvailable() (string, error) {
 	}
 	defer old.Close()
 
-	err = u.fetchInfo()
-	if err != nil {
+	if ok, err := u.checkLatest(ctx); !ok {
 		return "", err
 	}
-	if u.Info.Version == u.CurrentVersion {
-		return "", nil
-	} else {
-		return u.Info.Version, nil
+	return u.Info.Version, nil
+}
+
+// Release describes the version an update would install, see
+// CheckForUpdate.
+type Release struct {
+	Version   string
+	Size      int64 // Bytes an update would download: the patch if there is one, otherwise the full binary; 0 if unknown
+	HasPatch  bool  // Whether the manifest lists a patch from CurrentVersion
+	PatchSize int64 // Size of that patch
+	FullSize  int64 // Size of the compressed full binary, 0 if unknown
+}
+
+// CheckForUpdate fetches the manifest and returns the release an update
+// would install, or nil if there is no newer version or this installation
+// isn't in its staged rollout yet, without downloading or applying
+// anything else. It lets an app ask the user before calling Update. If
+// CurrentVersion is too old to install it directly, the error is an
+// *ErrMinFromVersion naming the version to install first. CheckForUpdate
+// doesn't look for a chain of patches through intermediate versions, so
+// Update may still find one when HasPatch is false.
+func (u *Updater) CheckForUpdate() (*Release, error) {
+	return u.CheckForUpdateContext(context.Background())
+}
+
+// CheckForUpdateContext is CheckForUpdate, aborting the request when ctx
+// is canceled or its deadline expires.
+func (u *Updater) CheckForUpdateContext(ctx context.Context) (*Release, error) {
+	if ok, err := u.checkLatest(ctx); !ok {
+		return nil, err
+	}
+	r := &Release{Version: u.Info.Version, Size: u.Info.CompressedLength, FullSize: u.Info.CompressedLength}
+	if n, ok := u.Info.PatchLengths[u.CurrentVersion]; ok && u.DiffURL != "" {
+		r.HasPatch, r.PatchSize, r.Size = true, n, n
+	}
+	return r, nil
+}
+
+// wantVersion reports whether the published Info.Version should replace
+// CurrentVersion. With AllowDowngrade any other version does. Otherwise it
+// has to be newer, as decided by IsNewer if set, or else by comparing them
+// as semantic versions. Versions that aren't both semantic versions can't
+// be ordered, so any other version is taken to be newer.
+func (u *Updater) wantVersion() bool {
+	switch {
+	case u.Info.Version == u.CurrentVersion:
+		return false
+	case u.AllowDowngrade:
+		return true
+	case u.IsNewer != nil:
+		return u.IsNewer(u.CurrentVersion, u.Info.Version)
+	}
+	if c, ok := CompareVersions(u.Info.Version, u.CurrentVersion); ok {
+		return c > 0
+	}
+	return true
+}
+
+// offered reports whether Info.Version should be installed: it is wanted,
+// see wantVersion, and this installation is in its staged rollout, if any.
+// It returns an *ErrMinFromVersion if CurrentVersion is too old to install
+// it directly.
+func (u *Updater) offered() (bool, error) {
+	if !u.wantVersion() {
+		u.logger().Info("update: no newer version", "version", u.Info.Version, "current", u.CurrentVersion)
+		return false, nil
+	}
+	ok, err := u.inRollout()
+	if err == nil && !ok {
+		u.logger().Info("update: not in the staged rollout yet", "version", u.Info.Version, "rollout", *u.Info.Rollout)
+	}
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err := u.checkMinFrom(); err != nil {
+		return false, err
 	}
+	return true, nil
+}
+
+// checkMinFrom returns an *ErrMinFromVersion if CurrentVersion is older
+// than Info.MinFromVersion, as decided by IsNewer if set, or else by
+// comparing them as semantic versions. Versions that can't be ordered
+// aren't held back.
+func (u *Updater) checkMinFrom() error {
+	from := u.Info.MinFromVersion
+	if from == "" || from == u.CurrentVersion {
+		return nil
+	}
+	older := false
+	if u.IsNewer != nil {
+		older = u.IsNewer(u.CurrentVersion, from)
+	} else if c, ok := CompareVersions(u.CurrentVersion, from); ok {
+		older = c < 0
+	}
+	if !older {
+		return nil
+	}
+	u.logger().Warn("update: running version too old to update directly", "version", u.Info.Version, "current", u.CurrentVersion, "min_from", from)
+	return &ErrMinFromVersion{Current: u.CurrentVersion, Version: u.Info.Version, MinFromVersion: from}
 }
 
 // Update initiates the self update process
 func (u *Updater) Update() error {
-	path, err := os.Executable()
+	return u.UpdateContext(context.Background())
+}
+
+// UpdateContext is Update, aborting every download and the patching as soon
+// as ctx is canceled or its deadline expires, for example to enforce a
+// timeout on the whole update or to stop on shutdown. Downloads are kept in
+// memory, so nothing is left behind; once the new binary is being installed
+// the update is completed regardless of ctx.
+func (u *Updater) UpdateContext(ctx context.Context) error {
+	_, err := u.update(ctx)
+	return err
+}
+
+// update is UpdateContext, also reporting whether it installed a new
+// binary.
+func (u *Updater) update(ctx context.Context) (bool, error) {
+	path, err := u.target()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if resolvedPath, err := filepath.EvalSymlinks(path); err == nil {
-		path = resolvedPath
+	// go fetch latest updates manifest; if we are on the latest version,
+	// or not in its rollout yet, there is nothing to do
+	if ok, err := u.checkLatest(ctx); !ok {
+		return false, err
 	}
+	if err := u.install(ctx, path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	// go fetch latest updates manifest
-	err = u.fetchInfo()
+// UpdateTo installs the given version rather than the latest one, for
+// example to pin an app to a version or to roll back to one that worked,
+// which unlike Rollback doesn't need a backup. It fetches the manifest the
+// generator keeps for every version, so versions published by generators
+// that didn't keep one can't be installed. A version older than
+// CurrentVersion is only installed with AllowDowngrade, in which case the
+// full binary is downloaded, as patches only lead to newer versions.
+// Installing CurrentVersion does nothing. A staged rollout of the version
+// doesn't apply, as it was asked for, but its MinFromVersion does.
+func (u *Updater) UpdateTo(ctx context.Context, version string) error {
+	path, err := u.target()
 	if err != nil {
 		return err
 	}
-
-	// we are on the latest version, nothing to do
-	if u.Info.Version == u.CurrentVersion {
+	if err := u.fetchVersionInfo(ctx, version); err != nil {
+		return err
+	}
+	if version == u.CurrentVersion {
 		return nil
 	}
-
-	old, err := os.Open(path)
-	if err != nil {
+	if !u.wantVersion() {
+		return fmt.Errorf("version %s is older than %s, set AllowDowngrade to install it", version, u.CurrentVersion)
+	}
+	if err := u.checkMinFrom(); err != nil {
 		return err
 	}
-	defer old.Close()
+	return u.install(ctx, path)
+}
 
-	bin, err := u.fetchAndVerifyPatch(old)
-	if err != nil {
-		if err == ErrHashMismatch {
-			log.Println("update: hash mismatch from patched binary")
-		} else {
-			if u.DiffURL != "" {
-				log.Println("update: patching binary,", err)
-			}
+// install fetches the binary described by u.Info, installs it at path and
+// restarts if asked to.
+func (u *Updater) install(ctx context.Context, path string) error {
+	if err := u.preflight(path); err != nil {
+		u.logger().Error("update: can't install", "version", u.Info.Version, "path", path, "error", err)
+		return err
+	}
+	bin := u.cachedBin()
+	if bin != nil {
+		u.logger().Info("update: using the download kept by an earlier attempt", "version", u.Info.Version, "bytes", len(bin))
+	} else {
+		old, err := os.Open(path)
+		if err != nil {
+			return err
 		}
-
-		// if patch failed grab the full new bin
-		bin, err = u.fetchAndVerifyFullBin()
+		bin, err = u.fetchUpdate(ctx, old)
+		// close the old binary before installing because on windows
+		// it can't be renamed if a handle to the file is still open
+		old.Close()
 		if err != nil {
-			if err == ErrHashMismatch {
-				log.Println("update: hash mismatch from full binary")
-			} else {
-				log.Println("update: fetching full binary,", err)
-			}
 			return err
 		}
+		u.cacheBin(bin)
+	}
+	u.logger().Info("update: verified new binary", "version", u.Info.Version, "algo", u.Info.Hash.Algo)
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	// close the old binary before installing because on windows
-	// it can't be renamed if a handle to the file is still open
-	old.Close()
-
-	err, errRecover := fromStream(bytes.NewBuffer(bin))
+	state := &installState{Version: u.Info.Version, Path: path, Algo: u.Info.Hash.Algo, Sum: u.Info.Hash.Value}
+	u.saveInstallState(state)
+	var err, errRecover error
+	if u.TargetPath != "" {
+		err = replaceFile(path, bytes.NewReader(bin))
+	} else {
+		err, errRecover = fromStream(path, bytes.NewReader(bin))
+	}
 	if errRecover != nil {
 		return fmt.Errorf("update and recovery errors: %q %q", err, errRecover)
 	}
 	if err != nil {
+		u.clearInstallState()
 		return err
 	}
+	state.Complete = true
+	u.saveInstallState(state)
+	u.clearCache()
+	u.logger().Info("update: installed", "version", u.Info.Version, "path", path)
 
 	// update was successful, run func if set
 	if u.OnSuccessfulUpdate != nil {
 		u.OnSuccessfulUpdate()
 	}
 
+	if u.RestartAfterUpdate {
+		if u.BeforeRestart != nil {
+			if err := u.BeforeRestart(); err != nil {
+				return fmt.Errorf("update installed, restart canceled: %w", err)
+			}
+		}
+		u.logger().Info("update: restarting", "version", u.Info.Version)
+		return Restart()
+	}
+
 	return nil
 }
 
-func fromStream(updateWith io.Reader) (err error, errRecover error) {
-	updatePath, err := os.Executable()
-	if err != nil {
-		return
+// fetchUpdate returns the new binary, patching old if possible and
+// downloading the full binary otherwise. Either way the result is verified
+// against the manifest.
+func (u *Updater) fetchUpdate(ctx context.Context, old io.Reader) ([]byte, error) {
+	start := time.Now()
+	bin, err := u.fetchAndVerifyPatch(ctx, old)
+	if err == nil {
+		u.logger().Info("update: patched binary", "from", u.CurrentVersion, "version", u.Info.Version, "bytes", len(bin), "duration", time.Since(start))
+		return bin, nil
+	}
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case errors.Is(err, ErrHashMismatch):
+		u.logger().Warn("update: patched binary doesn't match its checksum, downloading the full binary", "version", u.Info.Version, "error", err)
+	case errors.As(err, new(*ErrPatchChecksumMismatch)):
+		u.logger().Warn("update: downloaded patch doesn't match its checksum, downloading the full binary", "version", u.Info.Version, "error", err)
+	case err != errNoPatch:
+		u.logger().Warn("update: patching failed, downloading the full binary", "version", u.Info.Version, "error", err)
+	case u.DiffURL != "" && u.Info.PatchLengths != nil:
+		// no patch from the running version, but maybe a chain of them
+		bin, err = u.fetchAndVerifyPatchChain(ctx, old)
+		switch {
+		case err == nil:
+			u.logger().Info("update: patched binary through intermediate versions", "from", u.CurrentVersion, "version", u.Info.Version, "bytes", len(bin), "duration", time.Since(start))
+			return bin, nil
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err != errNoPatch:
+			u.logger().Warn("update: patching through intermediate versions failed, downloading the full binary", "version", u.Info.Version, "error", err)
+		}
 	}
 
-	var newBytes []byte
-	newBytes, err = ioutil.ReadAll(updateWith)
+	// if patch failed grab the full new bin
+	start = time.Now()
+	bin, err = u.fetchAndVerifyFullBin(ctx)
 	if err != nil {
-		return
+		u.logger().Error("update: downloading the full binary failed", "version", u.Info.Version, "error", err)
+		return nil, err
 	}
+	u.logger().Info("update: downloaded full binary", "version", u.Info.Version, "bytes", len(bin), "duration", time.Since(start))
+	return bin, nil
+}
 
-	// get the directory the executable exists in
-	updateDir := filepath.Dir(updatePath)
-	filename := filepath.Base(updatePath)
-
-	// Copy the contents of of newbinary to a the new executable file
-	newPath := filepath.Join(updateDir, fmt.Sprintf(".%s.new", filename))
-	fp, err := os.OpenFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+// fromStream replaces the executable at updatePath with the content of
+// updateWith. The new binary is written and synced to a temporary file next
+// to it, with the same permissions and, where supported, owner. The running
+// executable is then moved to its backup path and the new one renamed into
+// place; renaming rather than overwriting also works on windows, where a
+// running executable can't be written to or deleted. The backup is kept
+// for Rollback.
+func fromStream(updatePath string, updateWith io.Reader) (err error, errRecover error) {
+	newPath, err := writeNew(updatePath, updateWith)
 	if err != nil {
 		return
 	}
-	defer fp.Close()
-	_, err = io.Copy(fp, bytes.NewReader(newBytes))
-
-	// if we don't call fp.Close(), windows won't let us move the new executable
-	// because the file will still be "in use"
-	fp.Close()
 
 	// this is where we'll move the executable to so that we can swap in the updated replacement
-	oldPath := filepath.Join(updateDir, fmt.Sprintf(".%s.old", filename))
+	oldPath := backupPath(updatePath)
 
 	// delete any existing old exec file - this is necessary on Windows for two reasons:
 	// 1. after a successful update, Windows can't remove the .old file because the process is still running
@@ -286,6 +623,7 @@ func fromStream(updateWith io.Reader) (err error, errRecover error) {
 	// move the existing executable to a new file in the same directory
 	err = os.Rename(updatePath, oldPath)
 	if err != nil {
+		_ = os.Remove(newPath)
 		return
 	}
 
@@ -296,94 +634,647 @@ func fromStream(updateWith io.Reader) (err error, errRecover error) {
 		// copy unsuccessful
 		errRecover = os.Rename(oldPath, updatePath)
 	} else {
-		// copy successful, remove the old binary
-		errRemove := os.Remove(oldPath)
+		// copy successful, keep the old binary for Rollback but hide it on windows
+		_ = hideFile(oldPath)
+	}
 
-		// windows has trouble with removing old binaries, so hide it instead
-		if errRemove != nil {
-			_ = hideFile(oldPath)
+	return
+}
+
+// replaceFile replaces the plain file at updatePath, one that isn't
+// running, with the content of updateWith. The backup for Rollback is a
+// hard link to the current file, or a copy where links aren't supported,
+// so the new file can be renamed over it in one step and the file is never
+// missing.
+func replaceFile(updatePath string, updateWith io.Reader) error {
+	newPath, err := writeNew(updatePath, updateWith)
+	if err != nil {
+		return err
+	}
+	oldPath := backupPath(updatePath)
+	_ = os.Remove(oldPath)
+	if err := os.Link(updatePath, oldPath); err != nil {
+		if err := copyFile(updatePath, oldPath); err != nil {
+			_ = os.Remove(newPath)
+			return err
 		}
 	}
+	if err := os.Rename(newPath, updatePath); err != nil {
+		_ = os.Remove(newPath)
+		return err
+	}
+	return nil
+}
 
-	return
+// copyFile copies the file at src to dst, with its permissions.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// writeNew writes the content of updateWith to .<name>.new next to
+// updatePath and syncs it, with the permissions and, where supported, the
+// owner of updatePath. It returns the path written.
+func writeNew(updatePath string, updateWith io.Reader) (newPath string, err error) {
+	fi, err := os.Stat(updatePath)
+	if err != nil {
+		return
+	}
+
+	// Copy the contents of of newbinary to a the new executable file
+	newPath = newFilePath(updatePath)
+	fp, err := os.OpenFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(fp, updateWith)
+	if err == nil {
+		// the content must be on disk before the rename makes it the executable
+		err = fp.Sync()
+	}
+
+	// if we don't call fp.Close(), windows won't let us move the new executable
+	// because the file will still be "in use"
+	if errClose := fp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		// the mode given to OpenFile is subject to the umask
+		err = os.Chmod(newPath, fi.Mode().Perm())
+	}
+	if err == nil {
+		err = preserveOwner(newPath, fi)
+	}
+	if err != nil {
+		_ = os.Remove(newPath)
+		return "", err
+	}
+	return newPath, nil
+}
+
+// Rollback restores the executable replaced by the last update from its
+// backup, .<name>.old next to it, for example when the new version fails to
+// start properly. Like an update it takes effect on the next start. With
+// TargetPath set, that file is restored instead.
+func (u *Updater) Rollback() error {
+	path, err := u.target()
+	if err != nil {
+		return err
+	}
+	if err := rollback(path); err != nil {
+		return err
+	}
+	u.clearInstallState()
+	u.logger().Info("update: rolled back", "path", path)
+	return nil
+}
+
+func rollback(path string) error {
+	oldPath := backupPath(path)
+	if _, err := os.Stat(oldPath); err != nil {
+		return fmt.Errorf("no previous version to roll back to: %w", err)
+	}
+
+	// the binary being rolled back may be running, so it is moved aside
+	// rather than deleted
+	failedPath := failedPath(path)
+	_ = os.Remove(failedPath)
+	if err := os.Rename(path, failedPath); err != nil {
+		return err
+	}
+	if err := os.Rename(oldPath, path); err != nil {
+		if errRecover := os.Rename(failedPath, path); errRecover != nil {
+			return fmt.Errorf("rollback and recovery errors: %q %q", err, errRecover)
+		}
+		return err
+	}
+	_ = unhideFile(path)
+
+	// windows has trouble with removing running binaries, so hide it instead
+	if os.Remove(failedPath) != nil {
+		_ = hideFile(failedPath)
+	}
+	return nil
+}
+
+// target returns the path of the file an update replaces: TargetPath if
+// set, otherwise the running executable. Symlinks in TargetPath are
+// resolved like those to the executable, so an update replaces the file
+// a link points to rather than the link.
+func (u *Updater) target() (string, error) {
+	if u.TargetPath != "" {
+		if resolvedPath, err := filepath.EvalSymlinks(u.TargetPath); err == nil {
+			return resolvedPath, nil
+		}
+		return u.TargetPath, nil
+	}
+	return executable()
+}
+
+// executable returns the path of the running executable. Symlinks are
+// resolved, so an update replaces their target rather than the link.
+func executable() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolvedPath, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolvedPath
+	}
+	return path, nil
+}
+
+// reset sets *v to its zero value.
+func reset[T any](v *T) {
+	var zero T
+	*v = zero
+}
+
+// backupPath returns where the executable at path is kept by an update.
+func backupPath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.old", filepath.Base(path)))
+}
+
+// layoutPath returns the path below the base URLs of the published file
+// at the slash separated path p of Layout, see filePath.
+func (u *Updater) layoutPath(p string) string {
+	return u.filePath(strings.Split(p, "/")...)
 }
 
-// fetchInfo fetches the update JSON manifest at u.ApiURL/appname/platform.json
+// filePath returns the path of a published file below the base URLs:
+// the command name, the channel if set, then elems, each escaped. With
+// FlatNames it is a single name instead, see generate.AssetName.
+func (u *Updater) filePath(elems ...string) string {
+	if u.FlatNames {
+		if u.Channel != "" {
+			elems = append([]string{u.Channel}, elems...)
+		}
+		return url.QueryEscape(strings.Join(elems, "_"))
+	}
+	p := url.QueryEscape(u.CmdName)
+	if u.Channel != "" {
+		p += "/" + url.QueryEscape(u.Channel)
+	}
+	for _, e := range elems {
+		p += "/" + url.QueryEscape(e)
+	}
+	return p
+}
+
+// fetchInfo fetches the update JSON manifest at u.ApiURL/appname/[channel/]platform.json
 // and updates u.Info.
-func (u *Updater) fetchInfo() error {
-	r, err := u.fetch(u.ApiURL + url.QueryEscape(u.CmdName) + "/" + url.QueryEscape(plat) + ".json")
+func (u *Updater) fetchInfo(ctx context.Context) error {
+	return u.fetchManifest(ctx, u.layoutPath(u.Layout.ManifestPath(plat)))
+}
+
+// fetchVersionInfo fetches the manifest the generator keeps for version at
+// u.ApiURL/appname/[channel/]version/platform.json and updates u.Info.
+func (u *Updater) fetchVersionInfo(ctx context.Context, version string) error {
+	if err := u.fetchManifest(ctx, u.layoutPath(u.Layout.VersionManifestPath(version, plat))); err != nil {
+		return err
+	}
+	if u.Info.Version != version {
+		return fmt.Errorf("manifest of version %s is for version %s", version, u.Info.Version)
+	}
+	return nil
+}
+
+// fetchManifest fetches the manifest at path below u.ApiURL, checking its
+// signature at path.sig if PublicKey is set, and updates u.Info.
+func (u *Updater) fetchManifest(ctx context.Context, path string) error {
+	start := time.Now()
+	u.logger().Info("update: checking for update", "path", path, "current", u.CurrentVersion)
+	b, err := u.downloadManifest(ctx, u.ApiURL, path)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
-	err = json.NewDecoder(r).Decode(&u.Info)
+	return u.loadManifest(ctx, path, b, start)
+}
+
+// loadManifest updates u.Info from the manifest b fetched from path, since
+// start, checking its signature at path.sig if PublicKey is set.
+func (u *Updater) loadManifest(ctx context.Context, path string, b []byte, start time.Time) error {
+	if u.PublicKey != nil {
+		if err := u.verifyManifest(ctx, path+".sig", b); err != nil {
+			return err
+		}
+	}
+	// start over, so nothing of a previous manifest lingers
+	reset(&u.Info)
+	if err := json.Unmarshal(b, &u.Info); err != nil {
+		return err
+	}
+	if u.Info.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("%w: %s uses schema %d, this client reads up to %d", ErrSchemaVersion, path, u.Info.SchemaVersion, SchemaVersion)
+	}
+	// manifests from older generators only carry Sha256
+	if u.Info.Hash.Algo == "" {
+		u.Info.Hash.Algo = "sha256"
+		u.Info.Hash.Value = u.Info.Sha256
+	}
+	h, err := newHash(u.Info.Hash.Algo)
 	if err != nil {
 		return err
 	}
-	if len(u.Info.Sha256) != sha256.Size {
+	if len(u.Info.Hash.Value) != h.Size() {
 		return errors.New("bad cmd hash in info")
 	}
+	if u.PublicKey != nil {
+		if err := u.verifyInfo(); err != nil {
+			return err
+		}
+	}
+	u.logger().Info("update: fetched manifest", "path", path, "version", u.Info.Version, "duration", time.Since(start))
 	return nil
 }
 
-func (u *Updater) fetchAndVerifyPatch(old io.Reader) ([]byte, error) {
-	bin, err := u.fetchAndApplyPatch(old)
+func (u *Updater) fetchAndVerifyPatch(ctx context.Context, old io.Reader) ([]byte, error) {
+	bin, err := u.fetchAndApplyPatch(ctx, old)
 	if err != nil {
 		return nil, err
 	}
-	if !verifySha(bin, u.Info.Sha256) {
-		return nil, ErrHashMismatch
+	u.phase(PhaseVerifying)
+	if err := verifyHash(bin, u.Info.Hash.Algo, u.Info.Hash.Value); err != nil {
+		return nil, err
 	}
 	return bin, nil
 }
 
-func (u *Updater) fetchAndApplyPatch(old io.Reader) ([]byte, error) {
-	r, err := u.fetch(u.DiffURL + url.QueryEscape(u.CmdName) + "/" + url.QueryEscape(u.CurrentVersion) + "/" + url.QueryEscape(u.Info.Version) + "/" + url.QueryEscape(plat))
+func (u *Updater) fetchAndApplyPatch(ctx context.Context, old io.Reader) ([]byte, error) {
+	// manifests listing their patches tell us when there is none to fetch
+	if _, ok := u.Info.PatchLengths[u.CurrentVersion]; u.DiffURL == "" || (u.Info.PatchLengths != nil && !ok) {
+		return nil, errNoPatch
+	}
+	compression := u.Info.PatchCompression
+	if compression == "" {
+		compression = "none"
+	}
+	u.phase(PhaseDownloading)
+	report := u.reporter(0, u.Info.PatchLengths[u.CurrentVersion])
+	patch, err := u.downloadPatch(ctx, u.CurrentVersion, u.Info.Version, compression, u.Info.PatchSums[u.CurrentVersion], report)
 	if err != nil {
 		return nil, err
 	}
-	defer r.Close()
-	var buf bytes.Buffer
-	err = binarydist.Patch(old, &buf, r)
-	return buf.Bytes(), err
+	u.phase(PhaseApplying)
+	return applyPatch(ctx, u.Info.DiffAlgo, old, patch)
+}
+
+// downloadPatch downloads the patch from old to version, stored with
+// compression, and returns it decompressed. Unless sum is nil, the patch as
+// stored must have it as sha256 checksum; a mismatch is an
+// *ErrPatchChecksumMismatch, retried like a network error.
+func (u *Updater) downloadPatch(ctx context.Context, old, version, compression string, sum []byte, report func(int64)) ([]byte, error) {
+	ext, err := compressionExt(compression)
+	if err != nil {
+		return nil, err
+	}
+	path := u.layoutPath(u.Layout.PatchPath(old, version, plat, ext))
+	if sum == nil {
+		return u.downloadBinary(ctx, u.DiffURL, path, compression, report)
+	}
+	stored, err := withTimeout(ctx, u.DownloadTimeout, "DownloadTimeout", func(ctx context.Context) ([]byte, error) {
+		var b []byte
+		err := u.retry(ctx, path, func() error {
+			return u.fromMirrors(ctx, u.DiffURL, path, func(url string) (err error) {
+				if b, err = u.downloadOnce(ctx, url, "none", report); err != nil {
+					return err
+				}
+				if got := sha256.Sum256(b); !bytes.Equal(got[:], sum) {
+					return &ErrPatchChecksumMismatch{From: old, Version: version, Expected: sum, Got: got[:]}
+				}
+				return nil
+			})
+		})
+		return b, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decompressAll(stored, compression)
 }
 
-func (u *Updater) fetchAndVerifyFullBin() ([]byte, error) {
-	bin, err := u.fetchBin()
+func (u *Updater) fetchAndVerifyFullBin(ctx context.Context) ([]byte, error) {
+	bin, err := u.fetchBin(ctx)
 	if err != nil {
 		return nil, err
 	}
-	verified := verifySha(bin, u.Info.Sha256)
-	if !verified {
-		return nil, ErrHashMismatch
+	u.phase(PhaseVerifying)
+	if err := verifyHash(bin, u.Info.Hash.Algo, u.Info.Hash.Value); err != nil {
+		return nil, err
 	}
 	return bin, nil
 }
 
-func (u *Updater) fetchBin() ([]byte, error) {
-	r, err := u.fetch(u.BinURL + url.QueryEscape(u.CmdName) + "/" + url.QueryEscape(u.Info.Version) + "/" + url.QueryEscape(plat) + ".gz")
+func (u *Updater) fetchBin(ctx context.Context) ([]byte, error) {
+	compression := u.Info.Compression
+	switch {
+	case u.PlainDownload && u.Info.Uncompressed:
+		compression = "none"
+	case compression == "":
+		compression = "gzip"
+	}
+	ext, err := compressionExt(compression)
 	if err != nil {
 		return nil, err
 	}
-	defer r.Close()
-	buf := new(bytes.Buffer)
-	gz, err := gzip.NewReader(r)
+
+	total := u.Info.CompressedLength
+	if compression == "none" {
+		total = u.Info.Length
+	}
+	u.phase(PhaseDownloading)
+	report := u.reporter(0, total)
+
+	binPath := u.layoutPath(u.Layout.BinaryPath(u.Info.Version, plat, ext))
+	if rr, ok := u.requester().(RangeRequester); ok {
+		// resuming needs a place to keep the partial download
+		dir := u.getExecRelativeDir(u.Dir)
+		if err := os.MkdirAll(dir, 0755); err == nil {
+			partPath := filepath.Join(dir, fmt.Sprintf(".%s-%s%s.part", plat, u.Info.Version, ext))
+			return withTimeout(ctx, u.DownloadTimeout, "DownloadTimeout", func(ctx context.Context) ([]byte, error) {
+				return u.downloadResumable(ctx, rr, u.BinURL, binPath, partPath, compression, report)
+			})
+		}
+	}
+	return u.downloadBinary(ctx, u.BinURL, binPath, compression, report)
+}
+
+// download fetches path from base, or one of the Mirrors, and returns its
+// content decompressed with compression, see compressionExt. Transient
+// failures are retried up to MaxAttempts times in total, waiting
+// RetryDelay, doubled after every attempt and jittered, in between. A retry
+// that couldn't start before the deadline of ctx is given up. The bytes
+// received are passed to report, unless nil.
+func (u *Updater) download(ctx context.Context, base, path, compression string, report func(int64)) ([]byte, error) {
+	var b []byte
+	err := u.retry(ctx, path, func() error {
+		return u.fromMirrors(ctx, base, path, func(url string) (err error) {
+			b, err = u.downloadOnce(ctx, url, compression, report)
+			return err
+		})
+	})
+	return b, err
+}
+
+// downloadManifest is download for a manifest or another small file,
+// limited to ManifestTimeout.
+func (u *Updater) downloadManifest(ctx context.Context, base, path string) ([]byte, error) {
+	return withTimeout(ctx, u.manifestTimeout(), "ManifestTimeout", func(ctx context.Context) ([]byte, error) {
+		return u.download(ctx, base, path, "none", nil)
+	})
+}
+
+// manifestTimeout returns ManifestTimeout, or its default if unset.
+func (u *Updater) manifestTimeout() time.Duration {
+	if u.ManifestTimeout == 0 {
+		return defaultManifestTimeout
+	}
+	return u.ManifestTimeout
+}
+
+// downloadBinary is download for a patch or full binary, limited to
+// DownloadTimeout.
+func (u *Updater) downloadBinary(ctx context.Context, base, path, compression string, report func(int64)) ([]byte, error) {
+	return withTimeout(ctx, u.DownloadTimeout, "DownloadTimeout", func(ctx context.Context) ([]byte, error) {
+		return u.download(ctx, base, path, compression, report)
+	})
+}
+
+// withTimeout calls fetch with ctx limited to d, unless d isn't positive.
+// If the limit is what stopped fetch, the error names it.
+func withTimeout(ctx context.Context, d time.Duration, name string, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
+	if d <= 0 {
+		return fetch(ctx)
+	}
+	limited, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	b, err := fetch(limited)
+	if err != nil && ctx.Err() == nil && limited.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%s of %s exceeded: %w", name, d, err)
+	}
+	return b, err
+}
+
+// fromMirrors calls fetch with the URL of path on base and then on each of
+// the Mirrors, shuffled if ShuffleMirrors is set, until it succeeds or
+// fails with an error that isn't retryable. The base URL that succeeded
+// is recorded in Mirror.
+func (u *Updater) fromMirrors(ctx context.Context, base, path string, fetch func(url string) error) error {
+	bases := append([]string{base}, u.Mirrors...)
+	if u.ShuffleMirrors {
+		rand.Shuffle(len(bases), func(i, j int) {
+			bases[i], bases[j] = bases[j], bases[i]
+		})
+	}
+	var err error
+	for i, b := range bases {
+		if err = fetch(b + path); err == nil {
+			if b != base {
+				u.logger().Info("update: fetched from mirror", "path", path, "mirror", b)
+			}
+			u.Mirror = b
+			return nil
+		}
+		if ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		if i < len(bases)-1 {
+			u.logger().Warn("update: fetching failed, trying the next mirror", "path", path, "url", b, "error", err)
+		}
+	}
+	return err
+}
+
+// retry calls fetch, which downloads path, until it succeeds or fails with
+// an error that isn't retryable, see download.
+func (u *Updater) retry(ctx context.Context, path string, fetch func() error) error {
+	delay := u.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		err := fetch()
+		if err == nil || attempt >= u.MaxAttempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		// wait between delay/2 and delay, so clients don't retry in lockstep
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		u.logger().Warn("update: fetching failed, retrying", "path", path, "attempt", attempt, "wait", wait.Round(time.Millisecond), "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// downloadResumable is download for a RangeRequester. The compressed file
+// is saved to partPath as it arrives, so an interrupted download continues
+// where it stopped, whether retried right away or by a later update. The
+// part file is removed once complete; the checksum of the result is
+// verified by the caller like for any download.
+func (u *Updater) downloadResumable(ctx context.Context, rr RangeRequester, base, path, partPath, compression string, report func(int64)) ([]byte, error) {
+	err := u.retry(ctx, path, func() error {
+		return u.fromMirrors(ctx, base, path, func(url string) error {
+			return u.resumeDownload(ctx, rr, url, partPath, report)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	// a broken download mustn't be resumed, so it is dropped either way
+	defer os.Remove(partPath)
+
+	f, err := os.Open(partPath)
 	if err != nil {
 		return nil, err
 	}
-	if _, err = io.Copy(buf, gz); err != nil {
+	defer f.Close()
+	dr, err := decompress(f, compression)
+	if err != nil {
 		return nil, err
 	}
+	defer dr.Close()
+	return io.ReadAll(dr)
+}
+
+// resumeDownload appends the rest of url to the file at partPath, passing
+// the size of the file as it grows to report, unless nil.
+func (u *Updater) resumeDownload(ctx context.Context, rr RangeRequester, url, partPath string, report func(int64)) error {
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	body, partial, err := rr.FetchRange(ctx, url, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if !partial && offset > 0 {
+		// the server sent the whole file, so start over
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		offset = 0
+	}
+	if _, err := io.Copy(f, &ctxReader{ctx, u.throttle(ctx, withProgress(body, offset, report))}); err != nil {
+		return err
+	}
+	return f.Close()
+}
 
+func (u *Updater) downloadOnce(ctx context.Context, url, compression string, report func(int64)) ([]byte, error) {
+	r, err := u.fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	dr, err := decompress(u.throttle(ctx, withProgress(r, 0, report)), compression)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	buf := new(bytes.Buffer)
+	if _, err = io.Copy(buf, dr); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
-func (u *Updater) fetch(url string) (io.ReadCloser, error) {
-	if u.Requester == nil {
-		return defaultHTTPRequester.Fetch(url)
+// retryable reports whether err, returned by a download, may be transient:
+// a network error, a truncated response, a server error or a patch not
+// matching its checksum. A missing file or any other client error is not.
+func retryable(err error) bool {
+	var patchErr *ErrPatchChecksumMismatch
+	if errors.As(err, &patchErr) {
+		return true
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= 500 || status.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// compressionExt returns the file extension the generator uses for
+// compression, "gzip", "zstd" or "none".
+func compressionExt(compression string) (string, error) {
+	switch compression {
+	case "gzip":
+		return ".gz", nil
+	case "zstd":
+		return ".zst", nil
+	case "none":
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported compression %q in info", compression)
+}
+
+// decompress returns a reader of r decompressed with compression, see
+// compressionExt.
+func decompress(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// fetch requests url with u.Requester. The body stops reading once ctx is
+// done, even if the Requester doesn't implement ContextRequester.
+func (u *Updater) fetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	requester := u.requester()
 
-	readCloser, err := u.Requester.Fetch(url)
+	var (
+		readCloser io.ReadCloser
+		err        error
+	)
+	if cr, ok := requester.(ContextRequester); ok {
+		readCloser, err = cr.FetchContext(ctx, url)
+	} else {
+		readCloser, err = requester.Fetch(url)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -392,7 +1283,31 @@ func (u *Updater) fetch(url string) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("Fetch was expected to return non-nil ReadCloser")
 	}
 
-	return readCloser, nil
+	return struct {
+		io.Reader
+		io.Closer
+	}{&ctxReader{ctx, readCloser}, readCloser}, nil
+}
+
+// requester returns u.Requester, or the default HTTPRequester if unset.
+func (u *Updater) requester() Requester {
+	if u.Requester != nil {
+		return u.Requester
+	}
+	return &defaultHTTPRequester
+}
+
+// ctxReader fails reading with the error of ctx once it is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
 }
 
 func readTime(path string) time.Time {
@@ -410,10 +1325,31 @@ func readTime(path string) time.Time {
 	return t
 }
 
-func verifySha(bin []byte, sha []byte) bool {
-	h := sha256.New()
+// newHash returns a new hash.Hash for algo (sha256, sha512 or blake2b).
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "blake2b":
+		return blake2b.New512(nil)
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm %q in info", algo)
+}
+
+// verifyHash checks bin against sum, computed with algo, returning an
+// *ErrChecksumMismatch if it doesn't match.
+func verifyHash(bin []byte, algo string, sum []byte) error {
+	h, err := newHash(algo)
+	if err != nil {
+		return err
+	}
 	h.Write(bin)
-	return bytes.Equal(h.Sum(nil), sha)
+	if got := h.Sum(nil); !bytes.Equal(got, sum) {
+		return &ErrChecksumMismatch{Algo: algo, Expected: sum, Got: got}
+	}
+	return nil
 }
 
 func writeTime(path string, t time.Time) bool {
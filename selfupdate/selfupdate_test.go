@@ -2,9 +2,29 @@ package selfupdate
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/kr/binarydist"
 )
 
 func TestUpdaterFetchMustReturnNonNilReaderCloser(t *testing.T) {
@@ -118,6 +138,343 @@ func TestUpdateAvailable(t *testing.T) {
 	equals(t, "2023-07-09-66c6c12", version)
 }
 
+func TestFetchBinZstd(t *testing.T) {
+	var compressed bytes.Buffer
+	zw, _ := zstd.NewWriter(&compressed)
+	zw.Write([]byte("new binary"))
+	zw.Close()
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdownmain.com/myapp/1.3/"+plat+".zst", url)
+			return newTestReaderCloser(compressed.String()), nil
+		})
+	updater := createUpdater(mr)
+	updater.Info.Version = "1.3"
+	updater.Info.Compression = "zstd"
+
+	bin, err := updater.fetchBin(context.Background())
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "new binary", string(bin))
+}
+
+func TestFetchBinPlainDownload(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdownmain.com/myapp/1.3/"+plat, url)
+			return newTestReaderCloser("new binary"), nil
+		})
+	updater := createUpdater(mr)
+	updater.PlainDownload = true
+	updater.Info.Version = "1.3"
+	updater.Info.Compression = "zstd"
+	updater.Info.Uncompressed = true
+
+	bin, err := updater.fetchBin(context.Background())
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "new binary", string(bin))
+}
+
+func TestFetchAndApplyPatchGzip(t *testing.T) {
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader([]byte("old binary")), bytes.NewReader([]byte("new binary")), &patch); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write(patch.Bytes())
+	gw.Close()
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdomain.com/myapp/1.2/1.3/"+plat+".gz", url)
+			return newTestReaderCloser(compressed.String()), nil
+		})
+	updater := createUpdater(mr)
+	updater.Info.Version = "1.3"
+	updater.Info.PatchCompression = "gzip"
+
+	bin, err := updater.fetchAndApplyPatch(context.Background(), bytes.NewReader([]byte("old binary")))
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "new binary", string(bin))
+}
+
+func TestFetchUpdateFallsBackToFullBin(t *testing.T) {
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader([]byte("old binary")), bytes.NewReader([]byte("bad binary")), &patch); err != nil {
+		t.Fatal(err)
+	}
+	var full bytes.Buffer
+	gw := gzip.NewWriter(&full)
+	gw.Write([]byte("new binary"))
+	gw.Close()
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdomain.com/myapp/1.2/1.3/"+plat, url)
+			return newTestReaderCloser(patch.String()), nil
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdownmain.com/myapp/1.3/"+plat+".gz", url)
+			return newTestReaderCloser(full.String()), nil
+		})
+	updater := createUpdater(mr)
+	updater.Info.Version = "1.3"
+	sum := sha256.Sum256([]byte("new binary"))
+	updater.Info.Hash.Algo = "sha256"
+	updater.Info.Hash.Value = sum[:]
+
+	bin, err := updater.fetchUpdate(context.Background(), bytes.NewReader([]byte("old binary")))
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "new binary", string(bin))
+	equals(t, 2, mr.currentIndex)
+}
+
+func TestFetchUpdateSkipsUnlistedPatch(t *testing.T) {
+	var full bytes.Buffer
+	gw := gzip.NewWriter(&full)
+	gw.Write([]byte("new binary"))
+	gw.Close()
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			// looking for a chain of patches
+			equals(t, "http://updates.yourdomain.com/myapp/1.3/"+plat+".patches.json", url)
+			return nil, &StatusError{URL: url, StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdownmain.com/myapp/1.3/"+plat+".gz", url)
+			return newTestReaderCloser(full.String()), nil
+		})
+	updater := createUpdater(mr)
+	updater.Info.Version = "1.3"
+	updater.Info.PatchLengths = map[string]int64{"1.1": 100}
+	sum := sha256.Sum256([]byte("new binary"))
+	updater.Info.Hash.Algo = "sha256"
+	updater.Info.Hash.Value = sum[:]
+
+	bin, err := updater.fetchUpdate(context.Background(), bytes.NewReader([]byte("old binary")))
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "new binary", string(bin))
+}
+
+func TestFetchUpdateProgress(t *testing.T) {
+	var patch bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader([]byte("old binary")), bytes.NewReader([]byte("new binary")), &patch); err != nil {
+		t.Fatal(err)
+	}
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(patch.String()), nil
+		})
+	updater := createUpdater(mr)
+	updater.Info.Version = "1.3"
+	updater.Info.PatchLengths = map[string]int64{"1.2": int64(patch.Len())}
+	sum := sha256.Sum256([]byte("new binary"))
+	updater.Info.Hash.Algo = "sha256"
+	updater.Info.Hash.Value = sum[:]
+	var downloaded, total int64
+	var phases []Phase
+	updater.Progress = func(d, t int64) { downloaded, total = d, t }
+	updater.OnPhase = func(p Phase) { phases = append(phases, p) }
+
+	bin, err := updater.fetchUpdate(context.Background(), bytes.NewReader([]byte("old binary")))
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "new binary", string(bin))
+	equals(t, int64(patch.Len()), downloaded)
+	equals(t, int64(patch.Len()), total)
+	equals(t, "[downloading applying patch verifying]", fmt.Sprint(phases))
+}
+
+func TestFetchUpdatePatchChecksum(t *testing.T) {
+	var patch, full bytes.Buffer
+	if err := binarydist.Diff(bytes.NewReader([]byte("old binary")), bytes.NewReader([]byte("new binary")), &patch); err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(&full)
+	gw.Write([]byte("new binary"))
+	gw.Close()
+	truncated := func(url string) (io.ReadCloser, error) {
+		equals(t, "http://updates.yourdomain.com/myapp/1.2/1.3/"+plat, url)
+		return newTestReaderCloser(patch.String()[:patch.Len()/2]), nil
+	}
+
+	mr := &mockRequester{}
+	mr.handleRequest(truncated)
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(patch.String()), nil
+		})
+	updater := createUpdater(mr)
+	updater.MaxAttempts = 2
+	updater.RetryDelay = time.Millisecond
+	updater.Info.Version = "1.3"
+	patchSum := sha256.Sum256(patch.Bytes())
+	updater.Info.PatchLengths = map[string]int64{"1.2": int64(patch.Len())}
+	updater.Info.PatchSums = map[string][]byte{"1.2": patchSum[:]}
+	sum := sha256.Sum256([]byte("new binary"))
+	updater.Info.Hash.Algo = "sha256"
+	updater.Info.Hash.Value = sum[:]
+
+	// a corrupt patch is downloaded again rather than applied
+	bin, err := updater.fetchAndApplyPatch(context.Background(), bytes.NewReader([]byte("old binary")))
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "new binary", string(bin))
+	equals(t, 2, mr.currentIndex)
+
+	// and once out of attempts, the full binary is downloaded
+	mr = &mockRequester{}
+	mr.handleRequest(truncated)
+	mr.handleRequest(truncated)
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdownmain.com/myapp/1.3/"+plat+".gz", url)
+			return newTestReaderCloser(full.String()), nil
+		})
+	updater.Requester = mr
+	_, err = updater.fetchAndApplyPatch(context.Background(), bytes.NewReader([]byte("old binary")))
+	var mismatch *ErrPatchChecksumMismatch
+	if !errors.As(err, &mismatch) || errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("Expected an *ErrPatchChecksumMismatch, got %#v", err)
+	}
+	equals(t, "1.2", mismatch.From)
+	mr.currentIndex = 0
+	bin, err = updater.fetchUpdate(context.Background(), bytes.NewReader([]byte("old binary")))
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "new binary", string(bin))
+	equals(t, 3, mr.currentIndex)
+}
+
+func TestFetchAndVerifyFullBinChecksumMismatch(t *testing.T) {
+	var full bytes.Buffer
+	gw := gzip.NewWriter(&full)
+	gw.Write([]byte("tampered binary"))
+	gw.Close()
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(full.String()), nil
+		})
+	updater := createUpdater(mr)
+	updater.Info.Version = "1.3"
+	sum := sha256.Sum256([]byte("new binary"))
+	updater.Info.Hash.Algo = "sha256"
+	updater.Info.Hash.Value = sum[:]
+
+	_, err := updater.fetchAndVerifyFullBin(context.Background())
+	var mismatch *ErrChecksumMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected an *ErrChecksumMismatch, got %#v", err)
+	}
+	got := sha256.Sum256([]byte("tampered binary"))
+	equals(t, "sha256", mismatch.Algo)
+	equals(t, true, bytes.Equal(sum[:], mismatch.Expected))
+	equals(t, true, bytes.Equal(got[:], mismatch.Got))
+	equals(t, true, errors.Is(err, ErrHashMismatch))
+}
+
+func TestFetchInfoHashAlgo(t *testing.T) {
+	sum := sha512.Sum512([]byte("new binary"))
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"Version": "1.3",
+		"Hash":    map[string]interface{}{"Algo": "sha512", "Value": sum[:]},
+	})
+
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(string(manifest)), nil
+		})
+	updater := createUpdater(mr)
+
+	if err := updater.fetchInfo(context.Background()); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "sha512", updater.Info.Hash.Algo)
+	equals(t, nil, verifyHash([]byte("new binary"), updater.Info.Hash.Algo, updater.Info.Hash.Value))
+	equals(t, true, verifyHash([]byte("other binary"), updater.Info.Hash.Algo, updater.Info.Hash.Value) != nil)
+}
+
+func TestFetchInfoMetadata(t *testing.T) {
+	sum := sha256.Sum256([]byte("new binary"))
+	manifests := []string{}
+	for _, meta := range []interface{}{map[string]string{"notes": "https://example.com/1.3"}, nil} {
+		b, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "Metadata": meta})
+		manifests = append(manifests, string(b))
+	}
+
+	mr := &mockRequester{}
+	for _, m := range manifests {
+		m := m
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(m), nil
+			})
+	}
+	updater := createUpdater(mr)
+
+	if err := updater.fetchInfo(context.Background()); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "https://example.com/1.3", updater.Info.Metadata["notes"])
+
+	// the metadata of a previous manifest doesn't linger
+	if err := updater.fetchInfo(context.Background()); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, 0, len(updater.Info.Metadata))
+}
+
+func TestFetchInfoSchemaVersion(t *testing.T) {
+	sum := sha256.Sum256([]byte("new binary"))
+	for schema, supported := range map[int]bool{0: true, SchemaVersion: true, SchemaVersion + 1: false} {
+		manifest, _ := json.Marshal(map[string]interface{}{
+			"SchemaVersion": schema,
+			"Version":       "1.3",
+			"Sha256":        sum[:],
+			"SomeNewField":  "ignored",
+		})
+		mr := &mockRequester{}
+		mr.handleRequest(
+			func(url string) (io.ReadCloser, error) {
+				return newTestReaderCloser(string(manifest)), nil
+			})
+		updater := createUpdater(mr)
+
+		err := updater.fetchInfo(context.Background())
+		if supported {
+			equals(t, nil, err)
+		} else {
+			equals(t, true, errors.Is(err, ErrSchemaVersion))
+		}
+	}
+}
+
 func createUpdater(mr *mockRequester) *Updater {
 	return &Updater{
 		CurrentVersion: "1.2",
@@ -164,3 +521,980 @@ func (trc *testReadCloser) Read(p []byte) (n int, err error) {
 func (trc *testReadCloser) Close() error {
 	return nil
 }
+
+func TestNormalizePlatform(t *testing.T) {
+	for platform, want := range map[string]string{
+		"linux-amd64":        "linux-amd64",
+		"linux-x86_64":       "linux-amd64",
+		"darwin-aarch64":     "darwin-arm64",
+		"windows-X64":        "windows-amd64",
+		"linux-i686":         "linux-386",
+		"linux-armv7l":       "linux-arm",
+		"myapp-linux-x86_64": "myapp-linux-amd64",
+		"linux-mips":         "linux-mips",
+		"x86_64":             "x86_64",
+	} {
+		if got := NormalizePlatform(platform); got != want {
+			t.Errorf("NormalizePlatform(%q) = %q, want %q", platform, got, want)
+		}
+	}
+}
+
+func TestFromStreamKeepsBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "myapp")
+	if err := os.WriteFile(path, []byte("old binary"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	err, errRecover := fromStream(path, bytes.NewReader([]byte("new binary")))
+	if err != nil || errRecover != nil {
+		t.Fatalf("Error occurred: %#v %#v", err, errRecover)
+	}
+	b, _ := os.ReadFile(path)
+	equals(t, "new binary", string(b))
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("Expected the permissions to be kept, got %v %v", fi.Mode(), err)
+	}
+	b, _ = os.ReadFile(backupPath(path))
+	equals(t, "old binary", string(b))
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), ".myapp.new")); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be gone, got %v", err)
+	}
+
+	if err := rollback(path); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ = os.ReadFile(path)
+	equals(t, "old binary", string(b))
+	if err := rollback(path); err == nil {
+		t.Errorf("Expected an error rolling back without a backup")
+	}
+}
+
+func TestUpdateTargetPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte("old data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("new data"))
+	w.Close()
+	sum := sha256.Sum256([]byte("new data"))
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+
+	updater := createUpdater(nil)
+	updater.Requester = filesRequester{
+		"http://updates.yourdomain.com/myapp/" + plat + ".json":     manifest,
+		"http://updates.yourdownmain.com/myapp/1.3/" + plat + ".gz": gz.Bytes(),
+	}
+	updater.TargetPath = path
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ := os.ReadFile(path)
+	equals(t, "new data", string(b))
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("Expected the permissions to be kept, got %v %v", fi.Mode(), err)
+	}
+	b, _ = os.ReadFile(backupPath(path))
+	equals(t, "old data", string(b))
+
+	if err := updater.Rollback(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ = os.ReadFile(path)
+	equals(t, "old data", string(b))
+
+	// through a symlink, the file it points to is updated
+	link := filepath.Join(t.TempDir(), "data-link.bin")
+	if err := os.Symlink(path, link); err != nil {
+		t.Skipf("Can't create symlinks: %s", err)
+	}
+	updater.TargetPath = link
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ = os.ReadFile(path)
+	equals(t, "new data", string(b))
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected the symlink to be kept, got %v %v", fi, err)
+	}
+	if _, err := os.Stat(backupPath(path)); err != nil {
+		t.Errorf("Expected the backup next to the file linked to: %s", err)
+	}
+}
+
+func TestUpdateCacheDownloads(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(path, []byte("old data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a directory in the way of the temporary file makes installing fail
+	if err := os.MkdirAll(filepath.Join(dir, ".data.bin.new", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("new data"))
+	w.Close()
+	sum := sha256.Sum256([]byte("new data"))
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:]})
+	files := filesRequester{
+		"http://updates.yourdomain.com/myapp/" + plat + ".json":     manifest,
+		"http://updates.yourdownmain.com/myapp/1.3/" + plat + ".gz": gz.Bytes(),
+	}
+
+	updater := createUpdater(nil)
+	updater.Requester = files
+	updater.Dir = "update-cache-test/"
+	t.Cleanup(func() { os.RemoveAll(updater.getExecRelativeDir(updater.Dir)) })
+	updater.TargetPath = path
+	updater.CacheDownloads = true
+	if err := updater.Update(); err == nil {
+		t.Fatal("Expected installing to fail")
+	}
+	if _, err := os.Stat(updater.cachePath()); err != nil {
+		t.Fatalf("Expected the verified binary to be kept: %s", err)
+	}
+
+	// the retry installs the kept binary without downloading it
+	os.RemoveAll(filepath.Join(dir, ".data.bin.new"))
+	delete(files, "http://updates.yourdownmain.com/myapp/1.3/"+plat+".gz")
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ := os.ReadFile(path)
+	equals(t, "new data", string(b))
+	if _, err := os.Stat(updater.cachePath()); !os.IsNotExist(err) {
+		t.Errorf("Expected the kept binary to be removed once installed, got %v", err)
+	}
+}
+
+func TestUpdateLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte("old data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("new data"))
+	w.Close()
+	sum := sha256.Sum256([]byte("new data"))
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "CompressedLength": gz.Len()})
+
+	var buf bytes.Buffer
+	updater := createUpdater(nil)
+	updater.Requester = filesRequester{
+		"http://updates.yourdomain.com/myapp/" + plat + ".json":     manifest,
+		"http://updates.yourdownmain.com/myapp/1.3/" + plat + ".gz": gz.Bytes(),
+	}
+	updater.TargetPath = path
+	updater.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	if err := updater.Rollback(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	for _, want := range []string{
+		`msg="update: checking for update"`,
+		`msg="update: fetched manifest"`,
+		`msg="update: download progress" downloaded=` + strconv.Itoa(gz.Len()) + " total=" + strconv.Itoa(gz.Len()) + " percent=100",
+		`msg="update: downloaded full binary" version=1.3 bytes=8`,
+		`msg="update: verified new binary" version=1.3 algo=sha256`,
+		`msg="update: installed" version=1.3 path=` + path,
+		`msg="update: rolled back" path=` + path,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected the log to contain %s, got:\n%s", want, buf.String())
+		}
+	}
+
+	// a check finding nothing newer says so
+	buf.Reset()
+	updater.CurrentVersion = "1.3"
+	if err := updater.Update(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	if !strings.Contains(buf.String(), `msg="update: no newer version" version=1.3 current=1.3`) {
+		t.Errorf("Expected the log to say there's no newer version, got:\n%s", buf.String())
+	}
+}
+
+func TestUpdatePreflight(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(path, []byte("old data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("new data"))
+	manifest, _ := json.Marshal(map[string]interface{}{"Version": "1.3", "Sha256": sum[:], "Length": int64(1) << 62})
+	// the binary isn't published: a download would fail differently
+	updater := createUpdater(nil)
+	updater.Requester = filesRequester{"http://updates.yourdomain.com/myapp/" + plat + ".json": manifest}
+	updater.TargetPath = path
+
+	var errSpace *ErrInsufficientSpace
+	if err := updater.Update(); !errors.As(err, &errSpace) {
+		t.Fatalf("Expected an *ErrInsufficientSpace, got %v", err)
+	}
+	if errSpace.Dir != dir || errSpace.Need <= errSpace.Free {
+		t.Errorf("Unexpected %#v", errSpace)
+	}
+
+	if os.Getuid() == 0 {
+		t.Skip("root can write to any directory")
+	}
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+	var errWrite *ErrNotWritable
+	if err := updater.Update(); !errors.As(err, &errWrite) || !os.IsPermission(errWrite.Err) {
+		t.Fatalf("Expected an *ErrNotWritable, got %v", err)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 2000)
+	updater := &Updater{}
+	if _, ok := updater.throttle(context.Background(), bytes.NewReader(data)).(*bytes.Reader); !ok {
+		t.Error("Expected no throttling without RateLimit")
+	}
+
+	updater.RateLimit = 10000
+	start := time.Now()
+	b, err := io.ReadAll(updater.throttle(context.Background(), bytes.NewReader(data)))
+	if err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("Unexpected read %d bytes, %v", len(b), err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("Expected 2000 bytes at 10000 bytes/s to take about 200ms, took %s", d)
+	}
+
+	// a slow download stops as soon as the context is done
+	updater.RateLimit = 10
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := io.ReadAll(updater.throttle(ctx, bytes.NewReader(data))); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to stop the download, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected the download to stop at the deadline, took %s", d)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updater := createUpdater(nil)
+	updater.Dir = "update-recover-test/"
+	t.Cleanup(func() { os.RemoveAll(updater.getExecRelativeDir(updater.Dir)) })
+	updater.TargetPath = path
+	sum := sha256.Sum256([]byte("new data"))
+	state := &installState{Version: "1.3", Path: path, Algo: "sha256", Sum: sum[:]}
+
+	// stopped before the swap: the new file is dropped
+	write(path, "old data")
+	write(newFilePath(path), "new da")
+	updater.saveInstallState(state)
+	if err := updater.Recover(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ := os.ReadFile(path)
+	equals(t, "old data", string(b))
+	if _, err := os.Stat(newFilePath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the new file to be removed, got %v", err)
+	}
+	equals(t, true, updater.readInstallState() == nil)
+
+	// stopped between the renames: the swap is completed
+	os.Rename(path, backupPath(path))
+	write(newFilePath(path), "new data")
+	updater.saveInstallState(state)
+	if err := updater.Recover(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ = os.ReadFile(path)
+	equals(t, "new data", string(b))
+	if s := updater.readInstallState(); s == nil || !s.Complete {
+		t.Errorf("Expected the install to be recorded as complete, got %+v", s)
+	}
+
+	// ... or reverted if the new file isn't the update
+	os.Rename(path, newFilePath(path))
+	write(newFilePath(path), "new da")
+	if err := updater.Recover(); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	b, _ = os.ReadFile(path)
+	equals(t, "old data", string(b))
+
+	// a damaged install of the running version is reported
+	write(path, "bad data")
+	state.Complete = true
+	updater.saveInstallState(state)
+	updater.CurrentVersion = "1.3"
+	if err := updater.Recover(); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+	updater.CurrentVersion = "1.2"
+	if err := updater.Recover(); err != nil {
+		t.Errorf("Expected a file replaced since not to be checked, got %v", err)
+	}
+
+	// with nothing left to restore from
+	os.Remove(path)
+	os.Remove(backupPath(path))
+	if err := updater.Recover(); err == nil {
+		t.Error("Expected an error for a missing file without backup")
+	}
+}
+
+func TestCachedBinVerified(t *testing.T) {
+	updater := createUpdater(nil)
+	updater.Dir = "update-cache-test/"
+	t.Cleanup(func() { os.RemoveAll(updater.getExecRelativeDir(updater.Dir)) })
+	updater.CacheDownloads = true
+	updater.Info.Version = "1.3"
+	sum := sha256.Sum256([]byte("new binary"))
+	updater.Info.Hash.Algo = "sha256"
+	updater.Info.Hash.Value = sum[:]
+
+	updater.cacheBin([]byte("new binary"))
+	equals(t, "new binary", string(updater.cachedBin()))
+	if err := os.WriteFile(updater.cachePath(), []byte("corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	equals(t, true, updater.cachedBin() == nil)
+	if _, err := os.Stat(updater.cachePath()); !os.IsNotExist(err) {
+		t.Errorf("Expected the corrupt binary to be removed, got %v", err)
+	}
+}
+
+func TestPollReportsEveryCheck(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"Version": "1.2", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+		})
+	// further checks fail as the mock runs out of responses
+	updater := createUpdater(mr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var results []CheckResult
+	err := updater.Poll(ctx, time.Millisecond, func(r CheckResult) {
+		results = append(results, r)
+		if len(results) == 3 {
+			cancel()
+		}
+	})
+	equals(t, context.Canceled, err)
+	equals(t, 3, len(results))
+	equals(t, CheckResult{}, results[0])
+	equals(t, true, results[1].Err != nil && results[2].Err != nil)
+	equals(t, "1.2", updater.CurrentVersion)
+}
+
+func TestPollNotOffered(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=", "Rollout": 0}`), nil
+		})
+	updater := createUpdater(mr)
+	updater.InstallID = "install"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var results []CheckResult
+	err := updater.Poll(ctx, time.Millisecond, func(r CheckResult) {
+		results = append(results, r)
+		cancel()
+	})
+	equals(t, context.Canceled, err)
+	// nothing was installed, so there is nothing to report
+	equals(t, 1, len(results))
+	equals(t, CheckResult{}, results[0])
+	equals(t, "1.2", updater.CurrentVersion)
+}
+
+func TestUpdateContextCanceled(t *testing.T) {
+	mr := &mockRequester{}
+	updater := createUpdater(mr)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	equals(t, context.Canceled, updater.UpdateContext(ctx))
+	equals(t, 0, mr.currentIndex)
+}
+
+func TestFetchBinCanceledWhileReading(t *testing.T) {
+	var full bytes.Buffer
+	gw := gzip.NewWriter(&full)
+	gw.Write(bytes.Repeat([]byte("new binary"), 1<<16))
+	gw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			// the download is interrupted after the response arrived
+			cancel()
+			return newTestReaderCloser(full.String()), nil
+		})
+	updater := createUpdater(mr)
+	updater.Info.Version = "1.3"
+
+	_, err := updater.fetchBin(ctx)
+	equals(t, true, errors.Is(err, context.Canceled))
+}
+
+func TestTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".json") {
+			// slow, but well within the default ManifestTimeout
+			time.Sleep(100 * time.Millisecond)
+			io.WriteString(w, `{"Version": "1.3", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`)
+			return
+		}
+		// the binary never arrives
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	updater := createUpdater(nil)
+	t.Cleanup(func() { os.RemoveAll(updater.getExecRelativeDir(updater.Dir)) })
+	updater.Requester = &HTTPRequester{Client: server.Client()}
+	updater.ApiURL, updater.BinURL = server.URL+"/", server.URL+"/"
+	updater.ManifestTimeout = 50 * time.Millisecond
+	if err := updater.fetchInfo(context.Background()); err == nil || !strings.Contains(err.Error(), "ManifestTimeout") {
+		t.Errorf("Expected the manifest to time out, got %v", err)
+	}
+
+	updater.ManifestTimeout = 0
+	updater.DownloadTimeout = 50 * time.Millisecond
+	if err := updater.fetchInfo(context.Background()); err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	if _, err := updater.fetchBin(context.Background()); err == nil || !strings.Contains(err.Error(), "DownloadTimeout") {
+		t.Errorf("Expected the download to time out, got %v", err)
+	}
+}
+
+func TestHTTPRequesterHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "manifest")
+	}))
+	defer server.Close()
+
+	if _, err := (&HTTPRequester{Client: server.Client()}).Fetch(server.URL); err == nil {
+		t.Errorf("Expected an error without the Authorization header")
+	}
+	r, err := (&HTTPRequester{
+		Client: server.Client(),
+		Header: http.Header{"Authorization": {"Bearer secret"}},
+	}).Fetch(server.URL)
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	defer r.Close()
+	b, _ := io.ReadAll(r)
+	equals(t, "manifest", string(b))
+}
+
+func TestConditionalManifestFetch(t *testing.T) {
+	sum := sha256.Sum256([]byte("new binary"))
+	var (
+		version   = "1.2"
+		full, not int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + version + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			not++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode(map[string]interface{}{"Version": version, "Sha256": sum[:]})
+	}))
+	defer server.Close()
+
+	updater := createUpdater(nil)
+	updater.ApiURL = server.URL + "/"
+	updater.Requester = &HTTPRequester{Client: server.Client()}
+	check := func(want string, wantFull, wantNot int) {
+		t.Helper()
+		if v, err := updater.UpdateAvailable(); err != nil || v != want {
+			t.Fatalf("UpdateAvailable() = %q, %v; want %q", v, err, want)
+		}
+		if full != wantFull || not != wantNot {
+			t.Errorf("Got %d full and %d not modified responses; want %d and %d", full, not, wantFull, wantNot)
+		}
+	}
+	check("", 1, 0)
+	check("", 1, 1) // unchanged, so no update without downloading it
+	version = "1.3"
+	check("1.3", 2, 1)
+	check("1.3", 3, 1) // an update is always fetched in full
+
+	// kept across runs in ManifestCachePath
+	version = "1.2"
+	updater.ManifestCachePath = filepath.Join(t.TempDir(), "manifest-cache.json")
+	check("", 4, 1)
+	updater = &Updater{CurrentVersion: "1.2", ApiURL: updater.ApiURL, CmdName: "myapp", Requester: updater.Requester, ManifestCachePath: updater.ManifestCachePath}
+	check("", 4, 2)
+	// but not for another running version
+	updater.CurrentVersion = "1.1"
+	check("1.2", 5, 2)
+}
+
+func TestDownloadRetries(t *testing.T) {
+	unavailable := func(url string) (io.ReadCloser, error) {
+		return nil, &StatusError{URL: url, StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+	}
+	mr := &mockRequester{}
+	mr.handleRequest(unavailable)
+	mr.handleRequest(unavailable)
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser("manifest"), nil
+		})
+	updater := createUpdater(mr)
+	updater.MaxAttempts = 3
+	updater.RetryDelay = time.Millisecond
+
+	b, err := updater.download(context.Background(), "http://updates.yourdomain.com/", "myapp/linux-amd64.json", "none", nil)
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "manifest", string(b))
+	equals(t, 3, mr.currentIndex)
+
+	// out of attempts
+	mr = &mockRequester{}
+	mr.handleRequest(unavailable)
+	mr.handleRequest(unavailable)
+	updater.Requester = mr
+	updater.MaxAttempts = 2
+	_, err = updater.download(context.Background(), "http://updates.yourdomain.com/", "myapp/linux-amd64.json", "none", nil)
+	var status *StatusError
+	equals(t, true, errors.As(err, &status))
+	equals(t, 2, mr.currentIndex)
+}
+
+func TestDownloadDoesNotRetryClientErrors(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, &StatusError{URL: url, StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+		})
+	updater := createUpdater(mr)
+	updater.MaxAttempts = 3
+	updater.RetryDelay = time.Millisecond
+
+	_, err := updater.download(context.Background(), "http://updates.yourdomain.com/", "myapp/linux-amd64.json", "none", nil)
+	equals(t, true, err != nil)
+	equals(t, 1, mr.currentIndex)
+}
+
+func TestDownloadRespectsDeadline(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, io.ErrUnexpectedEOF
+		})
+	updater := createUpdater(mr)
+	updater.MaxAttempts = 3
+	updater.RetryDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := updater.download(ctx, "http://updates.yourdomain.com/", "myapp/linux-amd64.json", "none", nil)
+	equals(t, io.ErrUnexpectedEOF, err)
+	equals(t, 1, mr.currentIndex)
+}
+
+// flakyRangeRequester serves content, failing every download after limit
+// bytes.
+type flakyRangeRequester struct {
+	content []byte
+	limit   int
+	ranges  bool
+	offsets []int64
+	mockRequester
+}
+
+func (r *flakyRangeRequester) FetchRange(ctx context.Context, url string, offset int64) (io.ReadCloser, bool, error) {
+	r.offsets = append(r.offsets, offset)
+	if !r.ranges {
+		offset = 0
+	}
+	rest := r.content[offset:]
+	if len(rest) > r.limit {
+		return io.NopCloser(io.MultiReader(bytes.NewReader(rest[:r.limit]), iotest.ErrReader(io.ErrUnexpectedEOF))), r.ranges, nil
+	}
+	return io.NopCloser(bytes.NewReader(rest)), r.ranges, nil
+}
+
+func TestDownloadResumable(t *testing.T) {
+	var full bytes.Buffer
+	gw := gzip.NewWriter(&full)
+	gw.Write(bytes.Repeat([]byte("new binary "), 1000))
+	gw.Close()
+
+	for _, ranges := range []bool{true, false} {
+		partPath := filepath.Join(t.TempDir(), "linux-amd64.gz.part")
+		rr := &flakyRangeRequester{content: full.Bytes(), limit: full.Len() / 3, ranges: ranges}
+		updater := createUpdater(nil)
+		updater.MaxAttempts = 5
+		updater.RetryDelay = time.Millisecond
+
+		bin, err := updater.downloadResumable(context.Background(), rr, "http://updates.yourdownmain.com/", "myapp/1.3/linux-amd64.gz", partPath, "gzip", nil)
+		if ranges {
+			if err != nil {
+				t.Fatalf("Error occurred: %#v", err)
+			}
+			equals(t, string(bytes.Repeat([]byte("new binary "), 1000)), string(bin))
+			equals(t, 3, len(rr.offsets))
+			equals(t, int64(rr.limit), rr.offsets[1])
+			if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+				t.Errorf("Expected the part file to be removed, got %v", err)
+			}
+		} else {
+			// without ranges every attempt starts over and fails the same way
+			equals(t, io.ErrUnexpectedEOF, err)
+			equals(t, 5, len(rr.offsets))
+			fi, err := os.Stat(partPath)
+			equals(t, nil, err)
+			equals(t, int64(rr.limit), fi.Size())
+		}
+	}
+}
+
+func TestHTTPRequesterFetchRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "linux-amd64.gz", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer server.Close()
+	requester := &HTTPRequester{Client: server.Client()}
+
+	for _, tt := range []struct {
+		offset  int64
+		partial bool
+		body    string
+	}{
+		{0, false, "0123456789"},
+		{4, true, "456789"},
+		{20, false, "0123456789"}, // past the end
+	} {
+		r, partial, err := requester.FetchRange(context.Background(), server.URL, tt.offset)
+		if err != nil {
+			t.Fatalf("Error occurred: %#v", err)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		equals(t, tt.partial, partial)
+		equals(t, tt.body, string(b))
+	}
+}
+
+func TestDownloadFailsOverToMirror(t *testing.T) {
+	mr := &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdomain.com/myapp/linux-amd64.json", url)
+			return nil, &StatusError{URL: url, StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}
+		})
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			equals(t, "http://mirror.example.com/myapp/linux-amd64.json", url)
+			return newTestReaderCloser("manifest"), nil
+		})
+	updater := createUpdater(mr)
+	updater.Mirrors = []string{"http://mirror.example.com/"}
+
+	b, err := updater.download(context.Background(), updater.ApiURL, "myapp/linux-amd64.json", "none", nil)
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, "manifest", string(b))
+	equals(t, "http://mirror.example.com/", updater.Mirror)
+
+	// a missing file isn't a reason to ask a mirror
+	mr = &mockRequester{}
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return nil, &StatusError{URL: url, StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+		})
+	updater.Requester = mr
+	_, err = updater.download(context.Background(), updater.ApiURL, "myapp/linux-amd64.json", "none", nil)
+	equals(t, true, err != nil)
+	equals(t, 1, mr.currentIndex)
+}
+
+// filesRequester serves files by URL, and 404 for anything else.
+type filesRequester map[string][]byte
+
+func (r filesRequester) Fetch(url string) (io.ReadCloser, error) {
+	b, ok := r[url]
+	if !ok {
+		return nil, &StatusError{URL: url, StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	}
+	return newTestReaderCloser(string(b)), nil
+}
+
+func TestFetchUpdatePatchChain(t *testing.T) {
+	bins := map[string][]byte{}
+	for _, v := range []string{"1.0", "1.1", "1.2", "1.3"} {
+		bins[v] = bytes.Repeat([]byte("binary "+v+" "), 100)
+	}
+	files := filesRequester{}
+	// -diff-depth 1: each version only has a patch from the one before
+	for _, step := range [][2]string{{"1.0", "1.1"}, {"1.1", "1.2"}, {"1.2", "1.3"}} {
+		from, to := step[0], step[1]
+		var patch bytes.Buffer
+		if err := binarydist.Diff(bytes.NewReader(bins[from]), bytes.NewReader(bins[to]), &patch); err != nil {
+			t.Fatal(err)
+		}
+		files["http://updates.yourdomain.com/myapp/"+from+"/"+to+"/"+plat] = patch.Bytes()
+		sum := sha256.Sum256(bins[to])
+		patchSum := sha256.Sum256(patch.Bytes())
+		index, _ := json.Marshal(map[string]interface{}{
+			"Version": to,
+			"Hash":    map[string]interface{}{"Algo": "sha256", "Value": sum[:]},
+			"Patches": []map[string]interface{}{{"From": from, "Length": patch.Len(), "Sha256": patchSum[:]}},
+		})
+		files["http://updates.yourdomain.com/myapp/"+to+"/"+plat+".patches.json"] = index
+	}
+
+	updater := createUpdater(nil)
+	updater.Requester = files
+	updater.CurrentVersion = "1.0"
+	updater.Info.Version = "1.3"
+	updater.Info.PatchLengths = map[string]int64{"1.2": 1}
+	sum := sha256.Sum256(bins["1.3"])
+	updater.Info.Hash.Algo = "sha256"
+	updater.Info.Hash.Value = sum[:]
+
+	chain, err := updater.planPatchChain(context.Background())
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, 3, len(chain))
+	bin, err := updater.fetchUpdate(context.Background(), bytes.NewReader(bins["1.0"]))
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, string(bins["1.3"]), string(bin))
+
+	// a chain isn't worth it if the full binary is smaller
+	updater.Info.CompressedLength = 10
+	chain, _ = updater.planPatchChain(context.Background())
+	equals(t, 0, len(chain))
+	updater.Info.CompressedLength = 0
+
+	// a patch not matching its checksum is caught before it is applied
+	files["http://updates.yourdomain.com/myapp/1.1/1.2/"+plat] = files["http://updates.yourdomain.com/myapp/1.2/1.3/"+plat]
+	_, err = updater.fetchAndVerifyPatchChain(context.Background(), bytes.NewReader(bins["1.0"]))
+	var mismatch *ErrPatchChecksumMismatch
+	equals(t, true, errors.As(err, &mismatch))
+	equals(t, false, errors.Is(err, ErrHashMismatch))
+	equals(t, true, strings.HasPrefix(err.Error(), "downloaded patch from 1.1 to 1.2"))
+
+	// and without one, the intermediate binary not matching its checksum
+	// breaks the chain
+	sum = sha256.Sum256(bins["1.2"])
+	files["http://updates.yourdomain.com/myapp/1.2/"+plat+".patches.json"], _ = json.Marshal(map[string]interface{}{
+		"Version": "1.2",
+		"Hash":    map[string]interface{}{"Algo": "sha256", "Value": sum[:]},
+		"Patches": []map[string]interface{}{{"From": "1.1", "Length": 1}},
+	})
+	_, err = updater.fetchAndVerifyPatchChain(context.Background(), bytes.NewReader(bins["1.0"]))
+	equals(t, true, strings.HasPrefix(err.Error(), "patching 1.1 to 1.2"))
+}
+
+func TestWantVersion(t *testing.T) {
+	for _, tt := range []struct {
+		current, available string
+		allowDowngrade     bool
+		want               bool
+	}{
+		{"1.2.0", "1.3.0", false, true},
+		{"1.2.0", "1.2.0", false, false},
+		{"1.10.0", "1.9.0", false, false},
+		{"1.10.0", "1.9.0", true, true},
+		{"v1.2.0", "1.2.0+build", true, true},
+		{"2.0.0-rc.1", "2.0.0", false, true},
+		{"2023-07-09-66c6c12", "2023-07-10-0a1b2c3", false, true},
+	} {
+		updater := createUpdater(nil)
+		updater.CurrentVersion = tt.current
+		updater.Info.Version = tt.available
+		updater.AllowDowngrade = tt.allowDowngrade
+		if got := updater.wantVersion(); got != tt.want {
+			t.Errorf("wantVersion() from %s to %s (AllowDowngrade %v) = %v, want %v", tt.current, tt.available, tt.allowDowngrade, got, tt.want)
+		}
+	}
+
+	updater := createUpdater(nil)
+	updater.CurrentVersion = "build-20"
+	updater.Info.Version = "build-3"
+	updater.IsNewer = func(current, available string) bool {
+		c, _ := strconv.Atoi(strings.TrimPrefix(current, "build-"))
+		a, _ := strconv.Atoi(strings.TrimPrefix(available, "build-"))
+		return a > c
+	}
+	equals(t, false, updater.wantVersion())
+}
+
+func TestCheckForUpdate(t *testing.T) {
+	manifest := func(version string) func(string) (io.ReadCloser, error) {
+		return func(url string) (io.ReadCloser, error) {
+			equals(t, "http://updates.yourdomain.com/myapp/"+plat+".json", url)
+			return newTestReaderCloser(`{
+    "Version": "` + version + `",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "CompressedLength": 5000,
+    "PatchLengths": {"1.2": 300}
+}`), nil
+		}
+	}
+	mr := &mockRequester{}
+	mr.handleRequest(manifest("1.3"))
+	mr.handleRequest(manifest("1.2"))
+	updater := createUpdater(mr)
+
+	r, err := updater.CheckForUpdate()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, Release{Version: "1.3", Size: 300, HasPatch: true, PatchSize: 300, FullSize: 5000}, *r)
+	equals(t, 1, mr.currentIndex)
+
+	r, err = updater.CheckForUpdate()
+	if err != nil || r != nil {
+		t.Errorf("Expected no release for the running version, got %+v %v", r, err)
+	}
+
+	// fields missing from a later manifest don't linger
+	mr.handleRequest(
+		func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{"Version": "1.4", "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02="}`), nil
+		})
+	r, err = updater.CheckForUpdate()
+	if err != nil {
+		t.Fatalf("Error occurred: %#v", err)
+	}
+	equals(t, Release{Version: "1.4"}, *r)
+}
+
+func TestRollout(t *testing.T) {
+	manifest := func(rollout int) func(string) (io.ReadCloser, error) {
+		return func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(fmt.Sprintf(`{
+    "Version": "1.3",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "Rollout": %d
+}`, rollout)), nil
+		}
+	}
+	mr := &mockRequester{}
+	updater := createUpdater(mr)
+	updater.InstallID = "install-1"
+	bucket := rolloutBucket(updater.InstallID)
+	for _, rollout := range []int{0, bucket, bucket + 1, 100} {
+		mr.handleRequest(manifest(rollout))
+		version, err := updater.UpdateAvailable()
+		if err != nil {
+			t.Fatalf("Error occurred: %#v", err)
+		}
+		if want := rollout > bucket; (version == "1.3") != want {
+			t.Errorf("Rollout %d with bucket %d offered %q; want offered %v", rollout, bucket, version, want)
+		}
+	}
+
+	// without an InstallID one is generated once and kept
+	t.Cleanup(func() { os.RemoveAll(updater.getExecRelativeDir(updater.Dir)) })
+	updater.InstallID = ""
+	id, err := updater.installID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := updater.installID()
+	if err != nil || again != id || len(id) != 32 {
+		t.Errorf("Expected the install ID %q to be kept, got %q %v", id, again, err)
+	}
+}
+
+func TestMinFromVersion(t *testing.T) {
+	mr := &mockRequester{}
+	updater := createUpdater(mr)
+	for _, tc := range []struct {
+		current string
+		want    string // version offered, or that of the intermediate update
+		tooOld  bool
+	}{
+		{"1.0.0", "1.5.0", true},
+		{"1.5.0", "2.0.0", false},
+		{"1.6.0", "2.0.0", false},
+		{"nightly", "2.0.0", false}, // can't be ordered
+	} {
+		mr.handleRequest(func(url string) (io.ReadCloser, error) {
+			return newTestReaderCloser(`{
+    "Version": "2.0.0",
+    "Sha256": "Q2vvTOW0p69A37StVANN+/ko1ZQDTElomq7fVcex/02=",
+    "MinFromVersion": "1.5.0"
+}`), nil
+		})
+		updater.CurrentVersion = tc.current
+		version, err := updater.UpdateAvailable()
+		var minErr *ErrMinFromVersion
+		if tc.tooOld {
+			if !errors.As(err, &minErr) || minErr.MinFromVersion != tc.want || minErr.Current != tc.current {
+				t.Errorf("From %s: expected an *ErrMinFromVersion to update to %s first, got %v", tc.current, tc.want, err)
+			}
+			continue
+		}
+		if err != nil || version != tc.want {
+			t.Errorf("From %s: expected %s to be offered, got %q %v", tc.current, tc.want, version, err)
+		}
+	}
+}
+
+func TestRestart(t *testing.T) {
+	switch os.Getenv("SELFUPDATE_TEST_RESTART") {
+	case "child":
+		os.Setenv("SELFUPDATE_TEST_RESTART", "restarted")
+		fmt.Println("restart failed:", Restart())
+		os.Exit(1)
+	case "restarted":
+		fmt.Print("restarted with ", strings.Join(os.Args[1:], " "))
+		os.Exit(0)
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("the restarted process would outlive the test")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRestart$")
+	cmd.Env = append(os.Environ(), "SELFUPDATE_TEST_RESTART=child")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Error occurred: %v, output %q", err, out)
+	}
+	equals(t, "restarted with -test.run=^TestRestart$", string(out))
+}
@@ -0,0 +1,157 @@
+package selfupdate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares a and b as semantic versions, returning -1, 0 or
+// 1 if a is lower, equal or higher than b. ok is false, and c meaningless,
+// unless both are semantic versions.
+func CompareVersions(a, b string) (c int, ok bool) {
+	av, ok := parseSemver(a)
+	if !ok {
+		return 0, false
+	}
+	bv, ok := parseSemver(b)
+	if !ok {
+		return 0, false
+	}
+	return av.compare(bv), true
+}
+
+// IsSemver reports whether v is a semantic version (https://semver.org)
+// like 1.2.3 or v1.2.3-rc.1.
+func IsSemver(v string) bool {
+	_, ok := parseSemver(v)
+	return ok
+}
+
+// semver is a parsed semantic version (https://semver.org). A leading "v"
+// is accepted and build metadata is ignored for ordering.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses s as MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD].
+func parseSemver(s string) (semver, bool) {
+	var v semver
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		if !validIdents(s[i+1:], false) {
+			return v, false
+		}
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		if !validIdents(s[i+1:], true) {
+			return v, false
+		}
+		v.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		if !isNumeric(p) || (len(p) > 1 && p[0] == '0') {
+			return v, false
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return v, false
+		}
+		nums[i] = n
+	}
+	v.major, v.minor, v.patch = nums[0], nums[1], nums[2]
+	return v, true
+}
+
+// compare returns -1, 0 or 1 if v is lower, equal or higher than o.
+func (v semver) compare(o semver) int {
+	if c := compareInt(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.patch, o.patch); c != 0 {
+		return c
+	}
+	// a version without prerelease has higher precedence
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, b := v.pre[i], o.pre[i]
+		if a == b {
+			continue
+		}
+		an, bn := isNumeric(a), isNumeric(b)
+		switch {
+		case an && bn:
+			x, _ := strconv.Atoi(a)
+			y, _ := strconv.Atoi(b)
+			return compareInt(x, y)
+		case an:
+			return -1
+		case bn:
+			return 1
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareInt(len(v.pre), len(o.pre))
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validIdents reports whether s is a dot separated list of non-empty
+// alphanumeric identifiers. Prerelease identifiers additionally may not
+// have leading zeros when numeric.
+func validIdents(s string, prerelease bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+		if prerelease && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package selfupdate
+
+import "testing"
+
+func TestParseSemver(t *testing.T) {
+	for _, s := range []string{"1.2.3", "v1.2.3", "0.0.1-alpha.1", "1.0.0+build.5", "1.0.0-rc.1+abc"} {
+		if _, ok := parseSemver(s); !ok {
+			t.Errorf("parseSemver(%q) should succeed", s)
+		}
+	}
+	for _, s := range []string{"", "1.2", "v1..2", "1.2.3.4", "01.2.3", "1.2.3-", "1.2.3-01", "1.2.x"} {
+		if _, ok := parseSemver(s); ok {
+			t.Errorf("parseSemver(%q) should fail", s)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "2.0.0"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := parseSemver(ordered[i])
+		b, _ := parseSemver(ordered[i+1])
+		if a.compare(b) != -1 || b.compare(a) != 1 {
+			t.Errorf("expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+	a, _ := parseSemver("v1.0.0+a")
+	b, _ := parseSemver("1.0.0+b")
+	if a.compare(b) != 0 {
+		t.Errorf("build metadata should not affect ordering")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		c    int
+		ok   bool
+	}{
+		{"1.2.0", "1.10.0", -1, true},
+		{"v2.0.0", "2.0.0-rc.1", 1, true},
+		{"1.0.0+a", "1.0.0+b", 0, true},
+		{"1.2.0", "nightly", 0, false},
+		{"2023-07-09", "1.2.0", 0, false},
+	} {
+		c, ok := CompareVersions(tt.a, tt.b)
+		if c != tt.c || ok != tt.ok {
+			t.Errorf("CompareVersions(%q, %q) = %d, %v, want %d, %v", tt.a, tt.b, c, ok, tt.c, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"net/http"
+)
+
+// SignatureError is returned when PublicKey is set and a manifest or the
+// checksum of its binary isn't signed by it. Nothing of such a release is
+// downloaded, let alone installed.
+type SignatureError struct {
+	Reason string
+}
+
+func (e *SignatureError) Error() string {
+	return "signature verification failed: " + e.Reason
+}
+
+// ParsePublicKey parses a PEM encoded ed25519 public key, as written to
+// the .pub file by `go-selfupdate -keygen`, for PublicKey.
+func ParsePublicKey(b []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ed25519 public key")
+	}
+	return pub, nil
+}
+
+// verifyManifest checks the detached signature of the manifest bytes b,
+// fetched from sigPath next to it.
+func (u *Updater) verifyManifest(ctx context.Context, sigPath string, b []byte) error {
+	if len(u.PublicKey) != ed25519.PublicKeySize {
+		return &SignatureError{Reason: "invalid public key"}
+	}
+	sig, err := u.downloadManifest(ctx, u.ApiURL, sigPath)
+	var status *StatusError
+	if errors.Is(err, fs.ErrNotExist) || errors.As(err, &status) && status.StatusCode == http.StatusNotFound {
+		return &SignatureError{Reason: "manifest is not signed"}
+	}
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(u.PublicKey, b, sig) {
+		return &SignatureError{Reason: "manifest signature doesn't match"}
+	}
+	return nil
+}
+
+// verifyInfo checks the signature of the binary's checksum in Info, which
+// every patched or downloaded binary is verified against.
+func (u *Updater) verifyInfo() error {
+	if len(u.Info.Signature) == 0 {
+		return &SignatureError{Reason: "binary checksum is not signed"}
+	}
+	sum := sha256.Sum256(u.PublicKey)
+	if id := hex.EncodeToString(sum[:8]); u.Info.KeyID != "" && u.Info.KeyID != id {
+		return &SignatureError{Reason: "signed by key " + u.Info.KeyID + ", not " + id}
+	}
+	msg := append([]byte(u.Info.Hash.Algo+":"), u.Info.Hash.Value...)
+	if !ed25519.Verify(u.PublicKey, msg, u.Info.Signature) {
+		return &SignatureError{Reason: "binary checksum signature doesn't match"}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package selfupdate
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttle returns r limited to RateLimit, or r itself if there is no
+// limit.
+func (u *Updater) throttle(ctx context.Context, r io.Reader) io.Reader {
+	if u.RateLimit <= 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, rate: u.RateLimit}
+}
+
+// throttledReader reads from r at no more than rate bytes per second on
+// average since its first read, sleeping after a read until the bytes
+// read so far are due. The sleep ends early when ctx is done.
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64 // bytes read since start
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	// read about a tenth of a second's worth at a time, so the rate stays
+	// even rather than arriving in bursts of the buffer size
+	if max := max(t.rate/10, 1); int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		}
+	}
+	return n, err
+}